@@ -1,61 +1,280 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/vedadiyan/iceberg/internal/bootstrap"
 	"github.com/vedadiyan/iceberg/internal/bootstrap/parser"
 	"github.com/vedadiyan/iceberg/internal/bootstrap/server"
+	"github.com/vedadiyan/iceberg/internal/callers/filters"
 	"github.com/vedadiyan/iceberg/internal/callers/proxies"
 	"github.com/vedadiyan/iceberg/internal/common/netio"
+	"github.com/vedadiyan/iceberg/internal/common/retry"
+	"github.com/vedadiyan/iceberg/internal/common/router"
+	"github.com/vedadiyan/iceberg/internal/common/watch"
+	"github.com/vedadiyan/iceberg/internal/middleware/cache"
+	"github.com/vedadiyan/iceberg/internal/middleware/opa"
 )
 
-const test = `
-package example
-
-import rego.v1
+type (
+	// App is a loaded configuration. Health and Released are handed on from
+	// the app a reload replaces, while every app gets its own retry budget.
+	App struct {
+		Listen        parser.ListenV1
+		Listener      *server.Listener
+		Admin         *parser.ListenV1
+		AdminListener *server.Listener
+		RouteTable    *router.RouteTable
+		Handlers      []proxies.Handler
+		Budget        *retry.Budget
+		Health        *proxies.Health
+		Released      *proxies.Released
+	}
+)
 
-default allow := false
+// Build loads config. current is the running app, if any.
+func Build(config []byte, current *App) (*App, error) {
+	_, _, specs, err := parser.Parse(config)
+	if err != nil {
+		return nil, err
+	}
+	specsV1 := specs.(*parser.SpecV1)
+	listener, err := parser.ParseListenV1(specsV1.Listen)
+	if err != nil {
+		return nil, err
+	}
+	app := new(App)
+	app.Listen = specsV1.Listen
+	app.Listener = listener
+	if specsV1.Admin != nil {
+		adminListener, err := parser.ParseListenV1(*specsV1.Admin)
+		if err != nil {
+			return nil, err
+		}
+		app.Admin = specsV1.Admin
+		app.AdminListener = adminListener
+	}
+	app.RouteTable = router.NewRouteTable()
+	app.Handlers = make([]proxies.Handler, 0)
+	app.Budget = parser.ParseRetryBudgetV1(specsV1.RetryBudget)
+	app.Health = proxies.NewHealth()
+	app.Released = proxies.NewReleased()
+	if current != nil {
+		app.Health = current.Health
+		app.Released = current.Released
+	}
+	err = parser.ParseV1(specsV1.Resources, app.Budget, func(p *proxies.Proxy, pattern string, methods []string, opts ...bootstrap.RegistrationOptions) error {
+		p.Health = app.Health
+		p.Released = app.Released
+		proxy, err := proxies.NewProxy(p)
+		if err != nil {
+			return errors.Join(err, netio.Close(p.Callers...))
+		}
+		app.Handlers = append(app.Handlers, proxy)
+		return server.HandleFunc(app.RouteTable, pattern, methods, func(w http.ResponseWriter, r *http.Request, rv bootstrap.RouteValues) {
+			proxy.Handle(w, r, netio.RouteValues(rv))
+		}, append(opts, bootstrap.WithMetadata(proxy))...)
+	})
+	if err != nil {
+		return nil, errors.Join(err, app.Close())
+	}
+	return app, nil
+}
 
-# METADATA
-# title: test
-allow if {
-	1 == 1
+// Release frees the handlers of an app replaced by a reload.
+func (app *App) Release() error {
+	errs := make([]error, 0)
+	for _, handler := range app.Handlers {
+		errs = append(errs, handler.Release())
+	}
+	return errors.Join(errs...)
 }
-`
 
-func main() {
-	os.Setenv("test-policy", test)
+func (app *App) Close() error {
+	errs := make([]error, 0)
+	for _, handler := range app.Handlers {
+		errs = append(errs, handler.Close())
+	}
+	return errors.Join(errs...)
+}
 
-	data, err := os.ReadFile("../examples/test.yml")
+func Reload(srv *server.Server, current *App, config []byte) (*App, error) {
+	app, err := Build(config, current)
 	if err != nil {
-		log.Fatalln(err)
+		return current, err
+	}
+	if !reflect.DeepEqual(app.Listen, current.Listen) {
+		log.Println("listen settings changed, restart required to take effect")
+		app.Listen = current.Listen
+		app.Listener = current.Listener
 	}
-	os.Setenv("ICERBERG_CONFIG", string(data))
+	if !reflect.DeepEqual(app.Admin, current.Admin) {
+		log.Println("admin settings changed, restart required to take effect")
+		app.Admin = current.Admin
+		app.AdminListener = current.AdminListener
+	}
+	retired := srv.Swap(app.RouteTable)
+	gracePeriod := app.Listener.GracePeriod
+	netio.Go(func() {
+		select {
+		case <-retired:
+		case <-time.After(gracePeriod):
+		}
+		err := current.Release()
+		if err != nil {
+			log.Println(err)
+		}
+	})
+	return app, nil
+}
 
-	config := os.Getenv("ICERBERG_CONFIG")
-	_, _, specs, err := parser.Parse([]byte(config))
+func Interval() time.Duration {
+	value := os.Getenv("ICEBERG_CONFIG_INTERVAL")
+	if len(value) == 0 {
+		return 0
+	}
+	interval, err := parser.Timeout(value)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	specsV1 := specs.(*parser.SpecV1)
-	err = parser.ParseV1(specsV1.Resources, func(u *url.URL, pattern string, method string, c []netio.Caller, opts ...bootstrap.RegistrationOptions) {
-		proxy, err := proxies.NewProxy(u, c)
+	return interval
+}
+
+func Validate(files []string) int {
+	type source struct {
+		name string
+		data []byte
+	}
+	sources := make([]source, 0)
+	for _, file := range files {
+		data, err := watch.Read(file)
 		if err != nil {
-			log.Fatalln(err)
+			fmt.Fprintln(os.Stderr, err)
+			return 2
 		}
-		err = server.HandleFunc(pattern, method, func(w http.ResponseWriter, r *http.Request, rv bootstrap.RouteValues) {
-			proxy.Handle(w, r, netio.RouteValues(rv))
-		}, opts...)
+		sources = append(sources, source{name: file, data: data})
+	}
+	if len(files) == 0 {
+		if path := os.Getenv("ICEBERG_CONFIG_FILE"); len(path) != 0 {
+			return Validate([]string{path})
+		}
+		sources = append(sources, source{name: "ICEBERG_CONFIG", data: []byte(os.Getenv("ICEBERG_CONFIG"))})
+	}
+	code := 0
+	for _, source := range sources {
+		for _, err := range parser.Validate(source.data) {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", source.name, err.Error())
+			code = 1
+		}
+	}
+	return code
+}
+
+func Shutdown(srv *server.Server, app *App, gracePeriod time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	errs := make([]error, 0)
+	errs = append(errs, srv.Shutdown(ctx))
+	errs = append(errs, netio.Wait(ctx))
+	errs = append(errs, app.Close())
+	errs = append(errs, app.Released.Close())
+	errs = append(errs, netio.Wait(ctx))
+	errs = append(errs, srv.ShutdownAdmin(ctx))
+	return errors.Join(errs...)
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(Validate(os.Args[2:]))
+	}
+	var (
+		config  []byte
+		watcher *watch.Watcher
+		mut     sync.Mutex
+	)
+	if path := os.Getenv("ICEBERG_CONFIG_FILE"); len(path) != 0 {
+		watcher = watch.New(path, Interval())
+		data, err := watcher.Read()
 		if err != nil {
 			log.Fatalln(err)
 		}
-	})
+		config = data
+	} else {
+		config = []byte(os.Getenv("ICEBERG_CONFIG"))
+	}
+	app, err := Build(config, nil)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	server.ListenAndServe(specsV1.Listen)
+	srv := server.New(app.RouteTable)
+	listener := app.Listener
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	if watcher != nil {
+		go watcher.Watch(ctx, func(data []byte) {
+			mut.Lock()
+			defer mut.Unlock()
+			if ctx.Err() != nil {
+				return
+			}
+			current, err := Reload(srv, app, data)
+			if err != nil {
+				log.Println("reload failed:", err)
+				return
+			}
+			app = current
+			log.Println("configuration reloaded")
+		}, func(err error) {
+			log.Println("reload failed:", err)
+		})
+	}
+	if app.AdminListener != nil {
+		adminListener := app.AdminListener
+		go func() {
+			err := srv.ServeAdmin(adminListener, map[string]server.Check{
+				"nats:filters": func(context.Context) error { return filters.Check() },
+				"nats:cache":   func(context.Context) error { return cache.Check() },
+				"nats:opa":     func(context.Context) error { return opa.Check() },
+			})
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}()
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe(listener)
+	}()
+	select {
+	case err := <-errCh:
+		{
+			if err != nil {
+				log.Fatalln(err)
+			}
+			return
+		}
+	case <-ctx.Done():
+	}
+	stop()
+	log.Println("shutting down, waiting up to", listener.GracePeriod, "for in-flight requests")
+	mut.Lock()
+	defer mut.Unlock()
+	err = Shutdown(srv, app, listener.GracePeriod)
+	if err != nil {
+		log.Println(err)
+	}
+	err = <-errCh
+	if err != nil {
+		log.Println(err)
+	}
 }
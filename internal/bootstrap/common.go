@@ -1,8 +1,12 @@
 package bootstrap
 
 import (
+	"context"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/vedadiyan/iceberg/internal/common/router"
 )
@@ -11,42 +15,273 @@ type (
 	RouteValues         = router.RouteValues
 	RegistrationOptions func(*Options, *router.RouteTable, *url.URL, func(w http.ResponseWriter, r *http.Request, rv RouteValues))
 	Options             struct {
-		Cors          bool
-		ExposeHeaders string
+		CORS       *CORS
+		Timeouts   *Timeouts
+		Predicates *router.Predicates
+		Default    bool
+		Metadata   any
+	}
+	Timeouts struct {
+		Request time.Duration
+		Read    time.Duration
+		Write   time.Duration
 	}
 	CORS struct {
-		AllowedOrigins string
-		AllowedHeaders string
-		AllowedMethods string
-		MaxAge         string
-		ExposedHeaders string
+		AllowedOrigins   []string
+		AllowedHeaders   []string
+		AllowedMethods   []string
+		ExposedHeaders   []string
+		MaxAge           int
+		AllowCredentials bool
 	}
 )
 
-func WithCORSDisabled() RegistrationOptions {
-	return func(opt *Options, rt *router.RouteTable, u *url.URL, f func(w http.ResponseWriter, r *http.Request, rv RouteValues)) {
-		opt.Cors = true
-		opt.ExposeHeaders = "*"
-		rt.Register(u, "OPTIONS", func(w http.ResponseWriter, r *http.Request, rv router.RouteValues) {
-			w.Header().Add("access-control-allow-origin", "*")
-			w.Header().Add("access-control-allow-headers", "*")
-			w.Header().Add("access-control-max-age", "3628800")
-			w.Header().Add("access-control-allow-methods", "GET, DELETE, OPTIONS, POST, PUT")
-			w.WriteHeader(200)
-		})
+const (
+	NO_DEADLINE time.Duration = -1
+)
+
+var (
+	_defaultMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+)
+
+func WithMetadata(metadata any) RegistrationOptions {
+	return func(opt *Options, _ *router.RouteTable, _ *url.URL, _ func(w http.ResponseWriter, r *http.Request, rv RouteValues)) {
+		opt.Metadata = metadata
+	}
+}
+
+func WithPredicates(predicates *router.Predicates) RegistrationOptions {
+	return func(opt *Options, _ *router.RouteTable, _ *url.URL, _ func(w http.ResponseWriter, r *http.Request, rv RouteValues)) {
+		opt.Predicates = predicates
 	}
 }
 
+func WithDefault() RegistrationOptions {
+	return func(opt *Options, _ *router.RouteTable, _ *url.URL, _ func(w http.ResponseWriter, r *http.Request, rv RouteValues)) {
+		opt.Default = true
+	}
+}
+
+func WithTimeouts(timeouts *Timeouts) RegistrationOptions {
+	return func(opt *Options, _ *router.RouteTable, _ *url.URL, _ func(w http.ResponseWriter, r *http.Request, rv RouteValues)) {
+		opt.Timeouts = timeouts
+	}
+}
+
+func WithCORSDisabled() RegistrationOptions {
+	return WithCORS(&CORS{
+		AllowedOrigins: []string{"*"},
+		AllowedHeaders: []string{"*"},
+		AllowedMethods: _defaultMethods,
+		ExposedHeaders: []string{"*"},
+		MaxAge:         3628800,
+	})
+}
+
 func WithCORS(cors *CORS) RegistrationOptions {
-	return func(opt *Options, rt *router.RouteTable, u *url.URL, f func(w http.ResponseWriter, r *http.Request, rv RouteValues)) {
-		opt.Cors = true
-		opt.ExposeHeaders = cors.ExposedHeaders
-		rt.Register(u, "OPTIONS", func(w http.ResponseWriter, r *http.Request, rv router.RouteValues) {
-			w.Header().Add("access-control-allow-origin", cors.AllowedOrigins)
-			w.Header().Add("access-control-allow-headers", cors.AllowedHeaders)
-			w.Header().Add("access-control-max-age", cors.MaxAge)
-			w.Header().Add("access-control-allow-methods", cors.AllowedMethods)
-			w.WriteHeader(200)
-		})
+	return func(opt *Options, _ *router.RouteTable, _ *url.URL, _ func(w http.ResponseWriter, r *http.Request, rv RouteValues)) {
+		opt.CORS = cors
+	}
+}
+
+// Handler answers preflight requests and passes every other OPTIONS request
+// on to f.
+func (cors *CORS) Handler(f func(w http.ResponseWriter, r *http.Request, rv RouteValues)) func(w http.ResponseWriter, r *http.Request, rv RouteValues) {
+	return func(w http.ResponseWriter, r *http.Request, rv RouteValues) {
+		if len(r.Header.Get("Origin")) == 0 || len(r.Header.Get("Access-Control-Request-Method")) == 0 {
+			f(w, r, rv)
+			return
+		}
+		cors.Preflight(w, r)
+	}
+}
+
+// Table returns the route table the resource registers into, which is the
+// default table of rt for default resources.
+func (opt *Options) Table(rt *router.RouteTable) *router.RouteTable {
+	if opt.Default {
+		return rt.Default()
+	}
+	return rt
+}
+
+// Methods expands * (or no method at all) to every standard method and
+// normalizes the rest, keeping custom methods as they are.
+func Methods(methods []string) []string {
+	out := make([]string, 0)
+	for _, method := range methods {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method == "*" {
+			out = append(out, _defaultMethods...)
+			continue
+		}
+		if len(method) != 0 {
+			out = append(out, method)
+		}
+	}
+	if len(out) == 0 {
+		out = append(out, _defaultMethods...)
+	}
+	unique := make([]string, 0, len(out))
+	for _, method := range out {
+		if !contains(unique, method) {
+			unique = append(unique, method)
+		}
+	}
+	return unique
+}
+
+func (cors *CORS) Preflight(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Origin")
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+	origin := r.Header.Get("Origin")
+	if !cors.IsOriginAllowed(origin) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	if !cors.IsMethodAllowed(method) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	headers := split(r.Header.Values("Access-Control-Request-Headers"))
+	for _, header := range headers {
+		if !cors.IsHeaderAllowed(header) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	cors.setOrigin(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(cors.methods(), ", "))
+	if len(headers) != 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if cors.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cors.MaxAge))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cors *CORS) Apply(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 || !cors.IsOriginAllowed(origin) {
+		return
+	}
+	cors.setOrigin(w, origin)
+	if len(cors.ExposedHeaders) != 0 {
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(cors.ExposedHeaders, ", "))
+	}
+}
+
+func (cors *CORS) setOrigin(w http.ResponseWriter, origin string) {
+	if cors.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		return
+	}
+	if contains(cors.AllowedOrigins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+}
+
+func (cors *CORS) IsOriginAllowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range cors.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		prefix, suffix, found := strings.Cut(allowed, "*")
+		if !found || len(origin) <= len(prefix)+len(suffix) {
+			continue
+		}
+		if !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+			continue
+		}
+		if strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:") {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (cors *CORS) IsMethodAllowed(method string) bool {
+	for _, allowed := range cors.methods() {
+		if allowed == "*" || strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cors *CORS) IsHeaderAllowed(header string) bool {
+	for _, allowed := range cors.AllowedHeaders {
+		if allowed == "*" || strings.EqualFold(allowed, header) {
+			return true
+		}
+	}
+	switch strings.ToLower(header) {
+	case "accept", "accept-language", "content-language", "content-type", "range":
+		{
+			return true
+		}
+	}
+	return false
+}
+
+func (cors *CORS) methods() []string {
+	if len(cors.AllowedMethods) == 0 {
+		return _defaultMethods
+	}
+	return cors.AllowedMethods
+}
+
+func split(values []string) []string {
+	out := make([]string, 0)
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if len(item) == 0 {
+				continue
+			}
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func contains(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (timeouts *Timeouts) Apply(w http.ResponseWriter, r *http.Request) (*http.Request, context.CancelFunc) {
+	responseController := http.NewResponseController(w)
+	if timeouts.Read != 0 {
+		responseController.SetReadDeadline(deadline(timeouts.Read))
+	}
+	if timeouts.Write != 0 {
+		responseController.SetWriteDeadline(deadline(timeouts.Write))
+	}
+	if timeouts.Request <= 0 {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeouts.Request)
+	return r.WithContext(ctx), cancel
+}
+
+func deadline(timeout time.Duration) time.Time {
+	if timeout == NO_DEADLINE {
+		return time.Time{}
 	}
+	return time.Now().Add(timeout)
 }
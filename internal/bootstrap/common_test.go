@@ -0,0 +1,202 @@
+package bootstrap
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIsOriginAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{name: "any", allowed: []string{"*"}, origin: "https://app.example.com", want: true},
+		{name: "exact", allowed: []string{"https://app.example.com"}, origin: "https://app.example.com", want: true},
+		{name: "case insensitive", allowed: []string{"https://App.Example.com"}, origin: "https://app.example.COM", want: true},
+		{name: "other origin", allowed: []string{"https://app.example.com"}, origin: "https://evil.example.com"},
+		{name: "other scheme", allowed: []string{"https://app.example.com"}, origin: "http://app.example.com"},
+		{name: "subdomain", allowed: []string{"https://*.example.com"}, origin: "https://app.example.com", want: true},
+		{name: "nested subdomain", allowed: []string{"https://*.example.com"}, origin: "https://a.b.example.com", want: true},
+		{name: "bare domain", allowed: []string{"https://*.example.com"}, origin: "https://example.com"},
+		{name: "empty label", allowed: []string{"https://*.example.com"}, origin: "https://.example.com"},
+		{name: "suffix attack", allowed: []string{"https://*.example.com"}, origin: "https://example.com.evil.com"},
+		{name: "port smuggling", allowed: []string{"https://*.example.com"}, origin: "https://evil.com:443.example.com"},
+		{name: "path smuggling", allowed: []string{"https://*.example.com"}, origin: "https://evil.com/.example.com"},
+		{name: "wildcard port", allowed: []string{"http://localhost:*"}, origin: "http://localhost:3000", want: true},
+		{name: "second entry", allowed: []string{"https://a.example.com", "https://b.example.com"}, origin: "https://b.example.com", want: true},
+		{name: "none", origin: "https://app.example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cors := &CORS{AllowedOrigins: test.allowed}
+			if allowed := cors.IsOriginAllowed(test.origin); allowed != test.want {
+				t.Fatalf("expected %v, got %v", test.want, allowed)
+			}
+		})
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	cors := &CORS{
+		AllowedOrigins: []string{"https://*.example.com"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Authorization"},
+		MaxAge:         600,
+	}
+	tests := []struct {
+		name        string
+		cors        *CORS
+		origin      string
+		method      string
+		headers     string
+		wantStatus  int
+		wantHeaders map[string]string
+	}{
+		{
+			name:       "allowed",
+			cors:       cors,
+			origin:     "https://app.example.com",
+			method:     "post",
+			headers:    "authorization, content-type",
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Methods":     "GET, POST",
+				"Access-Control-Allow-Headers":     "authorization, content-type",
+				"Access-Control-Max-Age":           "600",
+				"Access-Control-Allow-Credentials": "",
+			},
+		},
+		{name: "origin not allowed", cors: cors, origin: "https://example.org", method: "GET", wantStatus: http.StatusForbidden, wantHeaders: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "method not allowed", cors: cors, origin: "https://app.example.com", method: "DELETE", wantStatus: http.StatusForbidden},
+		{name: "header not allowed", cors: cors, origin: "https://app.example.com", method: "GET", headers: "X-Debug", wantStatus: http.StatusForbidden},
+		{
+			name:       "credentials",
+			cors:       &CORS{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true},
+			origin:     "https://app.example.com",
+			method:     "PATCH",
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Allow-Methods":     "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS",
+				"Access-Control-Max-Age":           "",
+			},
+		},
+		{
+			name:        "any origin",
+			cors:        &CORS{AllowedOrigins: []string{"*"}},
+			origin:      "https://app.example.com",
+			method:      "GET",
+			wantStatus:  http.StatusNoContent,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": "*"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("OPTIONS", "/", nil)
+			r.Header.Set("Origin", test.origin)
+			r.Header.Set("Access-Control-Request-Method", test.method)
+			if len(test.headers) != 0 {
+				r.Header.Set("Access-Control-Request-Headers", test.headers)
+			}
+			w := httptest.NewRecorder()
+			test.cors.Preflight(w, r)
+			if w.Code != test.wantStatus {
+				t.Fatalf("expected status %d, got %d", test.wantStatus, w.Code)
+			}
+			for key, value := range test.wantHeaders {
+				if w.Header().Get(key) != value {
+					t.Fatalf("expected %s to be %q, got %q", key, value, w.Header().Get(key))
+				}
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name       string
+		cors       *CORS
+		origin     string
+		wantOrigin string
+		wantExpose string
+	}{
+		{name: "allowed", cors: &CORS{AllowedOrigins: []string{"https://app.example.com"}, ExposedHeaders: []string{"X-Total", "X-Page"}}, origin: "https://app.example.com", wantOrigin: "https://app.example.com", wantExpose: "X-Total, X-Page"},
+		{name: "not allowed", cors: &CORS{AllowedOrigins: []string{"https://app.example.com"}, ExposedHeaders: []string{"X-Total"}}, origin: "https://example.org"},
+		{name: "no origin", cors: &CORS{AllowedOrigins: []string{"*"}}},
+		{name: "any origin", cors: &CORS{AllowedOrigins: []string{"*"}}, origin: "https://example.org", wantOrigin: "*"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if len(test.origin) != 0 {
+				r.Header.Set("Origin", test.origin)
+			}
+			w := httptest.NewRecorder()
+			test.cors.Apply(w, r)
+			if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != test.wantOrigin {
+				t.Fatalf("expected origin %q, got %q", test.wantOrigin, origin)
+			}
+			if expose := w.Header().Get("Access-Control-Expose-Headers"); expose != test.wantExpose {
+				t.Fatalf("expected exposed headers %q, got %q", test.wantExpose, expose)
+			}
+			if w.Header().Get("Vary") != "Origin" {
+				t.Fatalf("expected Vary: Origin, got %q", w.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestTimeoutsApply(t *testing.T) {
+	tests := []struct {
+		name         string
+		timeouts     Timeouts
+		wantDeadline bool
+	}{
+		{name: "none", timeouts: Timeouts{}},
+		{name: "request", timeouts: Timeouts{Request: time.Minute}, wantDeadline: true},
+		{name: "no deadline", timeouts: Timeouts{Read: NO_DEADLINE, Write: NO_DEADLINE}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+			r, cancel := test.timeouts.Apply(httptest.NewRecorder(), r)
+			defer cancel()
+			deadline, ok := r.Context().Deadline()
+			if ok != test.wantDeadline {
+				t.Fatalf("expected %v, got %v", test.wantDeadline, ok)
+			}
+			if ok && time.Until(deadline) > test.timeouts.Request {
+				t.Fatalf("expected deadline within %v, got %v", test.timeouts.Request, time.Until(deadline))
+			}
+		})
+	}
+}
+
+func TestMethods(t *testing.T) {
+	tests := []struct {
+		name    string
+		methods []string
+		want    []string
+	}{
+		{name: "none", want: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}},
+		{name: "any", methods: []string{"*"}, want: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}},
+		{name: "normalized", methods: []string{" get", "Post "}, want: []string{"GET", "POST"}},
+		{name: "custom", methods: []string{"GET", "purge"}, want: []string{"GET", "PURGE"}},
+		{name: "duplicates", methods: []string{"GET", "*", "get"}, want: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}},
+		{name: "empty entries", methods: []string{"", " "}, want: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if methods := Methods(test.methods); !reflect.DeepEqual(methods, test.want) {
+				t.Fatalf("expected %v, got %v", test.want, methods)
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package parser
 
-import "gopkg.in/yaml.v3"
+import (
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
 
 type (
 	OnError string
@@ -14,36 +18,198 @@ type (
 		Name string `yaml:"name"`
 	}
 	SpecV1 struct {
-		Listen    string                `yaml:"listen"`
-		Resources map[string]ResourceV1 `yaml:"resources"`
+		Listen      ListenV1              `yaml:"listen"`
+		Admin       *ListenV1             `yaml:"admin"`
+		RetryBudget *RetryBudgetV1        `yaml:"retryBudget"`
+		Resources   map[string]ResourceV1 `yaml:"resources"`
+	}
+	RetryBudgetV1 struct {
+		Ratio        *float64 `yaml:"ratio"`
+		MinPerSecond *int     `yaml:"minPerSecond"`
+	}
+	ListenV1 struct {
+		Addr           string           `yaml:"addr"`
+		TLS            *TLSV1           `yaml:"tls"`
+		GracePeriod    string           `yaml:"gracePeriod"`
+		Timeouts       ServerTimeoutsV1 `yaml:"timeouts"`
+		TrustedProxies ListV1           `yaml:"trustedProxies"`
+	}
+	ServerTimeoutsV1 struct {
+		Read       string `yaml:"read"`
+		ReadHeader string `yaml:"readHeader"`
+		Write      string `yaml:"write"`
+		Idle       string `yaml:"idle"`
+	}
+	TLSV1 struct {
+		Cert       string `yaml:"cert"`
+		Key        string `yaml:"key"`
+		MinVersion string `yaml:"minVersion"`
+		Ciphers    ListV1 `yaml:"ciphers"`
+		ClientCA   string `yaml:"clientCA"`
+		ClientAuth string `yaml:"clientAuth"`
 	}
 	ResourceV1 struct {
-		Frontend string     `yaml:"frontend"`
-		Backend  string     `yaml:"backend"`
-		Method   string     `yaml:"method"`
-		Use      UseV1      `yaml:"use"`
-		Filters  []FilterV1 `yaml:"filters"`
+		Frontend     string          `yaml:"frontend"`
+		Backend      BackendV1       `yaml:"backend"`
+		LoadBalancer *LoadBalancerV1 `yaml:"loadBalancer"`
+		HealthCheck  *HealthCheckV1  `yaml:"healthCheck"`
+		Retry        *RetryV1        `yaml:"retry"`
+		Breaker      *BreakerV1      `yaml:"circuitBreaker"`
+		FailOnStatus bool            `yaml:"failOnStatus"`
+		Method       ListV1          `yaml:"method"`
+		Default      bool            `yaml:"default"`
+		Match        *MatchV1        `yaml:"match"`
+		Rewrite      *RewriteV1      `yaml:"rewrite"`
+		Body         *BodyV1         `yaml:"body"`
+		Transport    *TransportV1    `yaml:"transport"`
+		Timeouts     TimeoutsV1      `yaml:"timeouts"`
+		Use          UseV1           `yaml:"use"`
+		Filters      []FilterV1      `yaml:"filters"`
+	}
+	BackendV1  []EndpointV1
+	EndpointV1 struct {
+		URL    string `yaml:"url"`
+		Weight int    `yaml:"weight"`
+	}
+	LoadBalancerV1 struct {
+		Strategy string      `yaml:"strategy"`
+		Hash     *HashV1     `yaml:"hash"`
+		Ejection *EjectionV1 `yaml:"ejection"`
+	}
+	HashV1 struct {
+		Header     string `yaml:"header"`
+		Cookie     string `yaml:"cookie"`
+		RouteValue string `yaml:"routeValue"`
+	}
+	EjectionV1 struct {
+		Failures int    `yaml:"failures"`
+		Duration string `yaml:"duration"`
+	}
+	HealthCheckV1 struct {
+		Path               string `yaml:"path"`
+		Status             ListV1 `yaml:"status"`
+		Interval           string `yaml:"interval"`
+		Timeout            string `yaml:"timeout"`
+		HealthyThreshold   int    `yaml:"healthyThreshold"`
+		UnhealthyThreshold int    `yaml:"unhealthyThreshold"`
+	}
+	RetryV1 struct {
+		Attempts      int    `yaml:"attempts"`
+		PerTryTimeout string `yaml:"perTryTimeout"`
+		Backoff       string `yaml:"backoff"`
+		MaxBackoff    string `yaml:"maxBackoff"`
+		On            ListV1 `yaml:"on"`
+		Methods       ListV1 `yaml:"methods"`
+	}
+	BreakerV1 struct {
+		ConsecutiveFailures int     `yaml:"consecutiveFailures"`
+		ErrorRate           float64 `yaml:"errorRate"`
+		MinRequests         int     `yaml:"minRequests"`
+		Window              string  `yaml:"window"`
+		OpenDuration        string  `yaml:"openDuration"`
+		HalfOpenRequests    int     `yaml:"halfOpenRequests"`
+	}
+	MatchV1 struct {
+		Host    string            `yaml:"host"`
+		Headers map[string]string `yaml:"headers"`
+		Query   map[string]string `yaml:"query"`
+		Cookies map[string]string `yaml:"cookies"`
+	}
+	RewriteV1 struct {
+		StripPrefix string            `yaml:"stripPrefix"`
+		AddPrefix   string            `yaml:"addPrefix"`
+		Regex       []RegexRewriteV1  `yaml:"regex"`
+		Query       map[string]string `yaml:"query"`
+		Host        string            `yaml:"host"`
+	}
+	RegexRewriteV1 struct {
+		Match   string `yaml:"match"`
+		Replace string `yaml:"replace"`
+	}
+	BodyV1 struct {
+		Stream  bool   `yaml:"stream"`
+		Memory  string `yaml:"memory"`
+		MaxSize string `yaml:"maxSize"`
+	}
+	TransportV1 struct {
+		MaxIdleConns          int             `yaml:"maxIdleConns"`
+		MaxIdleConnsPerHost   int             `yaml:"maxIdleConnsPerHost"`
+		MaxConnsPerHost       int             `yaml:"maxConnsPerHost"`
+		IdleConnTimeout       string          `yaml:"idleConnTimeout"`
+		KeepAlive             string          `yaml:"keepAlive"`
+		DialTimeout           string          `yaml:"dialTimeout"`
+		TLSHandshakeTimeout   string          `yaml:"tlsHandshakeTimeout"`
+		ResponseHeaderTimeout string          `yaml:"responseHeaderTimeout"`
+		HTTP2                 *bool           `yaml:"http2"`
+		TLS                   *TransportTLSV1 `yaml:"tls"`
+	}
+	TransportTLSV1 struct {
+		CA                 string `yaml:"ca"`
+		Cert               string `yaml:"cert"`
+		Key                string `yaml:"key"`
+		ServerName         string `yaml:"serverName"`
+		InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+	}
+	TimeoutsV1 struct {
+		Backend string `yaml:"backend"`
+		Request string `yaml:"request"`
+		Read    string `yaml:"read"`
+		Write   string `yaml:"write"`
 	}
 	FilterV1 struct {
-		Name     string     `yaml:"name"`
-		Addr     string     `yaml:"addr"`
-		Level    string     `yaml:"level"`
-		Timeout  string     `yaml:"timeout"`
-		OnError  OnError    `yaml:"onError"`
-		Async    bool       `yaml:"async"`
-		Await    []string   `yaml:"await"`
-		Exchange ExchangeV1 `yaml:"exchange"`
-		Next     []FilterV1 `yaml:"next"`
+		Name         string       `yaml:"name"`
+		Addr         string       `yaml:"addr"`
+		Level        string       `yaml:"level"`
+		Timeout      string       `yaml:"timeout"`
+		Transport    *TransportV1 `yaml:"transport"`
+		Retry        *RetryV1     `yaml:"retry"`
+		Breaker      *BreakerV1   `yaml:"circuitBreaker"`
+		FailOnStatus bool         `yaml:"failOnStatus"`
+		OnError      OnErrorV1    `yaml:"onError"`
+		Async        bool         `yaml:"async"`
+		Await        []string     `yaml:"await"`
+		Exchange     ExchangeV1   `yaml:"exchange"`
+		Next         []FilterV1   `yaml:"next"`
+	}
+	OnErrorV1 struct {
+		Policy   OnError     `yaml:"policy"`
+		On       []string    `yaml:"on"`
+		Fallback *FallbackV1 `yaml:"fallback"`
+	}
+	FallbackV1 struct {
+		Addr      string            `yaml:"addr"`
+		Timeout   string            `yaml:"timeout"`
+		Transport *TransportV1      `yaml:"transport"`
+		Status    int               `yaml:"status"`
+		Headers   map[string]string `yaml:"headers"`
+		Body      string            `yaml:"body"`
 	}
 	ExchangeV1 struct {
-		Headers []string `yaml:"headers"`
-		Body    bool     `yaml:"body"`
+		Headers       []string `yaml:"headers"`
+		Body          bool     `yaml:"body"`
+		Status        bool     `yaml:"status"`
+		Trailers      []string `yaml:"trailers"`
+		Query         []string `yaml:"query"`
+		RouteValues   []string `yaml:"routeValues"`
+		URL           bool     `yaml:"url"`
+		Form          bool     `yaml:"form"`
+		MultipartForm bool     `yaml:"multipartForm"`
 	}
 	UseV1 struct {
 		Cache *CacheV1 `yaml:"cache"`
-		Cors  *string  `yaml:"cors"`
+		Cors  *CorsV1  `yaml:"cors"`
 		OPA   *OpaV1   `yaml:"opa"`
 	}
+	CorsV1 struct {
+		Mode             string `yaml:"-"`
+		AllowedOrigins   ListV1 `yaml:"allowedOrigins"`
+		AllowedHeaders   ListV1 `yaml:"allowedHeaders"`
+		AllowedMethods   ListV1 `yaml:"allowedMethods"`
+		ExposedHeaders   ListV1 `yaml:"exposedHeaders"`
+		MaxAge           int    `yaml:"maxAge"`
+		AllowCredentials bool   `yaml:"allowCredentials"`
+	}
+	ListV1  []string
 	CacheV1 struct {
 		Addr string `yaml:"addr"`
 		TTL  string `yaml:"ttl"`
@@ -64,4 +230,76 @@ const (
 	DEFAULT  OnError = "default"
 	TERM     OnError = "term"
 	CONTINUE OnError = "continue"
+	FALLBACK OnError = "fallback"
 )
+
+func (listen *ListenV1) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		listen.Addr = value.Value
+		return nil
+	}
+	type plain ListenV1
+	return value.Decode((*plain)(listen))
+}
+
+func (cors *CorsV1) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		cors.Mode = value.Value
+		return nil
+	}
+	type plain CorsV1
+	return value.Decode((*plain)(cors))
+}
+
+func (list *ListV1) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*list = make(ListV1, 0)
+		for _, item := range strings.Split(value.Value, ",") {
+			item = strings.TrimSpace(item)
+			if len(item) == 0 {
+				continue
+			}
+			*list = append(*list, item)
+		}
+		return nil
+	}
+	var items []string
+	err := value.Decode(&items)
+	if err != nil {
+		return err
+	}
+	*list = items
+	return nil
+}
+
+func (backend *BackendV1) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*backend = BackendV1{{URL: value.Value}}
+		return nil
+	}
+	var endpoints []EndpointV1
+	err := value.Decode(&endpoints)
+	if err != nil {
+		return err
+	}
+	*backend = endpoints
+	return nil
+}
+
+func (endpoint *EndpointV1) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		endpoint.URL = value.Value
+		return nil
+	}
+	type plain EndpointV1
+	return value.Decode((*plain)(endpoint))
+}
+
+func (onError *OnErrorV1) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		onError.Policy = OnError(value.Value)
+		return nil
+	}
+	type plain OnErrorV1
+	return value.Decode((*plain)(onError))
+}
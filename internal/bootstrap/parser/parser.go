@@ -2,21 +2,40 @@ package parser
 
 import (
 	"bytes"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
+	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 	"unicode"
 
 	"github.com/vedadiyan/iceberg/internal/bootstrap"
+	"github.com/vedadiyan/iceberg/internal/bootstrap/server"
 	"github.com/vedadiyan/iceberg/internal/callers/filters"
+	"github.com/vedadiyan/iceberg/internal/callers/proxies"
+	"github.com/vedadiyan/iceberg/internal/common/breaker"
 	"github.com/vedadiyan/iceberg/internal/common/netio"
+	"github.com/vedadiyan/iceberg/internal/common/retry"
+	"github.com/vedadiyan/iceberg/internal/common/router"
+	"github.com/vedadiyan/iceberg/internal/common/transport"
 	"github.com/vedadiyan/iceberg/internal/middleware/cache"
 	"github.com/vedadiyan/iceberg/internal/middleware/opa"
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	DEFAULT_FRONTEND = "/*path"
+)
+
+var (
+	_envHost = regexp.MustCompile(`\[\[([^\]]+)\]\]`)
+)
+
 func Parse(in []byte) (Version, *Metadata, any, error) {
 	var conf Config
 	err := yaml.Unmarshal(in, &conf)
@@ -37,11 +56,45 @@ func Parse(in []byte) (Version, *Metadata, any, error) {
 	return 0, nil, nil, fmt.Errorf("usupported version %s", conf.APIVersion)
 }
 
-func ParseV1(resourcesV1 map[string]ResourceV1, handleFunc func(*url.URL, string, string, []netio.Caller, ...bootstrap.RegistrationOptions)) error {
-	for _, value := range resourcesV1 {
-		url, err := url.Parse(value.Backend)
+// ParseV1 parses the resources, whose retry policies share budget.
+func ParseV1(resourcesV1 map[string]ResourceV1, budget *retry.Budget, handleFunc func(*proxies.Proxy, string, []string, ...bootstrap.RegistrationOptions) error) error {
+	for name, value := range resourcesV1 {
+		balancer, err := ParseBackendV1(value.Backend, value.LoadBalancer)
+		if err != nil {
+			return err
+		}
+		url := balancer.Endpoints[0].Address
+		backendTimeout, err := Timeout(value.Timeouts.Backend)
+		if err != nil {
+			return err
+		}
+		timeouts, err := ParseTimeoutsV1(value.Timeouts, url)
+		if err != nil {
+			return err
+		}
+		healthCheck, err := ParseHealthCheckV1(value.HealthCheck)
+		if err != nil {
+			return err
+		}
+		retry, err := ParseRetryV1(value.Retry, budget)
+		if err != nil {
+			return err
+		}
+		breaker, err := ParseBreakerV1(name, value.Breaker)
+		if err != nil {
+			return err
+		}
+		rewrite, err := ParseRewriteV1(value.Rewrite)
+		if err != nil {
+			return err
+		}
+		body, err := ParseBodyV1(value.Body)
 		if err != nil {
-			return nil
+			return err
+		}
+		transport, err := ParseTransportV1(value.Transport)
+		if err != nil {
+			return err
 		}
 		callers := make([]netio.Caller, 0)
 		opa, err := ParseOpaV1(value)
@@ -51,28 +104,455 @@ func ParseV1(resourcesV1 map[string]ResourceV1, handleFunc func(*url.URL, string
 		callers = append(callers, opa...)
 		cache, err := ParseCacheV1(value)
 		if err != nil {
-			return err
+			return errors.Join(err, netio.Close(callers...))
 		}
 		callers = append(callers, cache...)
-		filters, err := ParseFiltersV1(value.Filters, true)
+		filters, err := ParseFiltersV1(value.Filters, true, budget)
 		if err != nil {
-			return nil
+			return errors.Join(err, netio.Close(callers...))
 		}
 		callers = append(callers, filters...)
 		opts := make([]bootstrap.RegistrationOptions, 0)
-		if value.Use.Cors != nil {
-			opts = append(opts, bootstrap.WithCORSDisabled())
+		frontend := value.Frontend
+		if value.Default {
+			opts = append(opts, bootstrap.WithDefault())
+			if len(frontend) == 0 {
+				frontend = DEFAULT_FRONTEND
+			}
+		}
+		if value.Match != nil {
+			opts = append(opts, bootstrap.WithPredicates(ParseMatchV1(*value.Match)))
+		}
+		opts = append(opts, bootstrap.WithTimeouts(timeouts))
+		cors, err := ParseCorsV1(value)
+		if err != nil {
+			return errors.Join(err, netio.Close(callers...))
+		}
+		if cors != nil {
+			opts = append(opts, cors)
+		}
+		proxy := &proxies.Proxy{
+			Name:         name,
+			Address:      url,
+			Balancer:     balancer,
+			HealthCheck:  healthCheck,
+			Retry:        retry,
+			Breaker:      breaker,
+			FailOnStatus: value.FailOnStatus,
+			Rewrite:      rewrite,
+			Body:         body,
+			Transport:    transport,
+			Timeout:      backendTimeout,
+			Callers:      callers,
+		}
+		err = handleFunc(proxy, frontend, value.Method, opts...)
+		if err != nil {
+			return err
 		}
-		handleFunc(url, value.Frontend, value.Method, callers, opts...)
 	}
 	return nil
 }
 
+func ParseListenV1(listen ListenV1) (*server.Listener, error) {
+	listener := &server.Listener{
+		Addr:        listen.Addr,
+		GracePeriod: server.GRACE_PERIOD,
+	}
+	var err error
+	listener.ReadTimeout, err = ServerTimeout(listen.Timeouts.Read, server.READ_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	listener.ReadHeaderTimeout, err = ServerTimeout(listen.Timeouts.ReadHeader, server.READ_HEADER_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	listener.WriteTimeout, err = ServerTimeout(listen.Timeouts.Write, server.WRITE_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	listener.IdleTimeout, err = ServerTimeout(listen.Timeouts.Idle, server.IDLE_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	if len(listen.GracePeriod) != 0 {
+		gracePeriod, err := Timeout(listen.GracePeriod)
+		if err != nil {
+			return nil, err
+		}
+		listener.GracePeriod = gracePeriod
+	}
+	listener.TrustedProxies, err = server.ParseTrustedProxies(listen.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if listen.TLS == nil {
+		return listener, nil
+	}
+	if len(listen.TLS.Cert) == 0 || len(listen.TLS.Key) == 0 {
+		return nil, fmt.Errorf("tls requires both cert and key")
+	}
+	minVersion, err := server.ParseTLSVersion(listen.TLS.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	ciphers := make([]uint16, 0)
+	for _, name := range listen.TLS.Ciphers {
+		cipher, err := server.ParseCipherSuite(name)
+		if err != nil {
+			return nil, err
+		}
+		ciphers = append(ciphers, cipher)
+	}
+	clientAuth, err := server.ParseClientAuth(listen.TLS.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	if len(listen.TLS.ClientCA) != 0 && len(listen.TLS.ClientAuth) == 0 {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	if clientAuth >= tls.VerifyClientCertIfGiven && len(listen.TLS.ClientCA) == 0 {
+		return nil, fmt.Errorf("client certificate verification requires clientCA")
+	}
+	if len(ciphers) == 0 {
+		ciphers = nil
+	}
+	listener.TLS = &server.TLS{
+		CertFile:     listen.TLS.Cert,
+		KeyFile:      listen.TLS.Key,
+		ClientCAFile: listen.TLS.ClientCA,
+		ClientAuth:   clientAuth,
+		MinVersion:   minVersion,
+		CipherSuites: ciphers,
+	}
+	return listener, nil
+}
+
+func ParseTimeoutsV1(timeouts TimeoutsV1, backend *url.URL) (*bootstrap.Timeouts, error) {
+	request, err := Timeout(timeouts.Request)
+	if err != nil {
+		return nil, err
+	}
+	read, err := Deadline(timeouts.Read)
+	if err != nil {
+		return nil, err
+	}
+	write, err := Deadline(timeouts.Write)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(backend.Scheme) {
+	case "ws", "wss":
+		{
+			if read == 0 {
+				read = bootstrap.NO_DEADLINE
+			}
+			if write == 0 {
+				write = bootstrap.NO_DEADLINE
+			}
+		}
+	}
+	return &bootstrap.Timeouts{
+		Request: request,
+		Read:    read,
+		Write:   write,
+	}, nil
+}
+
+func ParseMatchV1(match MatchV1) *router.Predicates {
+	return &router.Predicates{
+		Host:    strings.ToLower(match.Host),
+		Headers: match.Headers,
+		Query:   match.Query,
+		Cookies: match.Cookies,
+	}
+}
+
+func ParseRewriteV1(rewrite *RewriteV1) (*netio.Rewrite, error) {
+	if rewrite == nil {
+		return nil, nil
+	}
+	out := &netio.Rewrite{
+		StripPrefix: rewrite.StripPrefix,
+		AddPrefix:   rewrite.AddPrefix,
+		Regex:       make([]netio.RegexRewrite, 0),
+		Query:       rewrite.Query,
+		Host:        rewrite.Host,
+	}
+	for _, regex := range rewrite.Regex {
+		match, err := regexp.Compile(regex.Match)
+		if err != nil {
+			return nil, err
+		}
+		out.Regex = append(out.Regex, netio.RegexRewrite{Match: match, Replace: regex.Replace})
+	}
+	return out, nil
+}
+
+func ParseBodyV1(body *BodyV1) (*netio.BodyOptions, error) {
+	if body == nil {
+		return nil, nil
+	}
+	memory, err := Size(body.Memory)
+	if err != nil {
+		return nil, err
+	}
+	maxSize, err := Size(body.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+	return &netio.BodyOptions{
+		Stream:  body.Stream,
+		Memory:  memory,
+		MaxSize: maxSize,
+	}, nil
+}
+
+func ParseBackendV1(backend BackendV1, loadBalancer *LoadBalancerV1) (*proxies.Balancer, error) {
+	if len(backend) == 0 {
+		return nil, fmt.Errorf("no backend")
+	}
+	balancer := &proxies.Balancer{
+		Endpoints: make([]*proxies.Endpoint, 0),
+	}
+	for _, endpoint := range backend {
+		url, err := Address(endpoint.URL)
+		if err != nil {
+			return nil, err
+		}
+		balancer.Endpoints = append(balancer.Endpoints, &proxies.Endpoint{
+			Address: url,
+			Weight:  endpoint.Weight,
+		})
+	}
+	if loadBalancer == nil {
+		return proxies.NewBalancer(balancer), nil
+	}
+	strategy, err := proxies.ParseStrategy(loadBalancer.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	balancer.Strategy = strategy
+	if loadBalancer.Hash != nil {
+		balancer.Hash = proxies.HashKey{
+			Header:     loadBalancer.Hash.Header,
+			Cookie:     loadBalancer.Hash.Cookie,
+			RouteValue: loadBalancer.Hash.RouteValue,
+		}
+	}
+	if loadBalancer.Ejection != nil {
+		duration, err := Timeout(loadBalancer.Ejection.Duration)
+		if err != nil {
+			return nil, err
+		}
+		balancer.Ejection = proxies.Ejection{
+			Failures: loadBalancer.Ejection.Failures,
+			Duration: duration,
+		}
+	}
+	return proxies.NewBalancer(balancer), nil
+}
+
+func ParseHealthCheckV1(healthCheck *HealthCheckV1) (*proxies.HealthCheck, error) {
+	if healthCheck == nil {
+		return nil, nil
+	}
+	interval, err := Timeout(healthCheck.Interval)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := Timeout(healthCheck.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	out := &proxies.HealthCheck{
+		Path:               healthCheck.Path,
+		Interval:           interval,
+		Timeout:            timeout,
+		HealthyThreshold:   healthCheck.HealthyThreshold,
+		UnhealthyThreshold: healthCheck.UnhealthyThreshold,
+	}
+	if len(out.Path) == 0 {
+		out.Path = "/"
+	}
+	for _, value := range healthCheck.Status {
+		status, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid health check status %s", value)
+		}
+		out.Status = append(out.Status, status)
+	}
+	return out, nil
+}
+
+func ParseRetryV1(in *RetryV1, budget *retry.Budget) (*retry.Policy, error) {
+	if in == nil {
+		return nil, nil
+	}
+	out := &retry.Policy{
+		Attempts: in.Attempts,
+		Budget:   budget,
+	}
+	for _, timeout := range []struct {
+		value  string
+		target *time.Duration
+	}{
+		{in.PerTryTimeout, &out.PerTryTimeout},
+		{in.Backoff, &out.Backoff},
+		{in.MaxBackoff, &out.MaxBackoff},
+	} {
+		value, err := Timeout(timeout.value)
+		if err != nil {
+			return nil, err
+		}
+		*timeout.target = value
+	}
+	for _, on := range in.On {
+		if status, err := strconv.Atoi(on); err == nil {
+			out.Statuses = append(out.Statuses, status)
+			continue
+		}
+		condition, err := retry.ParseCondition(on)
+		if err != nil {
+			return nil, err
+		}
+		out.Conditions = append(out.Conditions, condition)
+	}
+	for _, method := range in.Methods {
+		out.Methods = append(out.Methods, strings.ToUpper(method))
+	}
+	return retry.NewPolicy(out), nil
+}
+
+func ParseBreakerV1(name string, in *BreakerV1) (*breaker.Breaker, error) {
+	if in == nil {
+		return nil, nil
+	}
+	window, err := Timeout(in.Window)
+	if err != nil {
+		return nil, err
+	}
+	openDuration, err := Timeout(in.OpenDuration)
+	if err != nil {
+		return nil, err
+	}
+	return breaker.NewBreaker(&breaker.Breaker{
+		Name:                name,
+		ConsecutiveFailures: in.ConsecutiveFailures,
+		ErrorRate:           in.ErrorRate,
+		MinRequests:         in.MinRequests,
+		Window:              window,
+		OpenDuration:        openDuration,
+		HalfOpenRequests:    in.HalfOpenRequests,
+	}), nil
+}
+
+// ParseRetryBudgetV1 returns a retry budget, keeping the defaults for unset
+// values.
+func ParseRetryBudgetV1(in *RetryBudgetV1) *retry.Budget {
+	out := &retry.Budget{
+		Ratio:        retry.BUDGET_RATIO,
+		MinPerSecond: retry.BUDGET_MIN_PER_SECOND,
+	}
+	if in == nil {
+		return out
+	}
+	if in.Ratio != nil {
+		out.Ratio = *in.Ratio
+	}
+	if in.MinPerSecond != nil {
+		out.MinPerSecond = *in.MinPerSecond
+	}
+	return out
+}
+
+func ParseTransportV1(in *TransportV1) (*transport.Options, error) {
+	if in == nil {
+		return nil, nil
+	}
+	out := &transport.Options{
+		MaxIdleConns:        in.MaxIdleConns,
+		MaxIdleConnsPerHost: in.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     in.MaxConnsPerHost,
+		DisableHTTP2:        in.HTTP2 != nil && !*in.HTTP2,
+	}
+	for _, timeout := range []struct {
+		value  string
+		target *time.Duration
+	}{
+		{in.IdleConnTimeout, &out.IdleConnTimeout},
+		{in.KeepAlive, &out.KeepAlive},
+		{in.DialTimeout, &out.DialTimeout},
+		{in.TLSHandshakeTimeout, &out.TLSHandshakeTimeout},
+		{in.ResponseHeaderTimeout, &out.ResponseHeaderTimeout},
+	} {
+		value, err := Timeout(timeout.value)
+		if err != nil {
+			return nil, err
+		}
+		*timeout.target = value
+	}
+	if in.TLS != nil {
+		out.CAFile = in.TLS.CA
+		out.CertFile = in.TLS.Cert
+		out.KeyFile = in.TLS.Key
+		out.ServerName = in.TLS.ServerName
+		out.InsecureSkipVerify = in.TLS.InsecureSkipVerify
+	}
+	return out, nil
+}
+
+func ParseCorsV1(value ResourceV1) (bootstrap.RegistrationOptions, error) {
+	cors := value.Use.Cors
+	if cors == nil {
+		return nil, nil
+	}
+	switch strings.ToLower(cors.Mode) {
+	case "":
+		{
+			break
+		}
+	case "default":
+		{
+			return bootstrap.WithCORSDisabled(), nil
+		}
+	default:
+		{
+			return nil, fmt.Errorf("unsupported cors policy %s", cors.Mode)
+		}
+	}
+	if cors.AllowCredentials && containsWildcard(cors.AllowedOrigins) {
+		return nil, fmt.Errorf("cors policy cannot allow credentials for the * origin")
+	}
+	methods := make([]string, 0)
+	for _, method := range cors.AllowedMethods {
+		methods = append(methods, strings.ToUpper(method))
+	}
+	return bootstrap.WithCORS(&bootstrap.CORS{
+		AllowedOrigins:   cors.AllowedOrigins,
+		AllowedHeaders:   cors.AllowedHeaders,
+		AllowedMethods:   methods,
+		ExposedHeaders:   cors.ExposedHeaders,
+		MaxAge:           cors.MaxAge,
+		AllowCredentials: cors.AllowCredentials,
+	}), nil
+}
+
+// containsWildcard reports whether origins allow every origin.
+func containsWildcard(origins []string) bool {
+	for _, origin := range origins {
+		if strings.TrimSpace(origin) == "*" {
+			return true
+		}
+	}
+	return false
+}
+
 func ParseCacheV1(value ResourceV1) ([]netio.Caller, error) {
 	if value.Use.Cache == nil {
 		return nil, nil
 	}
-	url, err := url.Parse(value.Use.Cache.Addr)
+	url, err := Address(value.Use.Cache.Addr)
 	if err != nil {
 		return nil, err
 	}
@@ -93,7 +573,7 @@ func ParseOpaV1(value ResourceV1) ([]netio.Caller, error) {
 		return nil, nil
 	}
 	out := make([]netio.Caller, 0)
-	url, err := url.Parse(value.Use.OPA.Agent)
+	url, err := Address(value.Use.OPA.Agent)
 	if err != nil {
 		return nil, err
 	}
@@ -119,6 +599,7 @@ func ParseOpaV1(value ResourceV1) ([]netio.Caller, error) {
 	if err != nil {
 		return nil, err
 	}
+	out = append(out, http)
 	send, err := opa.NewOpaNats(&opa.Opa{
 		AppName:  "",
 		Agent:    url,
@@ -127,8 +608,9 @@ func ParseOpaV1(value ResourceV1) ([]netio.Caller, error) {
 		Timeout:  time.Second * 30,
 	})
 	if err != nil {
-		return nil, err
+		return nil, errors.Join(err, netio.Close(out...))
 	}
+	out = append(out, send)
 	receive, err := opa.NewOpaNats(&opa.Opa{
 		AppName:  "",
 		Agent:    url,
@@ -137,10 +619,8 @@ func ParseOpaV1(value ResourceV1) ([]netio.Caller, error) {
 		Timeout:  time.Second * 30,
 	})
 	if err != nil {
-		return nil, err
+		return nil, errors.Join(err, netio.Close(out...))
 	}
-	out = append(out, http)
-	out = append(out, send)
 	out = append(out, receive)
 	return out, nil
 }
@@ -182,43 +662,181 @@ func ParsePolicy(in []any) (map[string]opa.PolicyType, error) {
 	return policies, nil
 }
 
-func ParseFiltersV1(in []FilterV1, supportsLevel bool) ([]netio.Caller, error) {
+func ParseFiltersV1(in []FilterV1, supportsLevel bool, budget *retry.Budget) ([]netio.Caller, error) {
 	callers := make([]netio.Caller, 0)
 	for _, caller := range in {
-		url, err := url.Parse(caller.Addr)
+		c, err := ParseFilterV1(caller, supportsLevel, budget)
 		if err != nil {
-			return nil, err
+			return nil, errors.Join(err, netio.Close(callers...))
 		}
-		filter := filters.NewFilter()
-		filter.Address = url
-		filter.AwaitList = caller.Await
-		filter.Name = caller.Name
-		filter.Parallel = caller.Async
-		filter.Level = netio.LEVEL_NONE
-		if supportsLevel {
-			level, err := Level(caller.Level)
-			if err != nil {
-				return nil, err
-			}
-			filter.Level = level
-		}
-		timeout, err := Timeout(caller.Timeout)
+		callers = append(callers, c)
+	}
+	return callers, nil
+}
+
+func ParseFilterV1(caller FilterV1, supportsLevel bool, budget *retry.Budget) (netio.Caller, error) {
+	url, err := Address(caller.Addr)
+	if err != nil {
+		return nil, err
+	}
+	filter := filters.NewFilter()
+	filter.Address = url
+	filter.AwaitList = caller.Await
+	filter.Name = caller.Name
+	filter.Parallel = caller.Async
+	filter.Level = netio.LEVEL_NONE
+	if supportsLevel {
+		level, err := Level(caller.Level)
 		if err != nil {
 			return nil, err
 		}
-		filter.Timeout = timeout
-		next, err := ParseFiltersV1(caller.Next, false)
+		filter.Level = level
+	}
+	timeout, err := Timeout(caller.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	filter.Timeout = timeout
+	transport, err := ParseTransportV1(caller.Transport)
+	if err != nil {
+		return nil, err
+	}
+	filter.Transport = transport
+	retry, err := ParseRetryV1(caller.Retry, budget)
+	if err != nil {
+		return nil, err
+	}
+	filter.Retry = retry
+	breaker, err := ParseBreakerV1(caller.Name, caller.Breaker)
+	if err != nil {
+		return nil, err
+	}
+	filter.Breaker = breaker
+	filter.FailOnStatus = caller.FailOnStatus
+	next, err := ParseFiltersV1(caller.Next, false, budget)
+	if err != nil {
+		return nil, err
+	}
+	filter.Callers = next
+	ParseExchangeV1(filter, caller.Exchange)
+	onError, err := ParseOnErrorV1(caller, filter.Level)
+	if err != nil {
+		return nil, errors.Join(err, netio.Close(next...))
+	}
+	filter.OnError = *onError
+	c, err := filter.Build()
+	if err != nil {
+		return nil, errors.Join(err, netio.Close(next...), onError.Close())
+	}
+	return c, nil
+}
+
+func ParseOnErrorV1(caller FilterV1, level netio.Level) (*filters.OnError, error) {
+	policy, err := filters.ParsePolicy(string(caller.OnError.Policy))
+	if err != nil {
+		return nil, err
+	}
+	onError := filters.OnError{
+		Policy:     policy,
+		Conditions: make([]filters.Condition, 0),
+	}
+	for _, on := range caller.OnError.On {
+		condition, err := filters.ParseCondition(on)
 		if err != nil {
 			return nil, err
 		}
-		filter.Callers = next
-		c, err := filter.Build()
+		onError.Conditions = append(onError.Conditions, condition)
+	}
+	fallback := caller.OnError.Fallback
+	if policy != filters.POLICY_FALLBACK {
+		return &onError, nil
+	}
+	if fallback == nil {
+		return nil, fmt.Errorf("filter %s uses the fallback policy but has no fallback", caller.Name)
+	}
+	if len(fallback.Addr) == 0 {
+		header := http.Header{}
+		for key, value := range fallback.Headers {
+			header.Set(key, value)
+		}
+		onError.Response = &filters.StaticResponse{
+			Status: fallback.Status,
+			Header: header,
+			Body:   []byte(fallback.Body),
+		}
+		return &onError, nil
+	}
+	url, err := Address(fallback.Addr)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := Timeout(fallback.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if timeout == 0 {
+		timeout, err = Timeout(caller.Timeout)
 		if err != nil {
 			return nil, err
 		}
-		callers = append(callers, c)
 	}
-	return callers, nil
+	transport, err := ParseTransportV1(fallback.Transport)
+	if err != nil {
+		return nil, err
+	}
+	filter := filters.NewFilter()
+	filter.Address = url
+	filter.Name = caller.Name
+	filter.Level = level
+	filter.Timeout = timeout
+	filter.Transport = transport
+	c, err := filter.Build()
+	if err != nil {
+		return nil, err
+	}
+	onError.Fallback = c
+	return &onError, nil
+}
+
+func ParseExchangeV1(filter *filters.Filter, exchange ExchangeV1) {
+	if len(exchange.Headers) != 0 {
+		filter.SetExchangeHeaders(exchange.Headers)
+	}
+	if exchange.Body {
+		filter.SetExchangeBody()
+	}
+	if exchange.Status {
+		filter.SetExchangeStatus()
+	}
+	if len(exchange.Trailers) != 0 {
+		filter.SetExchangeTrailers(exchange.Trailers)
+	}
+	if len(exchange.Query) != 0 {
+		filter.SetExchangeQuery(exchange.Query)
+	}
+	if len(exchange.RouteValues) != 0 {
+		filter.SetExchangeRouteValues(exchange.RouteValues)
+	}
+	if exchange.URL {
+		filter.SetExchangeURL()
+	}
+	if exchange.Form {
+		filter.SetExchangeForm()
+	}
+	if exchange.MultipartForm {
+		filter.SetExchangeMultipartForm()
+	}
+}
+
+func Address(addr string) (*url.URL, error) {
+	addr = _envHost.ReplaceAllStringFunc(addr, func(match string) string {
+		host := os.Getenv(_envHost.FindStringSubmatch(match)[1])
+		if _, after, found := strings.Cut(host, "://"); found {
+			host = after
+		}
+		return host
+	})
+	return url.Parse(addr)
 }
 
 func Level(level string) (netio.Level, error) {
@@ -239,6 +857,34 @@ func Level(level string) (netio.Level, error) {
 	return netio.LEVEL_NONE, fmt.Errorf("unsupported level %s", level)
 }
 
+func ServerTimeout(str string, fallback time.Duration) (time.Duration, error) {
+	timeout, err := Deadline(str)
+	if err != nil {
+		return 0, err
+	}
+	switch timeout {
+	case 0:
+		{
+			return fallback, nil
+		}
+	case bootstrap.NO_DEADLINE:
+		{
+			return 0, nil
+		}
+	}
+	return timeout, nil
+}
+
+func Deadline(str string) (time.Duration, error) {
+	switch strings.ToLower(str) {
+	case "none", "off":
+		{
+			return bootstrap.NO_DEADLINE, nil
+		}
+	}
+	return Timeout(str)
+}
+
 func Timeout(str string) (time.Duration, error) {
 	if len(str) == 0 {
 		return 0, nil
@@ -250,7 +896,7 @@ func Timeout(str string) (time.Duration, error) {
 		}
 		buffer.WriteRune(r)
 	}
-	unit := str[:buffer.Len()]
+	unit := str[buffer.Len():]
 	unit = strings.TrimPrefix(unit, " ")
 	unit = strings.TrimSuffix(unit, " ")
 	n, err := strconv.Atoi(buffer.String())
@@ -277,3 +923,42 @@ func Timeout(str string) (time.Duration, error) {
 	}
 	return 0, fmt.Errorf("unsupported unit %s", unit)
 }
+
+func Size(str string) (int64, error) {
+	if len(str) == 0 {
+		return 0, nil
+	}
+	var buffer bytes.Buffer
+	for _, r := range str {
+		if !unicode.IsDigit(r) {
+			break
+		}
+		buffer.WriteRune(r)
+	}
+	unit := str[buffer.Len():]
+	unit = strings.TrimPrefix(unit, " ")
+	unit = strings.TrimSuffix(unit, " ")
+	n, err := strconv.ParseInt(buffer.String(), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	switch strings.ToLower(unit) {
+	case "", "b":
+		{
+			return n, nil
+		}
+	case "k", "kb", "kib":
+		{
+			return n << 10, nil
+		}
+	case "m", "mb", "mib":
+		{
+			return n << 20, nil
+		}
+	case "g", "gb", "gib":
+		{
+			return n << 30, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported unit %s", unit)
+}
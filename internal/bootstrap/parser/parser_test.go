@@ -0,0 +1,410 @@
+package parser
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vedadiyan/iceberg/internal/bootstrap"
+	"github.com/vedadiyan/iceberg/internal/bootstrap/server"
+	"github.com/vedadiyan/iceberg/internal/callers/filters"
+	"github.com/vedadiyan/iceberg/internal/callers/proxies"
+	"github.com/vedadiyan/iceberg/internal/common/netio"
+	"github.com/vedadiyan/iceberg/internal/common/retry"
+	"github.com/vedadiyan/iceberg/internal/common/router"
+	"github.com/vedadiyan/iceberg/internal/common/transport"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseExchangeV1(t *testing.T) {
+	tests := []struct {
+		name                 string
+		level                netio.Level
+		exchange             ExchangeV1
+		wantRequestUpdaters  int
+		wantResponseUpdaters int
+	}{
+		{name: "nothing", level: netio.LEVEL_REQUEST},
+		{name: "request headers and body", level: netio.LEVEL_REQUEST, exchange: ExchangeV1{Headers: []string{"X-User-*"}, Body: true}, wantRequestUpdaters: 2},
+		{name: "connect headers", level: netio.LEVEL_CONNECT, exchange: ExchangeV1{Headers: []string{"*"}}, wantRequestUpdaters: 1},
+		{name: "response headers and body", level: netio.LEVEL_RESPONSE, exchange: ExchangeV1{Headers: []string{"*"}, Body: true, Trailers: []string{"*"}}, wantResponseUpdaters: 3},
+		{
+			name:  "request only settings",
+			level: netio.LEVEL_REQUEST,
+			exchange: ExchangeV1{
+				Trailers:      []string{"X-Checksum"},
+				Query:         []string{"page"},
+				RouteValues:   []string{"id"},
+				URL:           true,
+				Form:          true,
+				MultipartForm: true,
+			},
+			wantRequestUpdaters: 6,
+		},
+		{
+			name:  "request only settings on a response filter",
+			level: netio.LEVEL_RESPONSE,
+			exchange: ExchangeV1{
+				Query:         []string{"page"},
+				RouteValues:   []string{"id"},
+				URL:           true,
+				Form:          true,
+				MultipartForm: true,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := filters.NewFilter()
+			filter.Level = test.level
+			ParseExchangeV1(filter, test.exchange)
+			if len(filter.RequestUpdaters) != test.wantRequestUpdaters {
+				t.Fatalf("expected %d request updaters, got %d", test.wantRequestUpdaters, len(filter.RequestUpdaters))
+			}
+			if len(filter.ResponseUpdaters) != test.wantResponseUpdaters {
+				t.Fatalf("expected %d response updaters, got %d", test.wantResponseUpdaters, len(filter.ResponseUpdaters))
+			}
+		})
+	}
+}
+
+func TestParseOnErrorV1(t *testing.T) {
+	tests := []struct {
+		name           string
+		in             string
+		wantPolicy     filters.Policy
+		wantConditions []filters.Condition
+		wantStatus     int
+		wantFallback   bool
+		wantErr        bool
+	}{
+		{name: "empty", in: `name: auth`, wantPolicy: filters.POLICY_DEFAULT},
+		{name: "shorthand", in: `onError: continue`, wantPolicy: filters.POLICY_CONTINUE},
+		{name: "conditions", in: "onError:\n  policy: continue\n  on: [timeout, 5xx]", wantPolicy: filters.POLICY_CONTINUE, wantConditions: []filters.Condition{filters.CONDITION_TIMEOUT, filters.CONDITION_5XX}},
+		{name: "static fallback", in: "onError:\n  policy: fallback\n  fallback:\n    status: 202\n    body: queued", wantPolicy: filters.POLICY_FALLBACK, wantStatus: 202},
+		{name: "fallback filter", in: "onError:\n  policy: fallback\n  fallback:\n    addr: http://fallback:8080", wantPolicy: filters.POLICY_FALLBACK, wantFallback: true},
+		{name: "fallback without fallback", in: `onError: fallback`, wantErr: true},
+		{name: "unknown policy", in: `onError: retry`, wantErr: true},
+		{name: "unknown condition", in: "onError:\n  policy: continue\n  on: [teapot]", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var filter FilterV1
+			if err := yaml.Unmarshal([]byte(test.in), &filter); err != nil {
+				t.Fatal(err)
+			}
+			onError, err := ParseOnErrorV1(filter, netio.LEVEL_REQUEST)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			defer onError.Close()
+			if onError.Policy != test.wantPolicy {
+				t.Fatalf("expected policy %s, got %s", test.wantPolicy, onError.Policy)
+			}
+			if len(onError.Conditions) != len(test.wantConditions) {
+				t.Fatalf("expected conditions %v, got %v", test.wantConditions, onError.Conditions)
+			}
+			for i, condition := range test.wantConditions {
+				if onError.Conditions[i] != condition {
+					t.Fatalf("expected conditions %v, got %v", test.wantConditions, onError.Conditions)
+				}
+			}
+			if (onError.Fallback != nil) != test.wantFallback {
+				t.Fatalf("expected fallback filter %v, got %v", test.wantFallback, onError.Fallback != nil)
+			}
+			if test.wantStatus != 0 && (onError.Response == nil || onError.Response.Status != test.wantStatus) {
+				t.Fatalf("expected a static response with status %d", test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestParseCorsV1(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    *bootstrap.CORS
+		wantErr bool
+	}{
+		{name: "none", in: `frontend: /users`},
+		{name: "default", in: `use: {cors: default}`, want: &bootstrap.CORS{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}, AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}, ExposedHeaders: []string{"*"}, MaxAge: 3628800}},
+		{name: "unknown mode", in: `use: {cors: strict}`, wantErr: true},
+		{
+			name: "policy",
+			in: `
+use:
+  cors:
+    allowedOrigins: https://app.example.com, https://*.example.org
+    allowedMethods: [get, post]
+    allowedHeaders: [Authorization]
+    exposedHeaders: X-Total
+    maxAge: 600
+    allowCredentials: true`,
+			want: &bootstrap.CORS{
+				AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+				AllowedMethods:   []string{"GET", "POST"},
+				AllowedHeaders:   []string{"Authorization"},
+				ExposedHeaders:   []string{"X-Total"},
+				MaxAge:           600,
+				AllowCredentials: true,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var resource ResourceV1
+			if err := yaml.Unmarshal([]byte(test.in), &resource); err != nil {
+				t.Fatal(err)
+			}
+			option, err := ParseCorsV1(resource)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if option == nil {
+				if test.want != nil {
+					t.Fatalf("expected a cors option")
+				}
+				return
+			}
+			var opt bootstrap.Options
+			option(&opt, router.NewRouteTable(), &url.URL{Path: "/users"}, func(w http.ResponseWriter, r *http.Request, rv bootstrap.RouteValues) {})
+			if !reflect.DeepEqual(opt.CORS, test.want) {
+				t.Fatalf("expected %+v, got %+v", test.want, opt.CORS)
+			}
+		})
+	}
+}
+
+func TestParseListenV1(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    *server.Listener
+		wantErr bool
+	}{
+		{name: "address", in: `':8080'`, want: &server.Listener{Addr: ":8080", GracePeriod: server.GRACE_PERIOD}},
+		{
+			name: "tls",
+			in:   "addr: ':8443'\ntls:\n  cert: server.pem\n  key: server.key\n  minVersion: '1.3'",
+			want: &server.Listener{Addr: ":8443", GracePeriod: server.GRACE_PERIOD, TLS: &server.TLS{CertFile: "server.pem", KeyFile: "server.key", MinVersion: tls.VersionTLS13}},
+		},
+		{
+			name: "client ca requires client certificates",
+			in:   "addr: ':8443'\ntls:\n  cert: server.pem\n  key: server.key\n  clientCA: ca.pem",
+			want: &server.Listener{Addr: ":8443", GracePeriod: server.GRACE_PERIOD, TLS: &server.TLS{CertFile: "server.pem", KeyFile: "server.key", ClientCAFile: "ca.pem", ClientAuth: tls.RequireAndVerifyClientCert, MinVersion: tls.VersionTLS12}},
+		},
+		{
+			name: "ciphers",
+			in:   "addr: ':8443'\ntls:\n  cert: server.pem\n  key: server.key\n  clientAuth: request\n  ciphers: TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+			want: &server.Listener{Addr: ":8443", GracePeriod: server.GRACE_PERIOD, TLS: &server.TLS{CertFile: "server.pem", KeyFile: "server.key", ClientAuth: tls.RequestClientCert, MinVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}}},
+		},
+		{name: "grace period", in: "addr: ':8080'\ngracePeriod: 5s", want: &server.Listener{Addr: ":8080", GracePeriod: time.Second * 5}},
+		{name: "invalid grace period", in: "addr: ':8080'\ngracePeriod: soon", wantErr: true},
+		{name: "missing key", in: "addr: ':8443'\ntls:\n  cert: server.pem", wantErr: true},
+		{name: "verification without client ca", in: "addr: ':8443'\ntls:\n  cert: server.pem\n  key: server.key\n  clientAuth: require", wantErr: true},
+		{name: "insecure cipher", in: "addr: ':8443'\ntls:\n  cert: server.pem\n  key: server.key\n  ciphers: TLS_RSA_WITH_RC4_128_SHA", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var listen ListenV1
+			if err := yaml.Unmarshal([]byte(test.in), &listen); err != nil {
+				t.Fatal(err)
+			}
+			listener, err := ParseListenV1(listen)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if test.wantErr {
+				return
+			}
+			if listener.Addr != test.want.Addr || listener.GracePeriod != test.want.GracePeriod || !reflect.DeepEqual(listener.TLS, test.want.TLS) {
+				t.Fatalf("expected %+v, got %+v", test.want, listener)
+			}
+		})
+	}
+}
+
+func TestParseListenTimeoutsV1(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    [4]time.Duration
+		wantErr bool
+	}{
+		{name: "defaults", in: `':8080'`, want: [4]time.Duration{server.READ_TIMEOUT, server.READ_HEADER_TIMEOUT, server.WRITE_TIMEOUT, server.IDLE_TIMEOUT}},
+		{name: "custom", in: "addr: ':8080'\ntimeouts:\n  read: 1m\n  readHeader: 2s\n  write: 5m\n  idle: 30s", want: [4]time.Duration{time.Minute, time.Second * 2, time.Minute * 5, time.Second * 30}},
+		{name: "disabled", in: "addr: ':8080'\ntimeouts:\n  read: none\n  write: off", want: [4]time.Duration{0, server.READ_HEADER_TIMEOUT, 0, server.IDLE_TIMEOUT}},
+		{name: "invalid", in: "addr: ':8080'\ntimeouts:\n  write: forever", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var listen ListenV1
+			if err := yaml.Unmarshal([]byte(test.in), &listen); err != nil {
+				t.Fatal(err)
+			}
+			listener, err := ParseListenV1(listen)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if test.wantErr {
+				return
+			}
+			timeouts := [4]time.Duration{listener.ReadTimeout, listener.ReadHeaderTimeout, listener.WriteTimeout, listener.IdleTimeout}
+			if timeouts != test.want {
+				t.Fatalf("expected %v, got %v", test.want, timeouts)
+			}
+		})
+	}
+}
+
+func TestParseTimeoutsV1(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeouts TimeoutsV1
+		backend  string
+		want     *bootstrap.Timeouts
+		wantErr  bool
+	}{
+		{name: "none", backend: "http://users", want: &bootstrap.Timeouts{}},
+		{name: "http", timeouts: TimeoutsV1{Request: "5s", Read: "1m", Write: "none"}, backend: "http://users", want: &bootstrap.Timeouts{Request: time.Second * 5, Read: time.Minute, Write: bootstrap.NO_DEADLINE}},
+		{name: "websocket defaults", backend: "ws://chat", want: &bootstrap.Timeouts{Read: bootstrap.NO_DEADLINE, Write: bootstrap.NO_DEADLINE}},
+		{name: "websocket", timeouts: TimeoutsV1{Read: "1h"}, backend: "wss://chat", want: &bootstrap.Timeouts{Read: time.Hour, Write: bootstrap.NO_DEADLINE}},
+		{name: "invalid", timeouts: TimeoutsV1{Request: "later"}, backend: "http://users", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend, err := url.Parse(test.backend)
+			if err != nil {
+				t.Fatal(err)
+			}
+			timeouts, err := ParseTimeoutsV1(test.timeouts, backend)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if !test.wantErr && !reflect.DeepEqual(timeouts, test.want) {
+				t.Fatalf("expected %+v, got %+v", test.want, timeouts)
+			}
+		})
+	}
+}
+
+func TestParseTransportV1(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    *transport.Options
+		wantErr bool
+	}{
+		{name: "none", in: "", want: nil},
+		{name: "pool", in: "maxIdleConns: 10\nmaxIdleConnsPerHost: 5\nmaxConnsPerHost: 20\nidleConnTimeout: 1m", want: &transport.Options{MaxIdleConns: 10, MaxIdleConnsPerHost: 5, MaxConnsPerHost: 20, IdleConnTimeout: time.Minute}},
+		{name: "http2 disabled", in: "http2: false", want: &transport.Options{DisableHTTP2: true}},
+		{name: "http2 enabled", in: "http2: true", want: &transport.Options{}},
+		{name: "tls", in: "tls:\n  ca: /etc/ca.pem\n  serverName: backend", want: &transport.Options{CAFile: "/etc/ca.pem", ServerName: "backend"}},
+		{name: "invalid timeout", in: "dialTimeout: soon", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var in *TransportV1
+			if len(test.in) != 0 {
+				in = new(TransportV1)
+				if err := yaml.Unmarshal([]byte(test.in), in); err != nil {
+					t.Fatal(err)
+				}
+			}
+			options, err := ParseTransportV1(in)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if !test.wantErr && !reflect.DeepEqual(options, test.want) {
+				t.Fatalf("expected %+v, got %+v", test.want, options)
+			}
+		})
+	}
+}
+
+func TestParseRetryBudgetV1(t *testing.T) {
+	tests := []struct {
+		name             string
+		in               string
+		wantRatio        float64
+		wantMinPerSecond int
+	}{
+		{name: "defaults", wantRatio: retry.BUDGET_RATIO, wantMinPerSecond: retry.BUDGET_MIN_PER_SECOND},
+		{name: "ratio", in: "ratio: 0.5", wantRatio: 0.5, wantMinPerSecond: retry.BUDGET_MIN_PER_SECOND},
+		{name: "no retries", in: "ratio: 0\nminPerSecond: 0"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var in *RetryBudgetV1
+			if len(test.in) != 0 {
+				in = new(RetryBudgetV1)
+				if err := yaml.Unmarshal([]byte(test.in), in); err != nil {
+					t.Fatal(err)
+				}
+			}
+			budget := ParseRetryBudgetV1(in)
+			if budget.Ratio != test.wantRatio || budget.MinPerSecond != test.wantMinPerSecond {
+				t.Fatalf("expected %v and %d, got %v and %d", test.wantRatio, test.wantMinPerSecond, budget.Ratio, budget.MinPerSecond)
+			}
+			policy, err := ParseRetryV1(&RetryV1{}, budget)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy.Budget != budget {
+				t.Fatalf("expected the policy to use the budget")
+			}
+		})
+	}
+}
+
+func TestParseBackendV1(t *testing.T) {
+	tests := []struct {
+		name         string
+		in           string
+		wantHosts    []string
+		wantWeights  []int
+		wantStrategy proxies.Strategy
+		wantEjection proxies.Ejection
+		wantErr      bool
+	}{
+		{name: "single", in: "backend: http://users", wantHosts: []string{"users"}, wantWeights: []int{1}, wantEjection: proxies.Ejection{Failures: proxies.EJECTION_FAILURES, Duration: proxies.EJECTION_DURATION}},
+		{name: "weighted", in: "backend:\n  - url: http://a\n    weight: 3\n  - http://b", wantHosts: []string{"a", "b"}, wantWeights: []int{3, 1}, wantEjection: proxies.Ejection{Failures: proxies.EJECTION_FAILURES, Duration: proxies.EJECTION_DURATION}},
+		{name: "load balancer", in: "backend: [http://a, http://b]\nloadBalancer:\n  strategy: leastRequests\n  ejection:\n    failures: 2\n    duration: 1m", wantHosts: []string{"a", "b"}, wantWeights: []int{1, 1}, wantStrategy: proxies.STRATEGY_LEAST_REQUESTS, wantEjection: proxies.Ejection{Failures: 2, Duration: time.Minute}},
+		{name: "unknown strategy", in: "backend: [http://a, http://b]\nloadBalancer:\n  strategy: random", wantErr: true},
+		{name: "invalid ejection duration", in: "backend: http://a\nloadBalancer:\n  ejection:\n    duration: later", wantErr: true},
+		{name: "no backend", in: "frontend: /", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var resource ResourceV1
+			if err := yaml.Unmarshal([]byte(test.in), &resource); err != nil {
+				t.Fatal(err)
+			}
+			balancer, err := ParseBackendV1(resource.Backend, resource.LoadBalancer)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if test.wantErr {
+				return
+			}
+			hosts := make([]string, 0)
+			weights := make([]int, 0)
+			for _, endpoint := range balancer.Endpoints {
+				hosts = append(hosts, endpoint.Address.Host)
+				weights = append(weights, endpoint.Weight)
+			}
+			if !reflect.DeepEqual(hosts, test.wantHosts) || !reflect.DeepEqual(weights, test.wantWeights) {
+				t.Fatalf("expected %v %v, got %v %v", test.wantHosts, test.wantWeights, hosts, weights)
+			}
+			if balancer.Strategy != test.wantStrategy || balancer.Ejection != test.wantEjection {
+				t.Fatalf("expected %v %v, got %v %v", test.wantStrategy, test.wantEjection, balancer.Strategy, balancer.Ejection)
+			}
+		})
+	}
+}
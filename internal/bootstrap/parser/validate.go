@@ -0,0 +1,979 @@
+package parser
+
+import (
+	cryptotls "crypto/tls"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/vedadiyan/iceberg/internal/bootstrap/server"
+	"github.com/vedadiyan/iceberg/internal/callers/filters"
+	"github.com/vedadiyan/iceberg/internal/callers/proxies"
+	"github.com/vedadiyan/iceberg/internal/common/retry"
+	"github.com/vedadiyan/iceberg/internal/common/router"
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	ValidationError struct {
+		Line    int
+		Column  int
+		Message string
+	}
+	validator struct {
+		errors []*ValidationError
+	}
+)
+
+var (
+	_syntaxLine  = regexp.MustCompile(`line (\d+)`)
+	_unmarshaler = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+	_node        = reflect.TypeOf(yaml.Node{})
+)
+
+func (validationError *ValidationError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", validationError.Line, validationError.Column, validationError.Message)
+}
+
+func Validate(in []byte) []*ValidationError {
+	v := new(validator)
+	var doc yaml.Node
+	err := yaml.Unmarshal(in, &doc)
+	if err != nil {
+		line := 0
+		if match := _syntaxLine.FindStringSubmatch(err.Error()); match != nil {
+			line, _ = strconv.Atoi(match[1])
+		}
+		return []*ValidationError{{Line: line, Message: err.Error()}}
+	}
+	if len(doc.Content) == 0 {
+		return []*ValidationError{{Message: "empty configuration"}}
+	}
+	root := resolve(doc.Content[0])
+	v.walk(root, reflect.TypeOf(Config{}))
+	apiVersion, found := lookup(root, "apiVersion")
+	if !found {
+		v.report(root, "missing required key %q", "apiVersion")
+		return v.sorted()
+	}
+	spec, found := lookup(root, "spec")
+	if !found {
+		v.report(root, "missing required key %q", "spec")
+		return v.sorted()
+	}
+	switch strings.ToLower(apiVersion.Value) {
+	case "apps/v1":
+		{
+			v.walk(spec, reflect.TypeOf(SpecV1{}))
+			v.specV1(spec)
+		}
+	default:
+		{
+			v.report(apiVersion, "unsupported apiVersion %q", apiVersion.Value)
+		}
+	}
+	return v.sorted()
+}
+
+func (v *validator) report(node *yaml.Node, format string, args ...any) {
+	v.errors = append(v.errors, &ValidationError{
+		Line:    node.Line,
+		Column:  node.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) sorted() []*ValidationError {
+	sort.SliceStable(v.errors, func(i, j int) bool {
+		if v.errors[i].Line != v.errors[j].Line {
+			return v.errors[i].Line < v.errors[j].Line
+		}
+		return v.errors[i].Column < v.errors[j].Column
+	})
+	return v.errors
+}
+
+func (v *validator) walk(node *yaml.Node, t reflect.Type) {
+	node = resolve(node)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == _node || t.Kind() == reflect.Interface || isNull(node) {
+		return
+	}
+	if reflect.PointerTo(t).Implements(_unmarshaler) && (t.Kind() != reflect.Struct || node.Kind != yaml.MappingNode) {
+		err := node.Decode(reflect.New(t).Interface())
+		if err != nil {
+			v.report(node, "%s", strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		{
+			if node.Kind != yaml.MappingNode {
+				v.report(node, "expected a mapping")
+				return
+			}
+			fields := fields(t)
+			for i := 0; i < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				field, ok := fields[key.Value]
+				if !ok {
+					v.unknown(key, fields)
+					continue
+				}
+				v.walk(value, field)
+			}
+		}
+	case reflect.Map:
+		{
+			if node.Kind != yaml.MappingNode {
+				v.report(node, "expected a mapping")
+				return
+			}
+			for i := 1; i < len(node.Content); i += 2 {
+				v.walk(node.Content[i], t.Elem())
+			}
+		}
+	case reflect.Slice:
+		{
+			if node.Kind != yaml.SequenceNode {
+				v.report(node, "expected a sequence")
+				return
+			}
+			for _, item := range node.Content {
+				v.walk(item, t.Elem())
+			}
+		}
+	default:
+		{
+			if node.Kind != yaml.ScalarNode {
+				v.report(node, "expected a %s value", t.Kind())
+				return
+			}
+			err := node.Decode(reflect.New(t).Interface())
+			if err != nil {
+				v.report(node, "expected a %s value but found %q", t.Kind(), node.Value)
+			}
+		}
+	}
+}
+
+func (v *validator) unknown(key *yaml.Node, fields map[string]reflect.Type) {
+	suggestion := ""
+	distance := 3
+	for name := range fields {
+		d := levenshtein(strings.ToLower(key.Value), strings.ToLower(name))
+		if d < distance || (d == distance && len(suggestion) != 0 && name < suggestion) {
+			distance = d
+			suggestion = name
+		}
+	}
+	if len(suggestion) != 0 {
+		v.report(key, "unknown key %q, did you mean %q?", key.Value, suggestion)
+		return
+	}
+	v.report(key, "unknown key %q", key.Value)
+}
+
+func (v *validator) specV1(node *yaml.Node) {
+	if listen, found := lookup(node, "listen"); found && listen.Kind == yaml.MappingNode {
+		v.listenV1(listen)
+	}
+	if admin, found := lookup(node, "admin"); found && admin.Kind == yaml.MappingNode {
+		v.listenV1(admin)
+	}
+	if retryBudget, found := lookup(node, "retryBudget"); found {
+		v.retryBudgetV1(retryBudget)
+	}
+	resources, found := lookup(node, "resources")
+	if !found || resources.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i < len(resources.Content); i += 2 {
+		v.resourceV1(resources.Content[i], resolve(resources.Content[i+1]))
+	}
+}
+
+func (v *validator) listenV1(node *yaml.Node) {
+	if gracePeriod, found := lookup(node, "gracePeriod"); found {
+		v.duration(gracePeriod)
+	}
+	if timeouts, found := lookup(node, "timeouts"); found && timeouts.Kind == yaml.MappingNode {
+		for i := 1; i < len(timeouts.Content); i += 2 {
+			v.deadline(timeouts.Content[i])
+		}
+	}
+	if trustedProxies, found := lookup(node, "trustedProxies"); found {
+		var values ListV1
+		if trustedProxies.Decode(&values) == nil {
+			for _, value := range values {
+				if _, err := server.ParseTrustedProxies([]string{value}); err != nil {
+					v.report(trustedProxies, "%s, expected a CIDR or an address", err.Error())
+				}
+			}
+		}
+	}
+	tlsNode, found := lookup(node, "tls")
+	if !found {
+		return
+	}
+	var tls TLSV1
+	if tlsNode.Decode(&tls) != nil {
+		return
+	}
+	if len(tls.Cert) == 0 || len(tls.Key) == 0 {
+		v.report(tlsNode, "tls requires both cert and key")
+	}
+	if minVersion, found := lookup(tlsNode, "minVersion"); found {
+		if _, err := server.ParseTLSVersion(minVersion.Value); err != nil {
+			v.report(minVersion, "unsupported minVersion %q, expected 1.0, 1.1, 1.2 or 1.3", minVersion.Value)
+		}
+	}
+	if ciphers, found := lookup(tlsNode, "ciphers"); found {
+		for _, name := range tls.Ciphers {
+			if _, err := server.ParseCipherSuite(name); err != nil {
+				v.report(ciphers, "%s", err.Error())
+			}
+		}
+	}
+	clientAuth, found := lookup(tlsNode, "clientAuth")
+	if !found {
+		return
+	}
+	value, err := server.ParseClientAuth(clientAuth.Value)
+	if err != nil {
+		v.report(clientAuth, "unsupported clientAuth %q, expected none, request, verify-if-given or require", clientAuth.Value)
+		return
+	}
+	if value >= cryptotls.VerifyClientCertIfGiven && len(tls.ClientCA) == 0 {
+		v.report(clientAuth, "clientAuth %q requires clientCA", clientAuth.Value)
+	}
+}
+
+func (v *validator) resourceV1(key *yaml.Node, node *yaml.Node) {
+	var resource ResourceV1
+	if node.Decode(&resource) != nil {
+		return
+	}
+	if frontend, found := lookup(node, "frontend"); !found || len(frontend.Value) == 0 {
+		if !resource.Default {
+			v.report(key, "resource %q has no frontend", key.Value)
+		}
+	} else if !strings.HasPrefix(frontend.Value, "/") {
+		v.report(frontend, "frontend must start with /")
+	} else if err := router.ValidateRoute(frontend.Value); err != nil {
+		v.report(frontend, "%s", err.Error())
+	}
+	if backend, found := lookup(node, "backend"); !found || len(resource.Backend) == 0 || (backend.Kind == yaml.ScalarNode && len(backend.Value) == 0) {
+		v.report(key, "resource %q has no backend", key.Value)
+	} else {
+		v.backendV1(backend)
+	}
+	if loadBalancer, found := lookup(node, "loadBalancer"); found {
+		v.loadBalancerV1(loadBalancer)
+	}
+	if healthCheck, found := lookup(node, "healthCheck"); found {
+		v.healthCheckV1(healthCheck)
+	}
+	if policy, found := lookup(node, "retry"); found {
+		v.retryV1(policy)
+	}
+	if breaker, found := lookup(node, "circuitBreaker"); found {
+		v.breakerV1(breaker)
+	}
+	if method, found := lookup(node, "method"); found {
+		v.methods(method, resource.Method)
+	}
+	if match, found := lookup(node, "match"); found {
+		v.matchV1(match)
+	}
+	if rewrite, found := lookup(node, "rewrite"); found {
+		v.rewriteV1(rewrite)
+	}
+	if body, found := lookup(node, "body"); found {
+		v.bodyV1(body)
+	}
+	if transport, found := lookup(node, "transport"); found {
+		v.transportV1(transport)
+	}
+	if timeouts, found := lookup(node, "timeouts"); found {
+		v.timeoutsV1(timeouts)
+	}
+	if use, found := lookup(node, "use"); found {
+		v.useV1(use)
+	}
+	if filters, found := lookup(node, "filters"); found && filters.Kind == yaml.SequenceNode {
+		v.filtersV1(filters, resource.Filters, false)
+	}
+}
+
+func (v *validator) backendV1(node *yaml.Node) {
+	if node.Kind != yaml.SequenceNode {
+		v.address(node, "http", "https", "ws", "wss")
+		return
+	}
+	schemes := make(map[bool]bool)
+	for _, item := range node.Content {
+		item = resolve(item)
+		addr := item
+		if item.Kind == yaml.MappingNode {
+			v.walk(item, reflect.TypeOf(EndpointV1{}))
+			url, found := lookup(item, "url")
+			if !found || len(url.Value) == 0 {
+				v.report(item, "endpoint has no url")
+				continue
+			}
+			addr = url
+			if weight, found := lookup(item, "weight"); found {
+				if n, err := strconv.Atoi(weight.Value); err == nil && n < 0 {
+					v.report(weight, "weight must not be negative")
+				}
+			}
+		}
+		v.address(addr, "http", "https", "ws", "wss")
+		if url, err := Address(addr.Value); err == nil {
+			schemes[strings.HasPrefix(strings.ToLower(url.Scheme), "ws")] = true
+		}
+	}
+	if len(schemes) > 1 {
+		v.report(node, "endpoints cannot mix http and ws schemes")
+	}
+}
+
+func (v *validator) loadBalancerV1(node *yaml.Node) {
+	strategy := proxies.STRATEGY_ROUND_ROBIN
+	if value, found := lookup(node, "strategy"); found {
+		parsed, err := proxies.ParseStrategy(value.Value)
+		if err != nil {
+			v.report(value, "unsupported strategy %q, expected roundRobin, leastRequests or hash", value.Value)
+		}
+		strategy = parsed
+	}
+	hash, found := lookup(node, "hash")
+	switch {
+	case strategy == proxies.STRATEGY_HASH && !found:
+		{
+			v.report(node, "the hash strategy requires a hash key")
+		}
+	case strategy != proxies.STRATEGY_HASH && found:
+		{
+			v.report(hash, "hash is only used by the hash strategy")
+		}
+	case found:
+		{
+			var hashKey HashV1
+			if hash.Decode(&hashKey) == nil && len(hashKey.Header) == 0 && len(hashKey.Cookie) == 0 && len(hashKey.RouteValue) == 0 {
+				v.report(hash, "hash requires a header, cookie or routeValue")
+			}
+		}
+	}
+	if ejection, found := lookup(node, "ejection"); found {
+		if failures, found := lookup(ejection, "failures"); found {
+			if n, err := strconv.Atoi(failures.Value); err == nil && n < 0 {
+				v.report(failures, "failures must not be negative")
+			}
+		}
+		if duration, found := lookup(ejection, "duration"); found {
+			v.duration(duration)
+		}
+	}
+}
+
+func (v *validator) healthCheckV1(node *yaml.Node) {
+	if path, found := lookup(node, "path"); found && len(path.Value) != 0 && !strings.HasPrefix(path.Value, "/") {
+		v.report(path, "path must start with /")
+	}
+	if status, found := lookup(node, "status"); found {
+		var statuses ListV1
+		if status.Decode(&statuses) == nil {
+			for _, value := range statuses {
+				if n, err := strconv.Atoi(value); err != nil || n < 100 || n > 599 {
+					v.report(status, "invalid status %q", value)
+				}
+			}
+		}
+	}
+	for _, key := range []string{"interval", "timeout"} {
+		if duration, found := lookup(node, key); found {
+			v.duration(duration)
+		}
+	}
+	for _, key := range []string{"healthyThreshold", "unhealthyThreshold"} {
+		if value, found := lookup(node, key); found {
+			if n, err := strconv.Atoi(value.Value); err == nil && n < 0 {
+				v.report(value, "%s must not be negative", key)
+			}
+		}
+	}
+}
+
+func (v *validator) retryV1(node *yaml.Node) {
+	if attempts, found := lookup(node, "attempts"); found {
+		if n, err := strconv.Atoi(attempts.Value); err == nil && n < 0 {
+			v.report(attempts, "attempts must not be negative")
+		}
+	}
+	for _, key := range []string{"perTryTimeout", "backoff", "maxBackoff"} {
+		if duration, found := lookup(node, key); found {
+			v.duration(duration)
+		}
+	}
+	var policy RetryV1
+	if node.Decode(&policy) != nil {
+		return
+	}
+	if on, found := lookup(node, "on"); found {
+		for _, value := range policy.On {
+			if status, err := strconv.Atoi(value); err == nil {
+				if status < 100 || status > 599 {
+					v.report(on, "invalid status %q", value)
+				}
+				continue
+			}
+			if _, err := retry.ParseCondition(value); err != nil {
+				v.report(on, "unsupported retry condition %q, expected connect, reset, timeout, noResponders or a status code", value)
+			}
+		}
+	}
+	if methods, found := lookup(node, "methods"); found {
+		v.methods(methods, policy.Methods)
+	}
+}
+
+func (v *validator) breakerV1(node *yaml.Node) {
+	for _, key := range []string{"consecutiveFailures", "minRequests", "halfOpenRequests"} {
+		if value, found := lookup(node, key); found {
+			if n, err := strconv.Atoi(value.Value); err == nil && n < 0 {
+				v.report(value, "%s must not be negative", key)
+			}
+		}
+	}
+	if errorRate, found := lookup(node, "errorRate"); found {
+		if n, err := strconv.ParseFloat(errorRate.Value, 64); err == nil && (n < 0 || n > 1) {
+			v.report(errorRate, "errorRate must be between 0 and 1")
+		}
+	}
+	for _, key := range []string{"window", "openDuration"} {
+		if duration, found := lookup(node, key); found {
+			v.duration(duration)
+		}
+	}
+}
+
+func (v *validator) retryBudgetV1(node *yaml.Node) {
+	if ratio, found := lookup(node, "ratio"); found {
+		if n, err := strconv.ParseFloat(ratio.Value, 64); err == nil && n < 0 {
+			v.report(ratio, "ratio must not be negative")
+		}
+	}
+	if minPerSecond, found := lookup(node, "minPerSecond"); found {
+		if n, err := strconv.Atoi(minPerSecond.Value); err == nil && n < 0 {
+			v.report(minPerSecond, "minPerSecond must not be negative")
+		}
+	}
+}
+
+func (v *validator) matchV1(node *yaml.Node) {
+	if host, found := lookup(node, "host"); found && len(host.Value) != 0 {
+		name := strings.TrimPrefix(host.Value, "*.")
+		if len(name) == 0 || strings.ContainsAny(name, "*:/ ") {
+			v.report(host, "host must be a hostname or a *. wildcard without a port")
+		}
+	}
+	for _, key := range []string{"headers", "query", "cookies"} {
+		predicates, found := lookup(node, key)
+		if !found || predicates.Kind != yaml.MappingNode {
+			continue
+		}
+		for index := 0; index < len(predicates.Content); index += 2 {
+			if len(predicates.Content[index].Value) == 0 {
+				v.report(predicates.Content[index], "%s predicate has no name", key)
+			}
+		}
+	}
+}
+
+func (v *validator) rewriteV1(node *yaml.Node) {
+	for _, key := range []string{"stripPrefix", "addPrefix"} {
+		if prefix, found := lookup(node, key); found && len(prefix.Value) != 0 && !strings.HasPrefix(prefix.Value, "/") {
+			v.report(prefix, "%s must start with /", key)
+		}
+	}
+	if regex, found := lookup(node, "regex"); found && regex.Kind == yaml.SequenceNode {
+		for _, item := range regex.Content {
+			match, found := lookup(item, "match")
+			if !found || len(match.Value) == 0 {
+				v.report(item, "regex rewrite has no match")
+				continue
+			}
+			if _, err := regexp.Compile(match.Value); err != nil {
+				v.report(match, "%s", err.Error())
+			}
+		}
+	}
+}
+
+func (v *validator) bodyV1(node *yaml.Node) {
+	for _, key := range []string{"memory", "maxSize"} {
+		if size, found := lookup(node, key); found {
+			v.size(size)
+		}
+	}
+}
+
+func (v *validator) transportV1(node *yaml.Node) {
+	for _, key := range []string{"maxIdleConns", "maxIdleConnsPerHost", "maxConnsPerHost"} {
+		if value, found := lookup(node, key); found {
+			if n, err := strconv.Atoi(value.Value); err == nil && n < 0 {
+				v.report(value, "%s must not be negative", key)
+			}
+		}
+	}
+	for _, key := range []string{"idleConnTimeout", "keepAlive", "dialTimeout", "tlsHandshakeTimeout", "responseHeaderTimeout"} {
+		if timeout, found := lookup(node, key); found {
+			v.duration(timeout)
+		}
+	}
+	tls, found := lookup(node, "tls")
+	if !found {
+		return
+	}
+	cert, hasCert := lookup(tls, "cert")
+	key, hasKey := lookup(tls, "key")
+	switch {
+	case hasCert && !hasKey:
+		{
+			v.report(cert, "a client cert requires a key")
+		}
+	case hasKey && !hasCert:
+		{
+			v.report(key, "a client key requires a cert")
+		}
+	}
+}
+
+func (v *validator) timeoutsV1(node *yaml.Node) {
+	for _, key := range []string{"backend", "request"} {
+		if timeout, found := lookup(node, key); found {
+			v.duration(timeout)
+		}
+	}
+	for _, key := range []string{"read", "write"} {
+		if timeout, found := lookup(node, key); found {
+			v.deadline(timeout)
+		}
+	}
+}
+
+func (v *validator) useV1(node *yaml.Node) {
+	if cache, found := lookup(node, "cache"); found {
+		if addr, found := lookup(cache, "addr"); found {
+			v.address(addr, "jetstream")
+		} else {
+			v.report(cache, "cache has no addr")
+		}
+		if ttl, found := lookup(cache, "ttl"); found {
+			v.duration(ttl)
+		}
+	}
+	if cors, found := lookup(node, "cors"); found {
+		v.corsV1(cors)
+	}
+	if opa, found := lookup(node, "opa"); found {
+		if agent, found := lookup(opa, "agent"); found {
+			v.address(agent, "nats")
+		} else {
+			v.report(opa, "opa has no agent")
+		}
+		policies := []string{"http", "ws.send", "ws.receive"}
+		for _, path := range policies {
+			if policy, found := lookup(opa, strings.Split(path, ".")...); found {
+				v.policies(policy)
+			}
+		}
+	}
+}
+
+func (v *validator) corsV1(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode {
+		if !strings.EqualFold(node.Value, "default") {
+			v.report(node, "unsupported cors policy %q, expected default or a custom policy", node.Value)
+		}
+		return
+	}
+	var cors CorsV1
+	if node.Decode(&cors) != nil {
+		return
+	}
+	if len(cors.AllowedOrigins) == 0 {
+		v.report(node, "cors policy has no allowedOrigins")
+	}
+	if origins, found := lookup(node, "allowedOrigins"); found {
+		for _, origin := range cors.AllowedOrigins {
+			if strings.Count(origin, "*") > 1 || (origin != "*" && strings.Contains(origin, "*") && !strings.Contains(origin, "://*.")) {
+				v.report(origins, "unsupported origin pattern %q, expected scheme://*.domain", origin)
+			}
+		}
+	}
+	if credentials, found := lookup(node, "allowCredentials"); found && cors.AllowCredentials && containsWildcard(cors.AllowedOrigins) {
+		v.report(credentials, "allowCredentials cannot be combined with the * origin, list the allowed origins instead")
+	}
+	if methods, found := lookup(node, "allowedMethods"); found {
+		for _, method := range cors.AllowedMethods {
+			if strings.ContainsAny(method, " \t") || len(method) == 0 {
+				v.report(methods, "invalid method %q", method)
+			}
+		}
+	}
+	if maxAge, found := lookup(node, "maxAge"); found && cors.MaxAge < 0 {
+		v.report(maxAge, "maxAge must not be negative")
+	}
+}
+
+func (v *validator) policies(node *yaml.Node) {
+	if node.Kind != yaml.SequenceNode {
+		return
+	}
+	for _, item := range node.Content {
+		item = resolve(item)
+		if item.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 1; i < len(item.Content); i += 2 {
+			value := resolve(item.Content[i])
+			switch strings.ToLower(value.Value) {
+			case "local", "remote":
+				{
+					continue
+				}
+			}
+			v.report(value, "unsupported policy type %q, expected local or remote", value.Value)
+		}
+	}
+}
+
+func (v *validator) filtersV1(node *yaml.Node, filters []FilterV1, callback bool) {
+	names := make(map[string]int)
+	for index, filter := range filters {
+		item := resolve(node.Content[index])
+		if len(filter.Name) == 0 {
+			v.report(item, "filter has no name")
+		} else if _, ok := names[filter.Name]; ok {
+			name, _ := lookup(item, "name")
+			v.report(name, "duplicate filter name %q", filter.Name)
+		} else {
+			names[filter.Name] = index
+		}
+		if addr, found := lookup(item, "addr"); found {
+			v.address(addr, "http", "https", "nats", "jetstream")
+		} else {
+			v.report(item, "filter %q has no addr", filter.Name)
+		}
+		level, found := lookup(item, "level")
+		switch {
+		case callback && found:
+			{
+				v.report(level, "level is not supported on next callbacks")
+			}
+		case !callback && !found:
+			{
+				v.report(item, "filter %q has no level", filter.Name)
+			}
+		case !callback:
+			{
+				if _, err := Level(level.Value); err != nil {
+					v.report(level, "unsupported level %q, expected connect, request or response", level.Value)
+				}
+			}
+		}
+		if timeout, found := lookup(item, "timeout"); found {
+			v.duration(timeout)
+		}
+		if transport, found := lookup(item, "transport"); found {
+			v.transportV1(transport)
+		}
+		if policy, found := lookup(item, "retry"); found {
+			v.retryV1(policy)
+		}
+		if breaker, found := lookup(item, "circuitBreaker"); found {
+			v.breakerV1(breaker)
+		}
+		if onError, found := lookup(item, "onError"); found {
+			v.onErrorV1(onError)
+		}
+		if exchange, found := lookup(item, "exchange"); found {
+			v.exchangeV1(exchange, filter.Level, callback)
+		}
+		if next, found := lookup(item, "next"); found && next.Kind == yaml.SequenceNode {
+			v.filtersV1(next, filter.Next, true)
+		}
+	}
+	v.await(node, filters, names)
+}
+
+func (v *validator) onErrorV1(node *yaml.Node) {
+	policy := node
+	if node.Kind == yaml.MappingNode {
+		policy, _ = lookup(node, "policy")
+	}
+	value := ""
+	if policy != nil {
+		value = policy.Value
+		if _, err := filters.ParsePolicy(value); err != nil {
+			v.report(policy, "unsupported onError policy %q, expected default, term, continue or fallback", value)
+		}
+	}
+	if on, found := lookup(node, "on"); found && on.Kind == yaml.SequenceNode {
+		for _, item := range on.Content {
+			if _, err := filters.ParseCondition(item.Value); err != nil {
+				v.report(item, "unsupported onError condition %q, expected timeout, transport, internal, status, 4xx, 5xx or open", item.Value)
+			}
+		}
+	}
+	fallback, found := lookup(node, "fallback")
+	isFallback := strings.EqualFold(value, string(FALLBACK))
+	switch {
+	case isFallback && !found:
+		{
+			v.report(node, "the fallback policy requires a fallback")
+		}
+	case !isFallback && found:
+		{
+			v.report(fallback, "fallback is only used by the fallback policy")
+		}
+	case found:
+		{
+			if addr, found := lookup(fallback, "addr"); found {
+				v.address(addr, "http", "https", "nats", "jetstream")
+			}
+			if timeout, found := lookup(fallback, "timeout"); found {
+				v.duration(timeout)
+			}
+			if transport, found := lookup(fallback, "transport"); found {
+				v.transportV1(transport)
+			}
+			if status, found := lookup(fallback, "status"); found {
+				if code, err := strconv.Atoi(status.Value); err == nil && (code < 100 || code > 599) {
+					v.report(status, "invalid status code %d", code)
+				}
+			}
+		}
+	}
+}
+
+func (v *validator) exchangeV1(node *yaml.Node, level string, callback bool) {
+	if callback {
+		v.report(node, "exchange is not supported on next callbacks")
+		return
+	}
+	if !strings.EqualFold(level, "response") {
+		var status bool
+		if value, found := lookup(node, "status"); found && value.Decode(&status) == nil && status {
+			v.report(value, "status exchange is only supported on response filters")
+		}
+		return
+	}
+	for _, key := range []string{"query", "routeValues", "url", "form", "multipartForm"} {
+		if value, found := lookup(node, key); found {
+			v.report(value, "%s exchange is not supported on response filters", key)
+		}
+	}
+}
+
+func (v *validator) await(node *yaml.Node, filters []FilterV1, names map[string]int) {
+	for index, filter := range filters {
+		awaitNode, found := lookup(resolve(node.Content[index]), "await")
+		if !found || awaitNode.Kind != yaml.SequenceNode {
+			continue
+		}
+		for i, name := range filter.Await {
+			item := awaitNode.Content[i]
+			target, ok := names[name]
+			if !ok {
+				v.report(item, "filter %q awaits %q which does not exist", filter.Name, name)
+				continue
+			}
+			if !filters[target].Async {
+				v.report(item, "filter %q awaits %q which is not async", filter.Name, name)
+			}
+		}
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(filters))
+	var visit func(index int, path []string) []string
+	visit = func(index int, path []string) []string {
+		state[index] = visiting
+		path = append(path, filters[index].Name)
+		for _, name := range filters[index].Await {
+			target, ok := names[name]
+			if !ok {
+				continue
+			}
+			switch state[target] {
+			case visiting:
+				{
+					return append(path, name)
+				}
+			case unvisited:
+				{
+					if cycle := visit(target, path); cycle != nil {
+						return cycle
+					}
+				}
+			}
+		}
+		state[index] = visited
+		return nil
+	}
+	for index := range filters {
+		if state[index] != unvisited {
+			continue
+		}
+		if cycle := visit(index, nil); cycle != nil {
+			awaitNode, _ := lookup(resolve(node.Content[index]), "await")
+			v.report(awaitNode, "await cycle %s", strings.Join(cycle, " -> "))
+			for i := range state {
+				if state[i] == visiting {
+					state[i] = visited
+				}
+			}
+		}
+	}
+}
+
+func (v *validator) address(node *yaml.Node, schemes ...string) {
+	url, err := url.Parse(_envHost.ReplaceAllString(node.Value, "env"))
+	if err != nil {
+		v.report(node, "invalid address %q", node.Value)
+		return
+	}
+	for _, scheme := range schemes {
+		if strings.EqualFold(url.Scheme, scheme) {
+			return
+		}
+	}
+	v.report(node, "unsupported scheme %q, expected one of %s", url.Scheme, strings.Join(schemes, ", "))
+}
+
+func (v *validator) duration(node *yaml.Node) {
+	_, err := Timeout(node.Value)
+	if err != nil {
+		v.report(node, "invalid duration %q, expected a number followed by ms, s, m or h", node.Value)
+	}
+}
+
+func (v *validator) size(node *yaml.Node) {
+	_, err := Size(node.Value)
+	if err != nil {
+		v.report(node, "invalid size %q, expected a number optionally followed by KB, MB or GB", node.Value)
+	}
+}
+
+func (v *validator) deadline(node *yaml.Node) {
+	_, err := Deadline(node.Value)
+	if err != nil {
+		v.report(node, "invalid timeout %q, expected a duration or none", node.Value)
+	}
+}
+
+func (v *validator) methods(node *yaml.Node, methods []string) {
+	for _, method := range methods {
+		if method == "*" {
+			continue
+		}
+		if len(method) == 0 || strings.IndexFunc(method, func(r rune) bool {
+			return r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("!#$%&'*+-.^_`|~", r))
+		}) != -1 {
+			v.report(node, "invalid method %q", method)
+		}
+	}
+}
+
+func lookup(node *yaml.Node, path ...string) (*yaml.Node, bool) {
+	node = resolve(node)
+	for _, key := range path {
+		if node.Kind != yaml.MappingNode {
+			return nil, false
+		}
+		found := false
+		for i := 0; i < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				node = resolve(node.Content[i+1])
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+func resolve(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
+}
+
+func fields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if len(name) == 0 {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func levenshtein(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minimum(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minimum(values ...int) int {
+	out := values[0]
+	for _, value := range values[1:] {
+		if value < out {
+			out = value
+		}
+	}
+	return out
+}
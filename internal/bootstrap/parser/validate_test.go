@@ -0,0 +1,226 @@
+package parser
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{
+			name: "valid",
+			in: `
+apiVersion: apps/v1
+spec:
+  listen: ':8080'
+  resources:
+    users:
+      frontend: /users/:id
+      backend: http://users:8080
+      filters:
+        - name: session
+          addr: http://auth:8080/session
+          level: request
+          async: true
+        - name: profile
+          addr: http://auth:8080/profile
+          level: request
+          await: [session]
+`,
+		},
+		{
+			name: "unknown top level key",
+			in: `
+apiVersio: apps/v1
+spec: {}
+`,
+			want: []string{
+				`2:1: unknown key "apiVersio", did you mean "apiVersion"?`,
+				`2:1: missing required key "apiVersion"`,
+			},
+		},
+		{
+			name: "unknown resource key",
+			in: `
+apiVersion: apps/v1
+spec:
+  resources:
+    users:
+      fronted: /users
+      backend: http://users:8080
+`,
+			want: []string{
+				`5:5: resource "users" has no frontend`,
+				`6:7: unknown key "fronted", did you mean "frontend"?`,
+			},
+		},
+		{
+			name: "unknown filter key",
+			in: `
+apiVersion: apps/v1
+spec:
+  resources:
+    users:
+      frontend: /users
+      backend: http://users:8080
+      filters:
+        - name: auth
+          addr: http://auth:8080
+          levl: request
+`,
+			want: []string{
+				`9:11: filter "auth" has no level`,
+				`11:11: unknown key "levl", did you mean "level"?`,
+			},
+		},
+		{
+			name: "unknown key without suggestion",
+			in: `
+apiVersion: apps/v1
+spec:
+  resources:
+    users:
+      frontend: /users
+      backend: http://users:8080
+      colour: blue
+`,
+			want: []string{
+				`8:7: unknown key "colour"`,
+			},
+		},
+		{
+			name: "unknown nested key",
+			in: `
+apiVersion: apps/v1
+spec:
+  resources:
+    users:
+      frontend: /users
+      backend: http://users:8080
+      use:
+        cache:
+          adr: jetstream://nats:4222/cache
+`,
+			want: []string{
+				`10:11: unknown key "adr", did you mean "addr"?`,
+				`10:11: cache has no addr`,
+			},
+		},
+		{
+			name: "await cycle",
+			in: `
+apiVersion: apps/v1
+spec:
+  resources:
+    users:
+      frontend: /users
+      backend: http://users:8080
+      filters:
+        - name: a
+          addr: http://auth:8080/a
+          level: request
+          async: true
+          await: [b]
+        - name: b
+          addr: http://auth:8080/b
+          level: request
+          async: true
+          await: [a]
+`,
+			want: []string{
+				`13:18: await cycle a -> b -> a`,
+			},
+		},
+		{
+			name: "await itself",
+			in: `
+apiVersion: apps/v1
+spec:
+  resources:
+    users:
+      frontend: /users
+      backend: http://users:8080
+      filters:
+        - name: a
+          addr: http://auth:8080/a
+          level: request
+          async: true
+          await: [a]
+`,
+			want: []string{
+				`13:18: await cycle a -> a`,
+			},
+		},
+		{
+			name: "await unknown and sync filters",
+			in: `
+apiVersion: apps/v1
+spec:
+  resources:
+    users:
+      frontend: /users
+      backend: http://users:8080
+      filters:
+        - name: a
+          addr: http://auth:8080/a
+          level: request
+        - name: b
+          addr: http://auth:8080/b
+          level: request
+          await: [a, c]
+`,
+			want: []string{
+				`15:19: filter "b" awaits "a" which is not async`,
+				`15:22: filter "b" awaits "c" which does not exist`,
+			},
+		},
+		{
+			name: "cors credentials with any origin",
+			in: `
+apiVersion: apps/v1
+spec:
+  resources:
+    users:
+      frontend: /users
+      backend: http://users:8080
+      use:
+        cors:
+          allowedOrigins: '*'
+          allowCredentials: true
+`,
+			want: []string{
+				`11:29: allowCredentials cannot be combined with the * origin, list the allowed origins instead`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, err := range Validate([]byte(test.in)) {
+				got = append(got, err.Error())
+			}
+			want := test.want
+			if want == nil {
+				want = []string{}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("expected %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestValidateExample(t *testing.T) {
+	in, err := os.ReadFile("../../../examples/test.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range Validate(in) {
+		t.Errorf("examples/test.yml:%s", err.Error())
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/vedadiyan/iceberg/internal/common/metrics"
+	"github.com/vedadiyan/iceberg/internal/common/router"
+)
+
+type (
+	Check   func(context.Context) error
+	Checker interface {
+		Check(context.Context) error
+	}
+	Describer interface {
+		Describe() any
+	}
+	RouteInfo struct {
+		Pattern  string             `json:"pattern"`
+		Method   string             `json:"method"`
+		Match    *router.Predicates `json:"match,omitempty"`
+		Default  bool               `json:"default,omitempty"`
+		Resource any                `json:"resource,omitempty"`
+	}
+	Readiness struct {
+		Ready  bool              `json:"ready"`
+		Checks map[string]string `json:"checks"`
+	}
+)
+
+const (
+	CHECK_TIMEOUT = time.Second * 2
+)
+
+func (server *Server) AdminHandler(checks map[string]Check) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), CHECK_TIMEOUT)
+		defer cancel()
+		readiness := server.Ready(ctx, checks)
+		status := http.StatusOK
+		if !readiness.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, readiness)
+	})
+	mux.HandleFunc("/routes", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, server.Routes())
+	})
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
+
+func (server *Server) ServeAdmin(listener *Listener, checks map[string]Check) error {
+	ln, err := listen(listener)
+	if err != nil {
+		return err
+	}
+	httpServer := newHTTPServer(listener, server.AdminHandler(checks))
+	server.mut.Lock()
+	server.admin = httpServer
+	server.mut.Unlock()
+	return serve(httpServer, ln, listener)
+}
+
+func (server *Server) ShutdownAdmin(ctx context.Context) error {
+	server.mut.Lock()
+	httpServer := server.admin
+	server.mut.Unlock()
+	if httpServer == nil {
+		return nil
+	}
+	return httpServer.Shutdown(ctx)
+}
+
+func (server *Server) Ready(ctx context.Context, checks map[string]Check) *Readiness {
+	readiness := &Readiness{
+		Ready:  true,
+		Checks: make(map[string]string),
+	}
+	if server.draining.Load() {
+		readiness.Ready = false
+		readiness.Checks["server"] = "shutting down"
+	}
+	all := server.backends()
+	for name, check := range checks {
+		all[name] = check
+	}
+	var mut sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range all {
+		name, check := name, check
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := "ok"
+			err := check(ctx)
+			if err != nil {
+				result = err.Error()
+			}
+			mut.Lock()
+			defer mut.Unlock()
+			if err != nil {
+				readiness.Ready = false
+			}
+			readiness.Checks[name] = result
+		}()
+	}
+	wg.Wait()
+	return readiness
+}
+
+func (server *Server) backends() map[string]Check {
+	generation := server.acquire()
+	defer generation.release()
+	checks := make(map[string]Check)
+	for _, route := range generation.routeTable.Routes() {
+		checker, ok := route.GetMetadata().(Checker)
+		if !ok {
+			continue
+		}
+		name := "backend"
+		if named, ok := checker.(interface{ GetName() string }); ok {
+			name = "backend:" + named.GetName()
+		}
+		checks[name] = checker.Check
+	}
+	return checks
+}
+
+func (server *Server) Routes() []RouteInfo {
+	generation := server.acquire()
+	defer generation.release()
+	routes := make([]RouteInfo, 0)
+	for _, route := range generation.routeTable.Routes() {
+		info := RouteInfo{
+			Pattern: route.GetPath(),
+			Method:  route.GetMethod(),
+			Match:   route.GetPredicates(),
+			Default: route.IsDefault(),
+		}
+		if describer, ok := route.GetMetadata().(Describer); ok {
+			info.Resource = describer.Describe()
+		}
+		routes = append(routes, info)
+	}
+	return routes
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
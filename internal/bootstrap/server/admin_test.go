@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/vedadiyan/iceberg/internal/bootstrap"
+	"github.com/vedadiyan/iceberg/internal/common/router"
+)
+
+type (
+	testBackend struct {
+		name string
+		err  error
+	}
+)
+
+func (backend *testBackend) GetName() string {
+	return backend.name
+}
+
+func (backend *testBackend) Check(context.Context) error {
+	return backend.err
+}
+
+func (backend *testBackend) Describe() any {
+	return backend.name
+}
+
+func TestReady(t *testing.T) {
+	tests := []struct {
+		name      string
+		backends  []*testBackend
+		checks    map[string]Check
+		draining  bool
+		wantReady bool
+		want      map[string]string
+	}{
+		{
+			name:      "ready",
+			backends:  []*testBackend{{name: "users"}, {name: "orders"}},
+			checks:    map[string]Check{"nats": func(context.Context) error { return nil }},
+			wantReady: true,
+			want:      map[string]string{"backend:users": "ok", "backend:orders": "ok", "nats": "ok"},
+		},
+		{
+			name:     "backend down",
+			backends: []*testBackend{{name: "users"}, {name: "orders", err: errors.New("connection refused")}},
+			want:     map[string]string{"backend:users": "ok", "backend:orders": "connection refused"},
+		},
+		{
+			name:     "check failed",
+			backends: []*testBackend{{name: "users"}},
+			checks:   map[string]Check{"nats": func(context.Context) error { return errors.New("disconnected") }},
+			want:     map[string]string{"backend:users": "ok", "nats": "disconnected"},
+		},
+		{
+			name:     "draining",
+			backends: []*testBackend{{name: "users"}},
+			draining: true,
+			want:     map[string]string{"backend:users": "ok", "server": "shutting down"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			routeTable := router.NewRouteTable()
+			for _, backend := range test.backends {
+				err := HandleFunc(routeTable, "/"+backend.name, []string{"GET"}, func(w http.ResponseWriter, r *http.Request, rv bootstrap.RouteValues) {}, bootstrap.WithMetadata(backend))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			server := New(routeTable)
+			server.draining.Store(test.draining)
+			readiness := server.Ready(context.TODO(), test.checks)
+			if readiness.Ready != test.wantReady {
+				t.Fatalf("expected ready %v, got %v", test.wantReady, readiness.Ready)
+			}
+			if !reflect.DeepEqual(readiness.Checks, test.want) {
+				t.Fatalf("expected %v, got %v", test.want, readiness.Checks)
+			}
+		})
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	routeTable := router.NewRouteTable()
+	err := HandleFunc(routeTable, "/users", []string{"post", "GET"}, func(w http.ResponseWriter, r *http.Request, rv bootstrap.RouteValues) {}, bootstrap.WithMetadata(&testBackend{name: "users"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = HandleFunc(routeTable, "/health", []string{"GET"}, func(w http.ResponseWriter, r *http.Request, rv bootstrap.RouteValues) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = HandleFunc(routeTable, "/*path", []string{"GET"}, func(w http.ResponseWriter, r *http.Request, rv bootstrap.RouteValues) {}, bootstrap.WithDefault())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := New(routeTable)
+	want := []RouteInfo{
+		{Pattern: "/health", Method: "GET"},
+		{Pattern: "/users", Method: "GET", Resource: "users"},
+		{Pattern: "/users", Method: "POST", Resource: "users"},
+		{Pattern: "/*path", Method: "GET", Default: true},
+	}
+	if routes := server.Routes(); !reflect.DeepEqual(routes, want) {
+		t.Fatalf("expected %v, got %v", want, routes)
+	}
+}
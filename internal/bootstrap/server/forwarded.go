@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/vedadiyan/iceberg/internal/common/netio"
+)
+
+type (
+	// TrustedProxies are the networks whose forwarding headers are believed.
+	TrustedProxies []netip.Prefix
+)
+
+const (
+	FORWARDED_UNKNOWN = "unknown"
+)
+
+// ParseTrustedProxies parses a list of CIDRs or single addresses.
+func ParseTrustedProxies(values []string) (TrustedProxies, error) {
+	trustedProxies := make(TrustedProxies, 0)
+	for _, value := range values {
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %s", value)
+			}
+			trustedProxies = append(trustedProxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s", value)
+		}
+		addr = addr.Unmap()
+		trustedProxies = append(trustedProxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return trustedProxies, nil
+}
+
+func (trustedProxies TrustedProxies) Contains(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetForwarded sets the forwarding headers of a request and resolves its
+// client address. Forwarding headers sent by a trusted proxy are extended,
+// and dropped when sent by anyone else. The client is the last address of
+// X-Forwarded-For, read from the right, that is not a trusted proxy.
+func SetForwarded(r *http.Request, trustedProxies TrustedProxies) {
+	peer := parseAddr(r.RemoteAddr)
+	if !trustedProxies.Contains(peer) {
+		for _, key := range netio.FORWARDED_HEADERS {
+			r.Header.Del(key)
+		}
+	}
+	r.Header.Del(netio.HEADER_CLIENT_IP)
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	if len(r.Header.Get(netio.HEADER_X_FORWARDED_PROTO)) == 0 {
+		r.Header.Set(netio.HEADER_X_FORWARDED_PROTO, proto)
+	}
+	if len(r.Header.Get(netio.HEADER_X_FORWARDED_HOST)) == 0 {
+		r.Header.Set(netio.HEADER_X_FORWARDED_HOST, r.Host)
+	}
+	chain := make([]string, 0)
+	for _, value := range r.Header.Values(netio.HEADER_X_FORWARDED_FOR) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); len(item) != 0 {
+				chain = append(chain, item)
+			}
+		}
+	}
+	node, client := FORWARDED_UNKNOWN, FORWARDED_UNKNOWN
+	if peer.IsValid() {
+		node = peer.String()
+		client = clientIP(chain, peer, trustedProxies).String()
+	}
+	r.Header.Set(netio.HEADER_X_FORWARDED_FOR, strings.Join(append(chain, node), ", "))
+	if peer.Is6() {
+		node = fmt.Sprintf("[%s]", node)
+	}
+	elements := append(r.Header.Values(netio.HEADER_FORWARDED), fmt.Sprintf("for=%s;host=%s;proto=%s", forwardedValue(node), forwardedValue(r.Host), proto))
+	r.Header.Set(netio.HEADER_FORWARDED, strings.Join(elements, ", "))
+	r.Header.Set(netio.HEADER_CLIENT_IP, client)
+}
+
+func clientIP(chain []string, peer netip.Addr, trustedProxies TrustedProxies) netip.Addr {
+	client := peer
+	for i := len(chain) - 1; i >= 0 && trustedProxies.Contains(client); i-- {
+		addr := parseAddr(chain[i])
+		if !addr.IsValid() {
+			break
+		}
+		client = addr
+	}
+	return client
+}
+
+// parseAddr parses an address with or without a port, returning the zero
+// address when it is neither.
+func parseAddr(value string) netip.Addr {
+	value = strings.TrimSpace(value)
+	if addr, err := netip.ParseAddr(strings.Trim(value, "[]")); err == nil {
+		return addr.Unmap()
+	}
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addrPort.Addr().Unmap()
+	}
+	return netip.Addr{}
+}
+
+// forwardedValue quotes a Forwarded parameter value unless it is a token.
+func forwardedValue(value string) string {
+	for _, c := range value {
+		if c > 0x7e || c <= ' ' || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c) {
+			return fmt.Sprintf("%q", value)
+		}
+	}
+	return value
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/vedadiyan/iceberg/internal/common/netio"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    TrustedProxies
+		wantErr bool
+	}{
+		{name: "cidr", values: []string{"10.0.0.0/8"}, want: TrustedProxies{netip.MustParsePrefix("10.0.0.0/8")}},
+		{name: "masked", values: []string{"10.1.2.3/8"}, want: TrustedProxies{netip.MustParsePrefix("10.0.0.0/8")}},
+		{name: "address", values: []string{"192.0.2.1"}, want: TrustedProxies{netip.MustParsePrefix("192.0.2.1/32")}},
+		{name: "ipv6", values: []string{"2001:db8::1", "fd00::/8"}, want: TrustedProxies{netip.MustParsePrefix("2001:db8::1/128"), netip.MustParsePrefix("fd00::/8")}},
+		{name: "mapped", values: []string{"::ffff:192.0.2.1"}, want: TrustedProxies{netip.MustParsePrefix("192.0.2.1/32")}},
+		{name: "empty", values: nil, want: TrustedProxies{}},
+		{name: "invalid cidr", values: []string{"10.0.0.0/33"}, wantErr: true},
+		{name: "invalid address", values: []string{"proxy.local"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trustedProxies, err := ParseTrustedProxies(test.values)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if !test.wantErr && !reflect.DeepEqual(trustedProxies, test.want) {
+				t.Fatalf("expected %v, got %v", test.want, trustedProxies)
+			}
+		})
+	}
+}
+
+func TestSetForwarded(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		tls        bool
+		header     http.Header
+		want       http.Header
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "198.51.100.7:4242",
+			want: http.Header{
+				netio.HEADER_X_FORWARDED_FOR:   {"198.51.100.7"},
+				netio.HEADER_X_FORWARDED_PROTO: {"http"},
+				netio.HEADER_X_FORWARDED_HOST:  {"api.example.com"},
+				netio.HEADER_FORWARDED:         {"for=198.51.100.7;host=api.example.com;proto=http"},
+				netio.HEADER_CLIENT_IP:         {"198.51.100.7"},
+			},
+		},
+		{
+			name:       "untrusted peer",
+			remoteAddr: "198.51.100.7:4242",
+			header: http.Header{
+				netio.HEADER_X_FORWARDED_FOR:   {"203.0.113.1"},
+				netio.HEADER_X_FORWARDED_PROTO: {"https"},
+				netio.HEADER_X_FORWARDED_HOST:  {"spoofed.example.com"},
+				netio.HEADER_FORWARDED:         {"for=203.0.113.1"},
+				netio.HEADER_CLIENT_IP:         {"203.0.113.1"},
+			},
+			want: http.Header{
+				netio.HEADER_X_FORWARDED_FOR:   {"198.51.100.7"},
+				netio.HEADER_X_FORWARDED_PROTO: {"http"},
+				netio.HEADER_X_FORWARDED_HOST:  {"api.example.com"},
+				netio.HEADER_FORWARDED:         {"for=198.51.100.7;host=api.example.com;proto=http"},
+				netio.HEADER_CLIENT_IP:         {"198.51.100.7"},
+			},
+		},
+		{
+			name:       "trusted peer",
+			remoteAddr: "10.0.0.2:4242",
+			tls:        true,
+			header: http.Header{
+				netio.HEADER_X_FORWARDED_FOR:   {"203.0.113.1"},
+				netio.HEADER_X_FORWARDED_PROTO: {"http"},
+				netio.HEADER_X_FORWARDED_HOST:  {"www.example.com"},
+				netio.HEADER_FORWARDED:         {"for=203.0.113.1"},
+				netio.HEADER_CLIENT_IP:         {"192.0.2.99"},
+			},
+			want: http.Header{
+				netio.HEADER_X_FORWARDED_FOR:   {"203.0.113.1, 10.0.0.2"},
+				netio.HEADER_X_FORWARDED_PROTO: {"http"},
+				netio.HEADER_X_FORWARDED_HOST:  {"www.example.com"},
+				netio.HEADER_FORWARDED:         {"for=203.0.113.1, for=10.0.0.2;host=api.example.com;proto=https"},
+				netio.HEADER_CLIENT_IP:         {"203.0.113.1"},
+			},
+		},
+		{
+			name:       "trusted chain",
+			remoteAddr: "10.0.0.2:4242",
+			header: http.Header{
+				netio.HEADER_X_FORWARDED_FOR: {"192.0.2.5, 203.0.113.1", "10.1.1.1"},
+			},
+			want: http.Header{
+				netio.HEADER_X_FORWARDED_FOR:   {"192.0.2.5, 203.0.113.1, 10.1.1.1, 10.0.0.2"},
+				netio.HEADER_X_FORWARDED_PROTO: {"http"},
+				netio.HEADER_X_FORWARDED_HOST:  {"api.example.com"},
+				netio.HEADER_FORWARDED:         {"for=10.0.0.2;host=api.example.com;proto=http"},
+				netio.HEADER_CLIENT_IP:         {"203.0.113.1"},
+			},
+		},
+		{
+			name:       "all trusted",
+			remoteAddr: "10.0.0.2:4242",
+			header: http.Header{
+				netio.HEADER_X_FORWARDED_FOR: {"10.9.9.9"},
+			},
+			want: http.Header{
+				netio.HEADER_X_FORWARDED_FOR:   {"10.9.9.9, 10.0.0.2"},
+				netio.HEADER_X_FORWARDED_PROTO: {"http"},
+				netio.HEADER_X_FORWARDED_HOST:  {"api.example.com"},
+				netio.HEADER_FORWARDED:         {"for=10.0.0.2;host=api.example.com;proto=http"},
+				netio.HEADER_CLIENT_IP:         {"10.9.9.9"},
+			},
+		},
+		{
+			name:       "invalid entry stops the walk",
+			remoteAddr: "10.0.0.2:4242",
+			header: http.Header{
+				netio.HEADER_X_FORWARDED_FOR: {"203.0.113.1, garbage"},
+			},
+			want: http.Header{
+				netio.HEADER_X_FORWARDED_FOR:   {"203.0.113.1, garbage, 10.0.0.2"},
+				netio.HEADER_X_FORWARDED_PROTO: {"http"},
+				netio.HEADER_X_FORWARDED_HOST:  {"api.example.com"},
+				netio.HEADER_FORWARDED:         {"for=10.0.0.2;host=api.example.com;proto=http"},
+				netio.HEADER_CLIENT_IP:         {"10.0.0.2"},
+			},
+		},
+		{
+			name:       "ipv6 peer",
+			remoteAddr: "[2001:db8::2]:4242",
+			header: http.Header{
+				netio.HEADER_X_FORWARDED_FOR: {"[2001:db8:ffff::1]:1234, 198.51.100.7"},
+			},
+			want: http.Header{
+				netio.HEADER_X_FORWARDED_FOR:   {"[2001:db8:ffff::1]:1234, 198.51.100.7, 2001:db8::2"},
+				netio.HEADER_X_FORWARDED_PROTO: {"http"},
+				netio.HEADER_X_FORWARDED_HOST:  {"api.example.com"},
+				netio.HEADER_FORWARDED:         {`for="[2001:db8::2]";host=api.example.com;proto=http`},
+				netio.HEADER_CLIENT_IP:         {"198.51.100.7"},
+			},
+		},
+		{
+			name:       "mapped peer",
+			remoteAddr: "[::ffff:10.0.0.2]:4242",
+			header: http.Header{
+				netio.HEADER_X_FORWARDED_FOR: {"203.0.113.1"},
+			},
+			want: http.Header{
+				netio.HEADER_X_FORWARDED_FOR:   {"203.0.113.1, 10.0.0.2"},
+				netio.HEADER_X_FORWARDED_PROTO: {"http"},
+				netio.HEADER_X_FORWARDED_HOST:  {"api.example.com"},
+				netio.HEADER_FORWARDED:         {"for=10.0.0.2;host=api.example.com;proto=http"},
+				netio.HEADER_CLIENT_IP:         {"203.0.113.1"},
+			},
+		},
+		{
+			name:       "unknown peer",
+			remoteAddr: "@",
+			want: http.Header{
+				netio.HEADER_X_FORWARDED_FOR:   {FORWARDED_UNKNOWN},
+				netio.HEADER_X_FORWARDED_PROTO: {"http"},
+				netio.HEADER_X_FORWARDED_HOST:  {"api.example.com"},
+				netio.HEADER_FORWARDED:         {"for=unknown;host=api.example.com;proto=http"},
+				netio.HEADER_CLIENT_IP:         {FORWARDED_UNKNOWN},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://api.example.com/", nil)
+			r.RemoteAddr = test.remoteAddr
+			if test.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for key, values := range test.header {
+				r.Header[key] = values
+			}
+			SetForwarded(r, trustedProxies)
+			if !reflect.DeepEqual(r.Header, test.want) {
+				t.Fatalf("expected %v, got %v", test.want, r.Header)
+			}
+		})
+	}
+}
@@ -1,67 +1,246 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/vedadiyan/iceberg/internal/bootstrap"
 	"github.com/vedadiyan/iceberg/internal/common/router"
 )
 
-var (
-	_mux *http.ServeMux
+type (
+	Listener struct {
+		Addr              string
+		TLS               *TLS
+		GracePeriod       time.Duration
+		ReadTimeout       time.Duration
+		ReadHeaderTimeout time.Duration
+		WriteTimeout      time.Duration
+		IdleTimeout       time.Duration
+		TrustedProxies    TrustedProxies
+	}
+	// Server serves a route table that can be swapped while requests are in
+	// flight. Each Server owns its listeners, so several can run side by side
+	// in one process.
+	Server struct {
+		current  atomic.Pointer[generation]
+		mut      sync.Mutex
+		server   *http.Server
+		admin    *http.Server
+		draining atomic.Bool
+		trusted  atomic.Pointer[TrustedProxies]
+	}
+	generation struct {
+		routeTable *router.RouteTable
+		mut        sync.Mutex
+		retired    bool
+		inFlight   sync.WaitGroup
+	}
 )
 
-func init() {
-	_mux = http.NewServeMux()
-	_mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		router, err := router.DefaultRouteTable().Find(r.URL, r.Method)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+const (
+	GRACE_PERIOD        = time.Second * 30
+	READ_TIMEOUT        = time.Second * 10
+	READ_HEADER_TIMEOUT = time.Second * 5
+	WRITE_TIMEOUT       = time.Second * 30
+	IDLE_TIMEOUT        = time.Second * 120
+
+	MAX_PARAMS = 8
+)
+
+func New(routeTable *router.RouteTable) *Server {
+	server := new(Server)
+	server.current.Store(newGeneration(routeTable))
+	return server
+}
+
+func newGeneration(routeTable *router.RouteTable) *generation {
+	generation := new(generation)
+	generation.routeTable = routeTable
+	return generation
+}
+
+func (server *Server) acquire() *generation {
+	for {
+		generation := server.current.Load()
+		generation.mut.Lock()
+		if !generation.retired {
+			generation.inFlight.Add(1)
+			generation.mut.Unlock()
+			return generation
 		}
-		router.ServeHTTP(w, r)
-	})
+		generation.mut.Unlock()
+	}
+}
+
+func (generation *generation) release() {
+	generation.inFlight.Done()
+}
+
+func (generation *generation) retire() {
+	generation.mut.Lock()
+	generation.retired = true
+	generation.mut.Unlock()
+	generation.inFlight.Wait()
+}
+
+// Swap atomically replaces the route table. The returned channel is closed
+// once the requests still being served by the previous one are done.
+func (server *Server) Swap(routeTable *router.RouteTable) <-chan struct{} {
+	old := server.current.Swap(newGeneration(routeTable))
+	retired := make(chan struct{})
+	go func() {
+		defer close(retired)
+		old.retire()
+	}()
+	return retired
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	generation := server.acquire()
+	defer generation.release()
+	SetClientIdentity(r)
+	SetForwarded(r, server.trustedProxies())
+	var buffer [MAX_PARAMS]string
+	route, values, err := generation.routeTable.Lookup(r, buffer[:0])
+	if errors.Is(err, router.NOT_ALLOWED) {
+		w.Header().Set("Allow", strings.Join(generation.routeTable.Allow(r), ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	route.Handle(w, r, values)
 }
 
-func HandleFunc(pattern string, method string, handler func(w http.ResponseWriter, r *http.Request, rv bootstrap.RouteValues), options ...bootstrap.RegistrationOptions) error {
-	url, err := url.Parse(pattern)
+func HandleFunc(routeTable *router.RouteTable, pattern string, methods []string, handler func(w http.ResponseWriter, r *http.Request, rv bootstrap.RouteValues), options ...bootstrap.RegistrationOptions) error {
+	err := router.ValidateRoute(pattern)
 	if err != nil {
 		return err
 	}
+	url := &url.URL{Path: pattern}
 	var opt bootstrap.Options
+	handler2 := func(w http.ResponseWriter, r *http.Request, rv bootstrap.RouteValues) {
+		if opt.Timeouts != nil {
+			var cancel context.CancelFunc
+			r, cancel = opt.Timeouts.Apply(w, r)
+			defer cancel()
+		}
+		if opt.CORS != nil {
+			opt.CORS.Apply(w, r)
+		}
+		handler(w, r, rv)
+	}
 	for _, option := range options {
-		option(&opt, router.DefaultRouteTable(), url, handler)
+		option(&opt, routeTable, url, handler2)
 	}
-	if len(method) == 0 {
-		method = "*"
+	if opt.CORS != nil {
+		route, err := opt.Table(routeTable).Register(url, "OPTIONS", opt.Predicates, opt.CORS.Handler(handler2))
+		if err != nil {
+			return err
+		}
+		route.SetMetadata(opt.Metadata)
 	}
-	handler2 := func(w http.ResponseWriter, r *http.Request, rv bootstrap.RouteValues) {
-		if opt.Cors && len(opt.ExposeHeaders) != 0 {
-			w.Header().Add("Access-Control-Expose-Headers", opt.ExposeHeaders)
+	for _, method := range bootstrap.Methods(methods) {
+		route, err := opt.Table(routeTable).Register(url, method, opt.Predicates, handler2)
+		if err != nil {
+			return err
 		}
-		handler(w, r, rv)
+		route.SetMetadata(opt.Metadata)
 	}
-	if method == "*" {
-		router.DefaultRouteTable().Register(url, "GET", handler2)
-		router.DefaultRouteTable().Register(url, "HEAD", handler2)
-		router.DefaultRouteTable().Register(url, "POST", handler2)
-		router.DefaultRouteTable().Register(url, "PUT", handler2)
-		router.DefaultRouteTable().Register(url, "DELETE", handler2)
+	return nil
+}
+
+func (server *Server) ListenAndServe(listener *Listener) error {
+	ln, err := listen(listener)
+	if err != nil {
+		return err
+	}
+	return server.Serve(ln, listener)
+}
+
+// Serve accepts connections on ln using the timeouts and TLS settings of
+// listener, whose Addr is ignored.
+func (server *Server) Serve(ln net.Listener, listener *Listener) error {
+	httpServer := newHTTPServer(listener, server)
+	server.mut.Lock()
+	if server.draining.Load() {
+		server.mut.Unlock()
+		return ln.Close()
+	}
+	server.server = httpServer
+	server.trusted.Store(&listener.TrustedProxies)
+	server.mut.Unlock()
+	return serve(httpServer, ln, listener)
+}
+
+func (server *Server) trustedProxies() TrustedProxies {
+	trustedProxies := server.trusted.Load()
+	if trustedProxies == nil {
 		return nil
 	}
-	router.DefaultRouteTable().Register(url, method, handler2)
-	return nil
+	return *trustedProxies
 }
 
-func ListenAndServe(addr string) {
-	server := http.Server{
-		Addr:              addr,
-		ReadTimeout:       time.Second * 10,
-		ReadHeaderTimeout: time.Second * 5,
-		WriteTimeout:      time.Second * 30,
-		Handler:           _mux,
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.mut.Lock()
+	server.draining.Store(true)
+	httpServer := server.server
+	server.mut.Unlock()
+	if httpServer == nil {
+		return nil
+	}
+	return httpServer.Shutdown(ctx)
+}
+
+func listen(listener *Listener) (net.Listener, error) {
+	addr := listener.Addr
+	if len(addr) == 0 {
+		addr = ":http"
+		if listener.TLS != nil {
+			addr = ":https"
+		}
+	}
+	return net.Listen("tcp", addr)
+}
+
+func newHTTPServer(listener *Listener, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              listener.Addr,
+		ReadTimeout:       listener.ReadTimeout,
+		ReadHeaderTimeout: listener.ReadHeaderTimeout,
+		WriteTimeout:      listener.WriteTimeout,
+		IdleTimeout:       listener.IdleTimeout,
+		Handler:           handler,
+	}
+}
+
+func serve(httpServer *http.Server, ln net.Listener, listener *Listener) error {
+	var err error
+	if listener.TLS == nil {
+		err = httpServer.Serve(ln)
+	} else {
+		var config *tls.Config
+		config, err = listener.TLS.Config()
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		httpServer.TLSConfig = config
+		err = httpServer.ServeTLS(ln, "", "")
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
 	}
-	_ = server.ListenAndServe()
+	return err
 }
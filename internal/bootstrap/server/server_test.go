@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vedadiyan/iceberg/internal/bootstrap"
+	"github.com/vedadiyan/iceberg/internal/common/router"
+)
+
+func TestSwap(t *testing.T) {
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	old := router.NewRouteTable()
+	err := HandleFunc(old, "/test", []string{"GET"}, func(w http.ResponseWriter, r *http.Request, rv bootstrap.RouteValues) {
+		close(started)
+		<-finish
+		w.Write([]byte("old"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	new := router.NewRouteTable()
+	err = HandleFunc(new, "/test", []string{"GET"}, func(w http.ResponseWriter, r *http.Request, rv bootstrap.RouteValues) {
+		w.Write([]byte("new"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := New(old)
+
+	inFlight := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		server.ServeHTTP(inFlight, httptest.NewRequest("GET", "/test", nil))
+		close(served)
+	}()
+	<-started
+	retired := server.Swap(new)
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+	if w.Body.String() != "new" {
+		t.Fatalf("expected requests after a swap to reach the new table, got %s", w.Body.String())
+	}
+	select {
+	case <-retired:
+		{
+			t.Fatalf("expected the old table to be retired after in flight requests")
+		}
+	case <-time.After(time.Millisecond * 50):
+	}
+	close(finish)
+	<-served
+	<-retired
+	if inFlight.Body.String() != "old" {
+		t.Fatalf("expected the in flight request to finish on the old table, got %s", inFlight.Body.String())
+	}
+}
+
+func TestServe(t *testing.T) {
+	routeTable := router.NewRouteTable()
+	handler := func(w http.ResponseWriter, r *http.Request, rv bootstrap.RouteValues) {
+		w.Write([]byte(rv["id"]))
+	}
+	if err := HandleFunc(routeTable, "/items/:id", []string{"GET", "put", "PURGE"}, handler); err != nil {
+		t.Fatal(err)
+	}
+	server := New(routeTable)
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+		wantBody   string
+		wantAllow  string
+	}{
+		{name: "found", method: "GET", target: "/items/1", wantStatus: http.StatusOK, wantBody: "1"},
+		{name: "custom method", method: "PURGE", target: "/items/2", wantStatus: http.StatusOK, wantBody: "2"},
+		{name: "not allowed", method: "POST", target: "/items/1", wantStatus: http.StatusMethodNotAllowed, wantAllow: "GET, PURGE, PUT"},
+		{name: "not found", method: "GET", target: "/users/1", wantStatus: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(test.method, test.target, nil))
+			if w.Code != test.wantStatus {
+				t.Fatalf("expected %d, got %d", test.wantStatus, w.Code)
+			}
+			if len(test.wantBody) != 0 && w.Body.String() != test.wantBody {
+				t.Fatalf("expected %s, got %s", test.wantBody, w.Body.String())
+			}
+			if allow := w.Header().Get("Allow"); allow != test.wantAllow {
+				t.Fatalf("expected %s, got %s", test.wantAllow, allow)
+			}
+		})
+	}
+}
+
+func TestServers(t *testing.T) {
+	servers := make([]*Server, 0)
+	for _, name := range []string{"a", "b"} {
+		name := name
+		routeTable := router.NewRouteTable()
+		err := HandleFunc(routeTable, "/name", []string{"GET"}, func(w http.ResponseWriter, r *http.Request, rv bootstrap.RouteValues) {
+			w.Write([]byte(name))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers = append(servers, New(routeTable))
+	}
+	servers[1].Shutdown(context.Background())
+	for index, want := range []string{"a", "b"} {
+		w := httptest.NewRecorder()
+		servers[index].ServeHTTP(w, httptest.NewRequest("GET", "/name", nil))
+		if w.Body.String() != want {
+			t.Fatalf("expected %s, got %s", want, w.Body.String())
+		}
+	}
+	if servers[0].draining.Load() {
+		t.Fatalf("expected shutting down one server to leave the other running")
+	}
+}
+
+func TestServeAfterShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := New(router.NewRouteTable())
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Serve(ln, &Listener{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Fatalf("expected the listener to be closed")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	routeTable := router.NewRouteTable()
+	err = HandleFunc(routeTable, "/slow", []string{"GET"}, func(w http.ResponseWriter, r *http.Request, rv bootstrap.RouteValues) {
+		close(started)
+		<-finish
+		w.Write([]byte("done"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := New(routeTable)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Serve(ln, &Listener{})
+	}()
+
+	body := make(chan string, 1)
+	go func() {
+		for {
+			res, err := http.Get("http://" + addr + "/slow")
+			if err != nil {
+				time.Sleep(time.Millisecond * 10)
+				continue
+			}
+			defer res.Body.Close()
+			data, _ := io.ReadAll(res.Body)
+			body <- string(data)
+			return
+		}
+	}()
+	<-started
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- server.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-shutdown:
+		{
+			t.Fatalf("expected shutdown to wait for the in flight request, got %v", err)
+		}
+	case <-time.After(time.Millisecond * 50):
+	}
+	close(finish)
+	if data := <-body; data != "done" {
+		t.Fatalf("expected the in flight request to complete, got %s", data)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("expected a clean stop, got %v", err)
+	}
+}
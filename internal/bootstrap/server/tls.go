@@ -0,0 +1,203 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	TLS struct {
+		CertFile     string
+		KeyFile      string
+		ClientCAFile string
+		ClientAuth   tls.ClientAuthType
+		MinVersion   uint16
+		CipherSuites []uint16
+	}
+	reloader struct {
+		tls       *TLS
+		mut       sync.RWMutex
+		checked   time.Time
+		modTimes  [3]time.Time
+		cert      *tls.Certificate
+		clientCAs *x509.CertPool
+	}
+)
+
+const (
+	HEADER_CLIENT_SUBJECT   = "X-Client-Cert-Subject"
+	HEADER_CLIENT_SAN       = "X-Client-Cert-San"
+	HEADER_CLIENT_SPIFFE_ID = "X-Client-Spiffe-Id"
+
+	RELOAD_INTERVAL = time.Second * 5
+)
+
+func ParseTLSVersion(version string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(version), "tls") {
+	case "", "1.2":
+		{
+			return tls.VersionTLS12, nil
+		}
+	case "1.0":
+		{
+			return tls.VersionTLS10, nil
+		}
+	case "1.1":
+		{
+			return tls.VersionTLS11, nil
+		}
+	case "1.3":
+		{
+			return tls.VersionTLS13, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported tls version %s", version)
+}
+
+func ParseCipherSuite(name string) (uint16, error) {
+	for _, suite := range tls.CipherSuites() {
+		if strings.EqualFold(suite.Name, name) {
+			return suite.ID, nil
+		}
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if strings.EqualFold(suite.Name, name) {
+			return 0, fmt.Errorf("insecure cipher suite %s", name)
+		}
+	}
+	return 0, fmt.Errorf("unsupported cipher suite %s", name)
+}
+
+func ParseClientAuth(clientAuth string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(clientAuth) {
+	case "", "none":
+		{
+			return tls.NoClientCert, nil
+		}
+	case "request":
+		{
+			return tls.RequestClientCert, nil
+		}
+	case "verify-if-given":
+		{
+			return tls.VerifyClientCertIfGiven, nil
+		}
+	case "require":
+		{
+			return tls.RequireAndVerifyClientCert, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported client auth %s", clientAuth)
+}
+
+func (t *TLS) Config() (*tls.Config, error) {
+	reloader := &reloader{tls: t}
+	err := reloader.load()
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion: t.MinVersion,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, clientCAs := reloader.get()
+			return &tls.Config{
+				MinVersion:   t.MinVersion,
+				CipherSuites: t.CipherSuites,
+				Certificates: []tls.Certificate{*cert},
+				ClientAuth:   t.ClientAuth,
+				ClientCAs:    clientCAs,
+				NextProtos:   []string{"h2", "http/1.1"},
+			}, nil
+		},
+	}
+	return config, nil
+}
+
+func (reloader *reloader) get() (*tls.Certificate, *x509.CertPool) {
+	reloader.mut.RLock()
+	expired := time.Since(reloader.checked) > RELOAD_INTERVAL
+	cert, clientCAs := reloader.cert, reloader.clientCAs
+	reloader.mut.RUnlock()
+	if !expired {
+		return cert, clientCAs
+	}
+	err := reloader.load()
+	if err != nil {
+		return cert, clientCAs
+	}
+	reloader.mut.RLock()
+	defer reloader.mut.RUnlock()
+	return reloader.cert, reloader.clientCAs
+}
+
+func (reloader *reloader) load() error {
+	reloader.mut.Lock()
+	defer reloader.mut.Unlock()
+	reloader.checked = time.Now()
+	files := [3]string{reloader.tls.CertFile, reloader.tls.KeyFile, reloader.tls.ClientCAFile}
+	modTimes := [3]time.Time{}
+	for index, file := range files {
+		if len(file) == 0 {
+			continue
+		}
+		stat, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[index] = stat.ModTime()
+	}
+	if reloader.cert != nil && modTimes == reloader.modTimes {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(reloader.tls.CertFile, reloader.tls.KeyFile)
+	if err != nil {
+		return err
+	}
+	var clientCAs *x509.CertPool
+	if len(reloader.tls.ClientCAFile) != 0 {
+		data, err := os.ReadFile(reloader.tls.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in %s", reloader.tls.ClientCAFile)
+		}
+	}
+	reloader.cert = &cert
+	reloader.clientCAs = clientCAs
+	reloader.modTimes = modTimes
+	return nil
+}
+
+func SetClientIdentity(r *http.Request) {
+	r.Header.Del(HEADER_CLIENT_SUBJECT)
+	r.Header.Del(HEADER_CLIENT_SAN)
+	r.Header.Del(HEADER_CLIENT_SPIFFE_ID)
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	r.Header.Set(HEADER_CLIENT_SUBJECT, cert.Subject.String())
+	for _, name := range cert.DNSNames {
+		r.Header.Add(HEADER_CLIENT_SAN, fmt.Sprintf("DNS:%s", name))
+	}
+	for _, email := range cert.EmailAddresses {
+		r.Header.Add(HEADER_CLIENT_SAN, fmt.Sprintf("email:%s", email))
+	}
+	for _, ip := range cert.IPAddresses {
+		r.Header.Add(HEADER_CLIENT_SAN, fmt.Sprintf("IP:%s", ip.String()))
+	}
+	for _, uri := range cert.URIs {
+		r.Header.Add(HEADER_CLIENT_SAN, fmt.Sprintf("URI:%s", uri.String()))
+		if strings.EqualFold(uri.Scheme, "spiffe") && len(r.Header.Get(HEADER_CLIENT_SPIFFE_ID)) == 0 {
+			r.Header.Set(HEADER_CLIENT_SPIFFE_ID, uri.String())
+		}
+	}
+}
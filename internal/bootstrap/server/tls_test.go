@@ -0,0 +1,230 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type (
+	certificate struct {
+		cert *x509.Certificate
+		key  *ecdsa.PrivateKey
+		der  []byte
+	}
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint16
+		wantErr bool
+	}{
+		{version: "", want: tls.VersionTLS12},
+		{version: "1.2", want: tls.VersionTLS12},
+		{version: "TLS1.3", want: tls.VersionTLS13},
+		{version: "1.0", want: tls.VersionTLS10},
+		{version: "1.4", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			version, err := ParseTLSVersion(test.version)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if version != test.want {
+				t.Fatalf("expected %d, got %d", test.want, version)
+			}
+		})
+	}
+}
+
+func TestParseCipherSuite(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    uint16
+		wantErr bool
+	}{
+		{name: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", want: tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		{name: "tls_ecdhe_rsa_with_aes_256_gcm_sha384", want: tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+		{name: "TLS_RSA_WITH_RC4_128_SHA", wantErr: true},
+		{name: "TLS_UNKNOWN", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cipher, err := ParseCipherSuite(test.name)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if cipher != test.want {
+				t.Fatalf("expected %d, got %d", test.want, cipher)
+			}
+		})
+	}
+}
+
+func TestParseClientAuth(t *testing.T) {
+	tests := []struct {
+		clientAuth string
+		want       tls.ClientAuthType
+		wantErr    bool
+	}{
+		{clientAuth: "", want: tls.NoClientCert},
+		{clientAuth: "request", want: tls.RequestClientCert},
+		{clientAuth: "verify-if-given", want: tls.VerifyClientCertIfGiven},
+		{clientAuth: "Require", want: tls.RequireAndVerifyClientCert},
+		{clientAuth: "optional", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.clientAuth, func(t *testing.T) {
+			clientAuth, err := ParseClientAuth(test.clientAuth)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if clientAuth != test.want {
+				t.Fatalf("expected %v, got %v", test.want, clientAuth)
+			}
+		})
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCertificate(t, nil, &x509.Certificate{Subject: pkix.Name{CommonName: "ca"}, IsCA: true, KeyUsage: x509.KeyUsageCertSign, BasicConstraintsValid: true})
+	server := newCertificate(t, ca, &x509.Certificate{Subject: pkix.Name{CommonName: "server"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+	spiffe, err := url.Parse("spiffe://example.org/orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newCertificate(t, ca, &x509.Certificate{Subject: pkix.Name{CommonName: "orders"}, DNSNames: []string{"orders.local"}, URIs: []*url.URL{spiffe}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	ca.write(t, filepath.Join(dir, "ca.pem"), "")
+	server.write(t, filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"))
+
+	config, err := (&TLS{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}).Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set(HEADER_CLIENT_SPIFFE_ID, "spoofed")
+		SetClientIdentity(r)
+		w.Header().Set(HEADER_CLIENT_SUBJECT, r.Header.Get(HEADER_CLIENT_SUBJECT))
+		w.Header()[HEADER_CLIENT_SAN] = r.Header.Values(HEADER_CLIENT_SAN)
+		w.Header().Set(HEADER_CLIENT_SPIFFE_ID, r.Header.Get(HEADER_CLIENT_SPIFFE_ID))
+	}))
+	backend.TLS = config
+	backend.StartTLS()
+	defer backend.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	tests := []struct {
+		name        string
+		client      *certificate
+		wantErr     bool
+		wantHeaders map[string][]string
+	}{
+		{name: "no client certificate", wantErr: true},
+		{
+			name:   "client certificate",
+			client: client,
+			wantHeaders: map[string][]string{
+				HEADER_CLIENT_SUBJECT:   {"CN=orders"},
+				HEADER_CLIENT_SAN:       {"DNS:orders.local", "URI:spiffe://example.org/orders"},
+				HEADER_CLIENT_SPIFFE_ID: {"spiffe://example.org/orders"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientConfig := &tls.Config{RootCAs: roots}
+			if test.client != nil {
+				clientConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{test.client.der}, PrivateKey: test.client.key}}
+			}
+			httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+			res, err := httpClient.Get(backend.URL)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			defer res.Body.Close()
+			for key, values := range test.wantHeaders {
+				if !reflect.DeepEqual(res.Header.Values(key), values) {
+					t.Fatalf("expected %s to be %v, got %v", key, values, res.Header.Values(key))
+				}
+			}
+		})
+	}
+}
+
+func TestSetClientIdentityWithoutTLS(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(HEADER_CLIENT_SUBJECT, "CN=admin")
+	r.Header.Set(HEADER_CLIENT_SPIFFE_ID, "spiffe://example.org/admin")
+	SetClientIdentity(r)
+	if len(r.Header) != 0 {
+		t.Fatalf("expected client identity headers to be removed, got %v", r.Header)
+	}
+}
+
+func newCertificate(t *testing.T, parent *certificate, template *x509.Certificate) *certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	issuer, signer := template, key
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &certificate{cert: cert, key: key, der: der}
+}
+
+func (certificate *certificate) write(t *testing.T, certFile string, keyFile string) {
+	err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keyFile) == 0 {
+		return
+	}
+	key, err := x509.MarshalECPrivateKey(certificate.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,29 +2,44 @@ package filters
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
+	"github.com/nats-io/nats.go"
+	"github.com/vedadiyan/iceberg/internal/common/breaker"
+	"github.com/vedadiyan/iceberg/internal/common/metrics"
 	"github.com/vedadiyan/iceberg/internal/common/netio"
+	"github.com/vedadiyan/iceberg/internal/common/retry"
+	"github.com/vedadiyan/iceberg/internal/common/transport"
 )
 
 type (
 	Filter struct {
-		Name      string
-		Address   *url.URL
-		Level     netio.Level
-		Parallel  bool
-		Timeout   time.Duration
-		Callers   []netio.Caller
-		AwaitList []string
+		Name         string
+		Address      *url.URL
+		Level        netio.Level
+		Parallel     bool
+		Timeout      time.Duration
+		Callers      []netio.Caller
+		AwaitList    []string
+		OnError      OnError
+		Transport    *transport.Options
+		Retry        *retry.Policy
+		Breaker      *breaker.Breaker
+		FailOnStatus bool
 
 		RequestUpdaters  []netio.RequestUpdater
 		ResponseUpdaters []netio.ResponseUpdater
 
-		instance netio.Caller
+		instance  netio.Caller
+		readsBody bool
+	}
+	releaser interface {
+		release() error
 	}
 )
 
@@ -55,6 +70,10 @@ func (f *Filter) GetName() string {
 	return f.Name
 }
 
+func (f *Filter) GetAddress() *url.URL {
+	return f.Address
+}
+
 func (f *Filter) GetAwaitList() []string {
 	return f.AwaitList
 }
@@ -63,22 +82,29 @@ func (f *Filter) GetIsParallel() bool {
 	return f.Parallel
 }
 
-func (f *Filter) GetContext() context.Context {
-	ctx, cancel := context.WithCancel(context.TODO())
+// GetContext leaves the filter's timeout out. It is applied by Call, so that
+// an error policy can still run a fallback once it expires.
+func (f *Filter) GetContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithCancel(parent)
+}
+
+func (f *Filter) withTimeout(parent context.Context) (context.Context, context.CancelFunc) {
 	timeout := f.Timeout
 	if timeout == 0 {
 		timeout = time.Second * 30
 	}
-	time.AfterFunc(time.Until(time.Now().Add(timeout)), func() {
-		cancel()
-	})
-	return ctx
+	return netio.WithTimeout(parent, timeout)
 }
 
 func (f *Filter) GetLevel() netio.Level {
 	return f.Level
 }
 
+// ReadsBody reports whether the filter exchanges the body or form.
+func (f *Filter) ReadsBody() bool {
+	return f.readsBody
+}
+
 func (f *Filter) SetExchangeHeaders(headers []string) {
 	switch f.Level {
 	case netio.LEVEL_CONNECT, netio.LEVEL_REQUEST:
@@ -101,6 +127,7 @@ func (f *Filter) SetExchangeHeaders(headers []string) {
 }
 
 func (f *Filter) SetExchangeBody() {
+	f.readsBody = true
 	switch f.Level {
 	case netio.LEVEL_CONNECT, netio.LEVEL_REQUEST:
 		{
@@ -113,27 +140,154 @@ func (f *Filter) SetExchangeBody() {
 	}
 }
 
-func (f *Filter) Call(ctx context.Context, rv map[string]string, c netio.Cloner, o netio.Cloner) (netio.Next, *http.Response, netio.Error) {
-	return f.instance.Call(ctx, rv, c, o)
+func (f *Filter) SetExchangeTrailers(trailers []string) {
+	switch f.Level {
+	case netio.LEVEL_CONNECT, netio.LEVEL_REQUEST:
+		{
+			if len(trailers) == 1 && trailers[0] == "*" {
+				f.RequestUpdaters = append(f.RequestUpdaters, netio.ReqReplaceTailer())
+				return
+			}
+			f.RequestUpdaters = append(f.RequestUpdaters, netio.ReqUpdateTailer(trailers...))
+		}
+	case netio.LEVEL_RESPONSE:
+		{
+			if len(trailers) == 1 && trailers[0] == "*" {
+				f.ResponseUpdaters = append(f.ResponseUpdaters, netio.ResReplaceTailer())
+				return
+			}
+			f.ResponseUpdaters = append(f.ResponseUpdaters, netio.ResUpdateTailer(trailers...))
+		}
+	}
+}
+
+func (f *Filter) SetExchangeStatus() {
+	switch f.Level {
+	case netio.LEVEL_RESPONSE:
+		{
+			f.ResponseUpdaters = append(f.ResponseUpdaters, netio.ResReplaceStatus())
+		}
+	}
+}
+
+func (f *Filter) SetExchangeQuery(keys []string) {
+	switch f.Level {
+	case netio.LEVEL_CONNECT, netio.LEVEL_REQUEST:
+		{
+			if len(keys) == 1 && keys[0] == "*" {
+				f.RequestUpdaters = append(f.RequestUpdaters, netio.ReqReplaceQuery())
+				return
+			}
+			f.RequestUpdaters = append(f.RequestUpdaters, netio.ReqUpdateQuery(keys...))
+		}
+	}
+}
+
+func (f *Filter) SetExchangeRouteValues(keys []string) {
+	switch f.Level {
+	case netio.LEVEL_CONNECT, netio.LEVEL_REQUEST:
+		{
+			f.RequestUpdaters = append(f.RequestUpdaters, netio.ReqUpdateRouteValues(keys...))
+		}
+	}
+}
+
+func (f *Filter) SetExchangeURL() {
+	switch f.Level {
+	case netio.LEVEL_CONNECT, netio.LEVEL_REQUEST:
+		{
+			f.RequestUpdaters = append(f.RequestUpdaters, netio.ReqReplaceURL())
+		}
+	}
+}
+
+func (f *Filter) SetExchangeForm() {
+	f.readsBody = true
+	switch f.Level {
+	case netio.LEVEL_CONNECT, netio.LEVEL_REQUEST:
+		{
+			f.RequestUpdaters = append(f.RequestUpdaters, netio.ReqReplaceForm())
+		}
+	}
+}
+
+func (f *Filter) SetExchangeMultipartForm() {
+	f.readsBody = true
+	switch f.Level {
+	case netio.LEVEL_CONNECT, netio.LEVEL_REQUEST:
+		{
+			f.RequestUpdaters = append(f.RequestUpdaters, netio.ReqReplaceMultipartForm())
+		}
+	}
+}
+
+func (f *Filter) Call(parent context.Context, rv netio.RouteValues, c netio.Cloner, o netio.Cloner) (netio.Next, *http.Response, netio.Error) {
+	start := time.Now()
+	transport := strings.ToLower(f.Address.Scheme)
+	done, ok := f.Breaker.Allow()
+	if !ok {
+		err := netio.NewOpenError(breaker.ErrOpen.Error())
+		metrics.FilterErrors.WithLabelValues(f.Name, transport, err.Kind().String()).Inc()
+		return f.OnError.Handle(parent, rv, c, o, err)
+	}
+	ctx, cancel := f.withTimeout(parent)
+	next, res, err := f.Retry.Do(ctx, f.Name, c, func(ctx context.Context, c netio.Cloner) (netio.Next, *http.Response, netio.Error) {
+		return f.instance.Call(ctx, rv, c, o)
+	})
+	done(breaker.Classify(ctx, res, err))
+	res = netio.CancelOnClose(res, cancel)
+	metrics.FilterDuration.WithLabelValues(f.Name, transport, f.Level.String()).Observe(metrics.Since(start))
+	// An error response terminates the request as it is, unless OnError
+	// recovers from it, FailOnStatus asks for an error or, for a parallel
+	// filter, there is no way to terminate.
+	if err == nil && res != nil && res.StatusCode > 399 {
+		err = netio.NewStatusError(res.Status, res.StatusCode)
+		if !f.FailOnStatus && !f.Parallel && !f.OnError.Recovers(err) {
+			metrics.FilterErrors.WithLabelValues(f.Name, transport, err.Kind().String()).Inc()
+			return netio.TERM, res, nil
+		}
+		res.Body.Close()
+	}
+	if err != nil {
+		metrics.FilterErrors.WithLabelValues(f.Name, transport, err.Kind().String()).Inc()
+		return f.OnError.Handle(parent, rv, c, o, err)
+	}
+	return next, res, nil
+}
+
+// BreakerState returns the state of the filter's circuit breaker, if any.
+func (f *Filter) BreakerState() string {
+	if f.Breaker == nil {
+		return ""
+	}
+	return f.Breaker.State().String()
+}
+
+func (f *Filter) Close() error {
+	errs := make([]error, 0)
+	if releaser, ok := f.instance.(releaser); ok {
+		errs = append(errs, releaser.release())
+	}
+	errs = append(errs, netio.Close(f.Callers...), f.OnError.Close())
+	return errors.Join(errs...)
 }
 
 func Await(resCh <-chan *netio.ShadowResponse, errCh <-chan error, ctx context.Context) (netio.Next, *http.Response, netio.Error) {
 	select {
 	case res := <-resCh:
 		{
-
-			if res.StatusCode > 399 {
-				return netio.TERM, nil, netio.NewError(res.Status, res.StatusCode)
-			}
 			return netio.CONTINUE, res.Response, nil
 		}
 	case err := <-errCh:
 		{
+			if errors.Is(err, nats.ErrNoResponders) {
+				return netio.TERM, nil, netio.NewTransportError(err)
+			}
 			return netio.TERM, nil, netio.NewError(err.Error(), http.StatusInternalServerError)
 		}
 	case <-ctx.Done():
 		{
-			return netio.TERM, nil, netio.NewError(context.DeadlineExceeded.Error(), http.StatusGatewayTimeout)
+			return netio.TERM, nil, netio.NewTimeoutError(context.DeadlineExceeded.Error())
 		}
 	}
 }
@@ -142,15 +296,27 @@ func (f *Filter) Build() (netio.Caller, error) {
 	switch strings.ToLower(f.Address.Scheme) {
 	case "http", "https":
 		{
-			return NewHttpFilter(f), nil
+			_, err := NewHttpFilter(f)
+			if err != nil {
+				return nil, err
+			}
+			return f, nil
 		}
 	case "jetstream":
 		{
-			return NewDurableNATSFilter(NewBaseNATS(f))
+			_, err := NewDurableNATSFilter(NewBaseNATS(f))
+			if err != nil {
+				return nil, err
+			}
+			return f, nil
 		}
 	case "nats":
 		{
-			return NewCoreNATSFilter(NewBaseNATS(f))
+			_, err := NewCoreNATSFilter(NewBaseNATS(f))
+			if err != nil {
+				return nil, err
+			}
+			return f, nil
 		}
 	}
 	return nil, fmt.Errorf("unsupported scheme %s", f.Address.Scheme)
@@ -0,0 +1,132 @@
+package filters
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vedadiyan/iceberg/internal/common/metrics"
+	"github.com/vedadiyan/iceberg/internal/common/netio"
+)
+
+func TestFilterMetrics(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		wantError bool
+	}{
+		{name: "success", status: http.StatusOK},
+		{name: "status error", status: http.StatusServiceUnavailable, wantError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+			}))
+			defer backend.Close()
+			address, err := url.Parse(backend.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filter := NewFilter()
+			filter.Name = "metrics"
+			filter.Address = address
+			filter.Level = netio.LEVEL_REQUEST
+			filter.OnError = OnError{Policy: POLICY_CONTINUE}
+			caller, err := filter.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer netio.Close(caller)
+			failures := metrics.FilterErrors.WithLabelValues("metrics", "http", "status")
+			before := testutil.ToFloat64(failures)
+			in, err := netio.NewShadowRequest(httptest.NewRequest("GET", "/", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, _, cerr := caller.Call(context.TODO(), nil, in.CloneRequest, in.CloneRequest)
+			if next != netio.CONTINUE || cerr != nil {
+				t.Fatalf("expected the filter to continue, got %v %v", next, cerr)
+			}
+			want := before
+			if test.wantError {
+				want++
+			}
+			if after := testutil.ToFloat64(failures); after != want {
+				t.Fatalf("expected %v filter errors, got %v", want, after)
+			}
+		})
+	}
+}
+
+func TestFilterStatus(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("denied"))
+	}))
+	defer backend.Close()
+	address, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		failOnStatus bool
+		parallel     bool
+		onError      OnError
+		wantNext     netio.Next
+		wantRes      bool
+		wantErr      bool
+	}{
+		{name: "pass through", onError: OnError{Policy: POLICY_TERM}, wantNext: netio.TERM, wantRes: true},
+		{name: "fail on status", failOnStatus: true, onError: OnError{Policy: POLICY_TERM}, wantNext: netio.TERM, wantErr: true},
+		{name: "recovered", onError: OnError{Policy: POLICY_CONTINUE}, wantNext: netio.CONTINUE},
+		{name: "unmatched recovery", onError: OnError{Policy: POLICY_CONTINUE, Conditions: []Condition{"5xx"}}, wantNext: netio.TERM, wantRes: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := NewFilter()
+			filter.Name = "status"
+			filter.Address = address
+			filter.Level = netio.LEVEL_REQUEST
+			filter.FailOnStatus = test.failOnStatus
+			filter.OnError = test.onError
+			caller, err := filter.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer netio.Close(caller)
+			in, err := netio.NewShadowRequest(httptest.NewRequest("GET", "/", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, res, cerr := caller.Call(context.TODO(), nil, in.CloneRequest, in.CloneRequest)
+			if next != test.wantNext {
+				t.Fatalf("expected %v, got %v", test.wantNext, next)
+			}
+			if (cerr != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, cerr)
+			}
+			if cerr != nil && cerr.Status() != http.StatusUnauthorized {
+				t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, cerr.Status())
+			}
+			if (res != nil) != test.wantRes {
+				t.Fatalf("expected response %v, got %v", test.wantRes, res)
+			}
+			if res != nil {
+				defer res.Body.Close()
+				body, err := io.ReadAll(res.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.StatusCode != http.StatusUnauthorized || string(body) != "denied" {
+					t.Fatalf("expected the backend response, got %d %s", res.StatusCode, body)
+				}
+			}
+		})
+	}
+}
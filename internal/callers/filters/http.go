@@ -5,32 +5,40 @@ import (
 	"net/http"
 
 	"github.com/vedadiyan/iceberg/internal/common/netio"
+	"github.com/vedadiyan/iceberg/internal/common/transport"
 )
 
 type (
 	HttpFilter struct {
 		*Filter
+		client *http.Client
 	}
 )
 
-func NewHttpFilter(f *Filter) *HttpFilter {
+func NewHttpFilter(f *Filter) (*HttpFilter, error) {
+	client, err := transport.GetClient(f.Transport)
+	if err != nil {
+		return nil, err
+	}
 	httpFilter := new(HttpFilter)
 	httpFilter.Filter = f
+	httpFilter.client = client
 	f.instance = httpFilter
-	return httpFilter
+	return httpFilter, nil
 }
 
 func (f *HttpFilter) Call(ctx context.Context, rv netio.RouteValues, c netio.Cloner, _ netio.Cloner) (netio.Next, *http.Response, netio.Error) {
-	r, err := c(netio.WithUrl(f.Address, rv), netio.WithContext(ctx))
+	r, err := c(netio.WithUrl(f.Address, rv), netio.WithContext(ctx), netio.WithoutHopByHop())
 	if err != nil {
 		return netio.TERM, nil, netio.NewError(err.Error(), http.StatusInternalServerError)
 	}
-	res, err := http.DefaultClient.Do(r)
+	res, err := f.client.Do(r)
 	if err != nil {
-		return netio.TERM, nil, netio.NewError(err.Error(), http.StatusBadGateway)
-	}
-	if res.StatusCode > 399 {
-		return netio.TERM, nil, netio.NewError(res.Status, res.StatusCode)
+		return netio.TERM, nil, netio.NewTransportError(err)
 	}
 	return netio.CONTINUE, res, nil
 }
+
+func (f *HttpFilter) release() error {
+	return transport.ReleaseClient(f.client)
+}
@@ -3,6 +3,8 @@ package filters
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -36,39 +38,71 @@ type (
 )
 
 const (
-	DURABLE_CHANNEL = "$ICEBERG.DURABLE"
+	DURABLE_CHANNEL      = "$ICEBERG.DURABLE"
+	NO_RESPONDERS_STATUS = "503"
 )
 
 var (
 	_conns   map[string]*nats.Conn
-	_gc      []func() error
+	_refs    map[string]int
+	_gc      map[string]func() error
 	_connMut sync.RWMutex
 )
 
 func init() {
 	_conns = make(map[string]*nats.Conn)
-	_gc = make([]func() error, 0)
+	_refs = make(map[string]int)
+	_gc = make(map[string]func() error)
 }
 
-func GetConn(url string, fn func(*nats.Conn) error) (*nats.Conn, error) {
+func GetConn(url string, reflect bool) (*nats.Conn, error) {
 	_connMut.Lock()
 	defer _connMut.Unlock()
-	if conn, ok := _conns[url]; ok {
-		return conn, nil
-	}
-	conn, err := nats.Connect(url)
-	if err != nil {
-		return nil, err
+	conn, ok := _conns[url]
+	if !ok {
+		c, err := nats.Connect(url)
+		if err != nil {
+			return nil, err
+		}
+		conn = c
+		_conns[url] = conn
 	}
-	if fn != nil {
-		err := fn(conn)
+	if _, ok := _gc[url]; reflect && !ok {
+		stop, err := CreateReflectorChannel(conn)
 		if err != nil {
+			if _refs[url] == 0 {
+				delete(_conns, url)
+				conn.Close()
+			}
 			return nil, err
 		}
+		_gc[url] = stop
 	}
+	_refs[url]++
 	return conn, nil
 }
 
+func ReleaseConn(url string) error {
+	_connMut.Lock()
+	defer _connMut.Unlock()
+	conn, ok := _conns[url]
+	if !ok {
+		return nil
+	}
+	_refs[url]--
+	if _refs[url] > 0 {
+		return nil
+	}
+	delete(_conns, url)
+	delete(_refs, url)
+	var err error
+	if stop, ok := _gc[url]; ok {
+		delete(_gc, url)
+		err = stop()
+	}
+	return errors.Join(err, netio.Drain(conn))
+}
+
 func MsgToRequest(m *nats.Msg) (*netio.ShadowRequest, error) {
 	req := http.Request{
 		Header: http.Header{},
@@ -118,13 +152,13 @@ func NewBaseNATS(f *Filter) *NatsBase {
 }
 
 func NewDurableNATSFilter(f *NatsBase) (*NatsJSFilter, error) {
-	conn, err := GetConn(f.Host, CreateReflectorChannel(f))
+	conn, err := GetConn(f.Host, true)
 	if err != nil {
 		return nil, err
 	}
 	queue, err := queue.New(conn, []string{f.Subject})
 	if err != nil {
-		return nil, err
+		return nil, errors.Join(err, ReleaseConn(f.Host))
 	}
 	nf := new(NatsJSFilter)
 	nf.NatsBase = f
@@ -138,46 +172,18 @@ func (f *NatsJSFilter) Call(ctx context.Context, _ netio.RouteValues, c netio.Cl
 	inbox := f.conn.NewRespInbox()
 	resCh := make(chan *netio.ShadowResponse, 1)
 	errCh := make(chan error, 1)
-	defer close(resCh)
-	defer close(errCh)
-	err := f.SubscribeOnce(inbox, resCh, errCh)
+	subs, err := f.SubscribeOnce(inbox, false, resCh, errCh)
 	if err != nil {
 		return netio.TERM, nil, netio.NewError(err.Error(), http.StatusInternalServerError)
 	}
+	defer unsubscribe(subs)
 	err = f.Publish(inbox, c)
 	if err != nil {
-		return netio.TERM, nil, netio.NewError(err.Error(), http.StatusBadGateway)
+		return netio.TERM, nil, netio.NewTransportError(err)
 	}
 	return Await(resCh, errCh, ctx)
 }
 
-func (f *NatsJSFilter) SubscribeOnce(inbox string, resCh chan<- *netio.ShadowResponse, errCh chan<- error) error {
-	handle := func(msg *nats.Msg) {
-		clone := *msg
-		headers, err := headers.Import(clone.Header)
-		if err != nil {
-			errCh <- err
-			return
-		}
-		if len(headers) > 0 {
-			clone.Header = nats.Header(headers)
-		}
-		res, err := MsgToResponse(&clone)
-		if err != nil {
-			errCh <- err
-			return
-		}
-		resCh <- res
-	}
-	subs, err := f.conn.Subscribe(inbox, func(msg *nats.Msg) {
-		go handle(msg)
-	})
-	if err != nil {
-		return err
-	}
-	return subs.AutoUnsubscribe(1)
-}
-
 func (f *NatsJSFilter) Publish(inbox string, c netio.Cloner) error {
 	req, err := c()
 	if err != nil {
@@ -203,7 +209,7 @@ func (f *NatsJSFilter) Publish(inbox string, c netio.Cloner) error {
 }
 
 func NewCoreNATSFilter(f *NatsBase) (*NatsCoreFilter, error) {
-	conn, err := GetConn(f.Host, nil)
+	conn, err := GetConn(f.Host, false)
 	if err != nil {
 		return nil, err
 	}
@@ -218,25 +224,51 @@ func (f *NatsCoreFilter) Call(ctx context.Context, _ netio.RouteValues, c netio.
 	inbox := f.conn.NewRespInbox()
 	resCh := make(chan *netio.ShadowResponse, 1)
 	errCh := make(chan error, 1)
-	defer close(resCh)
-	defer close(errCh)
-	err := f.SubscribeOnce(inbox, resCh, errCh)
+	subs, err := f.SubscribeOnce(inbox, true, resCh, errCh)
 	if err != nil {
 		return netio.TERM, nil, netio.NewError(err.Error(), http.StatusInternalServerError)
 	}
+	defer unsubscribe(subs)
 	err = f.Publish(inbox, c)
 	if err != nil {
-		return netio.TERM, nil, netio.NewError(err.Error(), http.StatusBadGateway)
+		return netio.TERM, nil, netio.NewTransportError(err)
 	}
 	return Await(resCh, errCh, ctx)
 }
 
-func (f *NatsCoreFilter) SubscribeOnce(inbox string, resCh chan<- *netio.ShadowResponse, errCh chan<- error) error {
+func (f *NatsCoreFilter) Publish(inbox string, c netio.Cloner) error {
+	req, err := c()
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	msg := &nats.Msg{
+		Subject: f.Subject,
+		Reply:   inbox,
+		Header:  nats.Header{},
+		Data:    data,
+	}
+	hdr := headers.Header(req.Header.Clone())
+	err = hdr.Export(msg.Header)
+	if err != nil {
+		return err
+	}
+	return f.conn.PublishMsg(msg)
+}
+
+// SubscribeOnce subscribes to the reply of a call. The reply may arrive
+// after the call gave up on it, so it is only offered to the channels. With
+// noResponders, the status NATS replies with when nobody listens on the
+// subject is offered as nats.ErrNoResponders.
+func (f *NatsBase) SubscribeOnce(inbox string, noResponders bool, resCh chan<- *netio.ShadowResponse, errCh chan<- error) (*nats.Subscription, error) {
 	handle := func(msg *nats.Msg) {
 		clone := *msg
 		headers, err := headers.Import(clone.Header)
 		if err != nil {
-			errCh <- err
+			offer(errCh, err)
 			return
 		}
 		if len(headers) > 0 {
@@ -244,10 +276,10 @@ func (f *NatsCoreFilter) SubscribeOnce(inbox string, resCh chan<- *netio.ShadowR
 		}
 		res, err := MsgToResponse(&clone)
 		if err != nil {
-			errCh <- err
+			offer(errCh, err)
 			return
 		}
-		resCh <- res
+		offer(resCh, res)
 	}
 	callbacks := func(msg *nats.Msg) {
 		shadowRequest, err := MsgToRequest(msg)
@@ -257,81 +289,85 @@ func (f *NatsCoreFilter) SubscribeOnce(inbox string, resCh chan<- *netio.ShadowR
 		netio.Cascade(shadowRequest, f.Callers...)
 	}
 	subs, err := f.conn.Subscribe(inbox, func(msg *nats.Msg) {
-		go func() {
+		if noResponders && len(msg.Data) == 0 && msg.Header.Get("Status") == NO_RESPONDERS_STATUS {
+			offer(errCh, nats.ErrNoResponders)
+			return
+		}
+		netio.Go(func() {
 			defer callbacks(msg)
 			handle(msg)
-		}()
+		})
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return subs.AutoUnsubscribe(1)
-}
-
-func (f *NatsCoreFilter) Publish(inbox string, c netio.Cloner) error {
-	req, err := c()
+	err = subs.AutoUnsubscribe(1)
 	if err != nil {
-		return err
+		return nil, errors.Join(err, subs.Unsubscribe())
 	}
-	data, err := io.ReadAll(req.Body)
-	if err != nil {
-		return err
-	}
-	msg := &nats.Msg{
-		Subject: f.Subject,
-		Reply:   inbox,
-		Header:  nats.Header{},
-		Data:    data,
+	return subs, nil
+}
+
+// unsubscribe stops the delivery of a reply nobody waits for anymore. The
+// subscription is already gone once its reply was delivered.
+func unsubscribe(subs *nats.Subscription) {
+	if subs.IsValid() {
+		_ = subs.Unsubscribe()
 	}
-	hdr := headers.Header(req.Header.Clone())
-	err = hdr.Export(msg.Header)
-	if err != nil {
-		return err
+}
+
+func offer[T any](ch chan<- T, value T) {
+	select {
+	case ch <- value:
+	default:
 	}
-	return f.conn.PublishMsg(msg)
 }
 
-func CreateReflectorChannel(f *NatsBase) func(c *nats.Conn) error {
-	return func(c *nats.Conn) error {
-		queue, err := queue.New(c, []string{DURABLE_CHANNEL})
+func (f *NatsBase) release() error {
+	return ReleaseConn(f.Host)
+}
+
+func CreateReflectorChannel(c *nats.Conn) (func() error, error) {
+	queue, err := queue.New(c, []string{DURABLE_CHANNEL})
+	if err != nil {
+		return nil, err
+	}
+	handle := func(m *nats.Msg) error {
+		clone := *m
+		headers, err := headers.Import(clone.Header)
 		if err != nil {
 			return err
 		}
-		handle := func(m *nats.Msg) error {
-			clone := *m
-			headers, err := headers.Import(clone.Header)
-			if err != nil {
-				return err
-			}
-			reply := headers.GetReply()
-			if len(reply) == 0 {
-				return err
-			}
-			clone.Subject = reply
-			clone.Reply = ""
-			headers.DeleteReply()
-			err = headers.Export(clone.Header)
-			if err != nil {
-				return err
-			}
-			return c.PublishMsg(&clone)
+		reply := headers.GetReply()
+		if len(reply) == 0 {
+			return err
 		}
-		callbacks := func(msg *nats.Msg) {
-			shadowRequest, err := MsgToRequest(msg)
-			if err != nil {
-				log.Println(err)
-			}
-			netio.Cascade(shadowRequest, f.Callers...)
+		clone.Subject = reply
+		clone.Reply = ""
+		headers.DeleteReply()
+		err = headers.Export(clone.Header)
+		if err != nil {
+			return err
+		}
+		return c.PublishMsg(&clone)
+	}
+	return queue.Pull(DURABLE_CHANNEL, func(msg *nats.Msg) natshelpers.State {
+		err := handle(msg)
+		if err != nil {
+			return natshelpers.Drop()
+		}
+		return natshelpers.Done()
+	})
+}
+
+func Check() error {
+	_connMut.RLock()
+	defer _connMut.RUnlock()
+	errs := make([]error, 0)
+	for url, conn := range _conns {
+		if status := conn.Status(); status != nats.CONNECTED {
+			errs = append(errs, fmt.Errorf("nats %s is %s", url, status.String()))
 		}
-		stop, err := queue.Pull(DURABLE_CHANNEL, func(msg *nats.Msg) natshelpers.State {
-			defer callbacks(msg)
-			err := handle(msg)
-			if err != nil {
-				return natshelpers.Drop()
-			}
-			return natshelpers.Done()
-		})
-		_gc = append(_gc, stop)
-		return err
 	}
+	return errors.Join(errs...)
 }
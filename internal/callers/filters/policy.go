@@ -0,0 +1,203 @@
+package filters
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/vedadiyan/iceberg/internal/common/netio"
+)
+
+type (
+	Policy    string
+	Condition string
+	OnError   struct {
+		Policy     Policy
+		Conditions []Condition
+		Fallback   netio.Caller
+		Response   *StaticResponse
+	}
+	StaticResponse struct {
+		Status int
+		Header http.Header
+		Body   []byte
+	}
+)
+
+const (
+	POLICY_DEFAULT  Policy = "default"
+	POLICY_TERM     Policy = "term"
+	POLICY_CONTINUE Policy = "continue"
+	POLICY_FALLBACK Policy = "fallback"
+
+	CONDITION_TIMEOUT   Condition = "timeout"
+	CONDITION_TRANSPORT Condition = "transport"
+	CONDITION_INTERNAL  Condition = "internal"
+	CONDITION_STATUS    Condition = "status"
+	CONDITION_4XX       Condition = "4xx"
+	CONDITION_5XX       Condition = "5xx"
+	CONDITION_OPEN      Condition = "open"
+)
+
+func ParsePolicy(policy string) (Policy, error) {
+	switch Policy(strings.ToLower(policy)) {
+	case "", POLICY_DEFAULT:
+		{
+			return POLICY_DEFAULT, nil
+		}
+	case POLICY_TERM:
+		{
+			return POLICY_TERM, nil
+		}
+	case POLICY_CONTINUE:
+		{
+			return POLICY_CONTINUE, nil
+		}
+	case POLICY_FALLBACK:
+		{
+			return POLICY_FALLBACK, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported onError policy %s", policy)
+}
+
+func ParseCondition(condition string) (Condition, error) {
+	switch Condition(strings.ToLower(condition)) {
+	case CONDITION_TIMEOUT:
+		{
+			return CONDITION_TIMEOUT, nil
+		}
+	case CONDITION_TRANSPORT:
+		{
+			return CONDITION_TRANSPORT, nil
+		}
+	case CONDITION_INTERNAL:
+		{
+			return CONDITION_INTERNAL, nil
+		}
+	case CONDITION_STATUS:
+		{
+			return CONDITION_STATUS, nil
+		}
+	case CONDITION_4XX:
+		{
+			return CONDITION_4XX, nil
+		}
+	case CONDITION_5XX:
+		{
+			return CONDITION_5XX, nil
+		}
+	case CONDITION_OPEN:
+		{
+			return CONDITION_OPEN, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported onError condition %s", condition)
+}
+
+func (condition Condition) Matches(err netio.Error) bool {
+	switch condition {
+	case CONDITION_TIMEOUT:
+		{
+			return err.Kind() == netio.ERROR_KIND_TIMEOUT
+		}
+	case CONDITION_TRANSPORT:
+		{
+			return err.Kind() == netio.ERROR_KIND_TRANSPORT
+		}
+	case CONDITION_INTERNAL:
+		{
+			return err.Kind() == netio.ERROR_KIND_INTERNAL
+		}
+	case CONDITION_STATUS:
+		{
+			return err.Kind() == netio.ERROR_KIND_STATUS
+		}
+	case CONDITION_4XX:
+		{
+			return err.Kind() == netio.ERROR_KIND_STATUS && err.Status() >= 400 && err.Status() < 500
+		}
+	case CONDITION_5XX:
+		{
+			return err.Kind() == netio.ERROR_KIND_STATUS && err.Status() >= 500
+		}
+	case CONDITION_OPEN:
+		{
+			return err.Kind() == netio.ERROR_KIND_OPEN
+		}
+	}
+	return false
+}
+
+func (onError *OnError) Matches(err netio.Error) bool {
+	if len(onError.Conditions) == 0 {
+		return true
+	}
+	for _, condition := range onError.Conditions {
+		if condition.Matches(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// Recovers reports whether the policy continues or falls back on err rather
+// than terminating the request.
+func (onError *OnError) Recovers(err netio.Error) bool {
+	return onError.Matches(err) && (onError.Policy == POLICY_CONTINUE || onError.Policy == POLICY_FALLBACK)
+}
+
+// Handle applies the policy to err. A fallback filter is called with ctx, the
+// context the failed filter was given, so it ends with the request but is
+// not bound by the failed filter's timeout.
+func (onError *OnError) Handle(ctx context.Context, rv netio.RouteValues, c netio.Cloner, o netio.Cloner, err netio.Error) (netio.Next, *http.Response, netio.Error) {
+	if !onError.Matches(err) {
+		return netio.TERM, nil, err
+	}
+	switch onError.Policy {
+	case POLICY_CONTINUE:
+		{
+			return netio.CONTINUE, nil, nil
+		}
+	case POLICY_FALLBACK:
+		{
+			if onError.Fallback != nil {
+				ctx, cancel := onError.Fallback.GetContext(ctx)
+				next, res, err := onError.Fallback.Call(ctx, rv, c, o)
+				return next, netio.CancelOnClose(res, cancel), err
+			}
+			if onError.Response != nil {
+				return netio.CONTINUE, onError.Response.Create(), nil
+			}
+		}
+	}
+	return netio.TERM, nil, err
+}
+
+func (onError *OnError) Close() error {
+	if onError.Fallback == nil {
+		return nil
+	}
+	return netio.Close(onError.Fallback)
+}
+
+func (staticResponse *StaticResponse) Create() *http.Response {
+	status := staticResponse.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := staticResponse.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(staticResponse.Body)),
+		ContentLength: int64(len(staticResponse.Body)),
+	}
+}
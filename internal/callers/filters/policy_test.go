@@ -0,0 +1,148 @@
+package filters
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/vedadiyan/iceberg/internal/common/netio"
+)
+
+func TestConditionMatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition string
+		err       netio.Error
+		want      bool
+	}{
+		{name: "timeout", condition: "timeout", err: netio.NewTimeoutError("deadline exceeded"), want: true},
+		{name: "timeout on transport", condition: "timeout", err: netio.NewTransportError(io.EOF)},
+		{name: "transport", condition: "transport", err: netio.NewTransportError(io.EOF), want: true},
+		{name: "deadline is a timeout", condition: "transport", err: netio.NewTransportError(context.DeadlineExceeded)},
+		{name: "internal", condition: "internal", err: netio.NewError("failed", http.StatusInternalServerError), want: true},
+		{name: "status", condition: "status", err: netio.NewStatusError("404 Not Found", http.StatusNotFound), want: true},
+		{name: "4xx", condition: "4xx", err: netio.NewStatusError("401 Unauthorized", http.StatusUnauthorized), want: true},
+		{name: "4xx on 5xx", condition: "4xx", err: netio.NewStatusError("503 Service Unavailable", http.StatusServiceUnavailable)},
+		{name: "5xx", condition: "5XX", err: netio.NewStatusError("503 Service Unavailable", http.StatusServiceUnavailable), want: true},
+		{name: "5xx on internal", condition: "5xx", err: netio.NewError("failed", http.StatusInternalServerError)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			condition, err := ParseCondition(test.condition)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if matches := condition.Matches(test.err); matches != test.want {
+				t.Fatalf("expected %v, got %v", test.want, matches)
+			}
+		})
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		want    Policy
+		wantErr bool
+	}{
+		{policy: "", want: POLICY_DEFAULT},
+		{policy: "Continue", want: POLICY_CONTINUE},
+		{policy: "term", want: POLICY_TERM},
+		{policy: "fallback", want: POLICY_FALLBACK},
+		{policy: "retry", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			policy, err := ParsePolicy(test.policy)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if policy != test.want {
+				t.Fatalf("expected %s, got %s", test.want, policy)
+			}
+		})
+	}
+	if _, err := ParseCondition("teapot"); err == nil {
+		t.Fatalf("expected an unsupported condition to fail")
+	}
+}
+
+func TestOnErrorHandle(t *testing.T) {
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fallback"))
+	}))
+	defer fallback.Close()
+	address, err := url.Parse(fallback.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout := netio.NewTimeoutError("deadline exceeded")
+	unavailable := netio.NewStatusError("503 Service Unavailable", http.StatusServiceUnavailable)
+	tests := []struct {
+		name       string
+		onError    OnError
+		err        netio.Error
+		fallback   bool
+		wantNext   netio.Next
+		wantBody   string
+		wantStatus int
+		wantErr    bool
+	}{
+		{name: "default", onError: OnError{Policy: POLICY_DEFAULT}, err: unavailable, wantNext: netio.TERM, wantErr: true},
+		{name: "term", onError: OnError{Policy: POLICY_TERM}, err: unavailable, wantNext: netio.TERM, wantErr: true},
+		{name: "continue", onError: OnError{Policy: POLICY_CONTINUE}, err: unavailable, wantNext: netio.CONTINUE},
+		{name: "continue on matching condition", onError: OnError{Policy: POLICY_CONTINUE, Conditions: []Condition{CONDITION_TIMEOUT, CONDITION_5XX}}, err: unavailable, wantNext: netio.CONTINUE},
+		{name: "continue on other condition", onError: OnError{Policy: POLICY_CONTINUE, Conditions: []Condition{CONDITION_TIMEOUT}}, err: unavailable, wantNext: netio.TERM, wantErr: true},
+		{name: "static fallback", onError: OnError{Policy: POLICY_FALLBACK, Response: &StaticResponse{Status: http.StatusAccepted, Body: []byte("static")}}, err: timeout, wantNext: netio.CONTINUE, wantBody: "static", wantStatus: http.StatusAccepted},
+		{name: "static fallback default status", onError: OnError{Policy: POLICY_FALLBACK, Response: &StaticResponse{Body: []byte("static")}}, err: timeout, wantNext: netio.CONTINUE, wantBody: "static", wantStatus: http.StatusOK},
+		{name: "fallback filter", onError: OnError{Policy: POLICY_FALLBACK}, fallback: true, err: timeout, wantNext: netio.CONTINUE, wantBody: "fallback", wantStatus: http.StatusOK},
+		{name: "fallback on other condition", onError: OnError{Policy: POLICY_FALLBACK, Conditions: []Condition{CONDITION_4XX}}, fallback: true, err: unavailable, wantNext: netio.TERM, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.fallback {
+				filter := NewFilter()
+				filter.Name = "fallback"
+				filter.Address = address
+				filter.Level = netio.LEVEL_REQUEST
+				caller, err := filter.Build()
+				if err != nil {
+					t.Fatal(err)
+				}
+				test.onError.Fallback = caller
+				defer test.onError.Close()
+			}
+			in, err := netio.NewShadowRequest(httptest.NewRequest("GET", "/", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, res, herr := test.onError.Handle(context.TODO(), nil, in.CloneRequest, in.CloneRequest, test.err)
+			if next != test.wantNext {
+				t.Fatalf("expected next %v, got %v", test.wantNext, next)
+			}
+			if (herr != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, herr)
+			}
+			if herr != nil && herr != test.err {
+				t.Fatalf("expected the original error, got %s", herr.Message())
+			}
+			if len(test.wantBody) == 0 {
+				if res != nil {
+					t.Fatalf("expected no response, got %d", res.StatusCode)
+				}
+				return
+			}
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != test.wantBody || res.StatusCode != test.wantStatus {
+				t.Fatalf("expected %d %s, got %d %s", test.wantStatus, test.wantBody, res.StatusCode, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package proxies
+
+import (
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vedadiyan/iceberg/internal/common/netio"
+)
+
+type (
+	Strategy string
+	Endpoint struct {
+		Address *url.URL
+		Weight  int
+
+		outstanding  atomic.Int64
+		failures     atomic.Int64
+		ejectedUntil atomic.Int64
+		unhealthy    atomic.Bool
+		current      int
+	}
+	// HashKey selects the request value hashed by the hash strategy. The
+	// first non-empty of header, cookie and route value is used.
+	HashKey struct {
+		Header     string
+		Cookie     string
+		RouteValue string
+	}
+	// Ejection removes an endpoint from rotation for Duration after
+	// Failures consecutive failed calls.
+	Ejection struct {
+		Failures int
+		Duration time.Duration
+	}
+	// Balancer spreads calls across the endpoints of a backend. Unhealthy
+	// endpoints are skipped unless no endpoint is healthy, and ejected ones
+	// unless every healthy endpoint is ejected.
+	Balancer struct {
+		Endpoints []*Endpoint
+		Strategy  Strategy
+		Hash      HashKey
+		Ejection  Ejection
+
+		mut  sync.Mutex
+		next atomic.Uint64
+		ring []point
+	}
+	EndpointInfo struct {
+		Address     string `json:"address"`
+		Weight      int    `json:"weight"`
+		Outstanding int64  `json:"outstanding"`
+		Ejected     bool   `json:"ejected,omitempty"`
+		Unhealthy   bool   `json:"unhealthy,omitempty"`
+	}
+	point struct {
+		hash     uint32
+		endpoint *Endpoint
+	}
+	eligibility int
+)
+
+const (
+	STRATEGY_ROUND_ROBIN    Strategy = "roundRobin"
+	STRATEGY_LEAST_REQUESTS Strategy = "leastRequests"
+	STRATEGY_HASH           Strategy = "hash"
+
+	EJECTION_FAILURES = 5
+	EJECTION_DURATION = time.Second * 30
+
+	HASH_REPLICAS = 160
+)
+
+// endpoints eligible for a pick, from the most to the least strict
+const (
+	ELIGIBLE_AVAILABLE eligibility = iota
+	ELIGIBLE_HEALTHY
+	ELIGIBLE_ANY
+)
+
+func ParseStrategy(strategy string) (Strategy, error) {
+	switch strings.ToLower(strategy) {
+	case "", strings.ToLower(string(STRATEGY_ROUND_ROBIN)):
+		{
+			return STRATEGY_ROUND_ROBIN, nil
+		}
+	case strings.ToLower(string(STRATEGY_LEAST_REQUESTS)):
+		{
+			return STRATEGY_LEAST_REQUESTS, nil
+		}
+	case strings.ToLower(string(STRATEGY_HASH)):
+		{
+			return STRATEGY_HASH, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported load balancing strategy %s", strategy)
+}
+
+func NewBalancer(balancer *Balancer) *Balancer {
+	for _, endpoint := range balancer.Endpoints {
+		if endpoint.Weight <= 0 {
+			endpoint.Weight = 1
+		}
+	}
+	if balancer.Ejection.Failures <= 0 {
+		balancer.Ejection.Failures = EJECTION_FAILURES
+	}
+	if balancer.Ejection.Duration <= 0 {
+		balancer.Ejection.Duration = EJECTION_DURATION
+	}
+	if balancer.Strategy == STRATEGY_HASH {
+		for _, endpoint := range balancer.Endpoints {
+			for i := 0; i < HASH_REPLICAS*endpoint.Weight; i++ {
+				hash := crc32.ChecksumIEEE([]byte(endpoint.Address.String() + "#" + strconv.Itoa(i)))
+				balancer.ring = append(balancer.ring, point{hash: hash, endpoint: endpoint})
+			}
+		}
+		sort.Slice(balancer.ring, func(i, j int) bool {
+			return balancer.ring[i].hash < balancer.ring[j].hash
+		})
+	}
+	return balancer
+}
+
+// Pick selects the endpoint for a request and counts it as outstanding
+// until Done is called.
+func (balancer *Balancer) Pick(r *http.Request, rv netio.RouteValues) *Endpoint {
+	var endpoint *Endpoint
+	if len(balancer.Endpoints) == 1 {
+		endpoint = balancer.Endpoints[0]
+	} else {
+		now := time.Now().UnixNano()
+		level := balancer.level(now)
+		switch balancer.Strategy {
+		case STRATEGY_LEAST_REQUESTS:
+			{
+				endpoint = balancer.leastRequests(now, level)
+			}
+		case STRATEGY_HASH:
+			{
+				if key := balancer.Hash.value(r, rv); len(key) != 0 {
+					endpoint = balancer.hash(key, now, level)
+				}
+			}
+		}
+		if endpoint == nil {
+			endpoint = balancer.roundRobin(now, level)
+		}
+	}
+	endpoint.outstanding.Add(1)
+	return endpoint
+}
+
+// Done records the outcome of a call to endpoint. Consecutive failures eject
+// it once they reach the ejection threshold.
+func (balancer *Balancer) Done(endpoint *Endpoint, failed bool) {
+	endpoint.outstanding.Add(-1)
+	if !failed {
+		endpoint.failures.Store(0)
+		return
+	}
+	if endpoint.failures.Add(1) < int64(balancer.Ejection.Failures) {
+		return
+	}
+	endpoint.failures.Store(0)
+	endpoint.ejectedUntil.Store(time.Now().Add(balancer.Ejection.Duration).UnixNano())
+}
+
+func (balancer *Balancer) Describe() []EndpointInfo {
+	now := time.Now().UnixNano()
+	out := make([]EndpointInfo, 0, len(balancer.Endpoints))
+	for _, endpoint := range balancer.Endpoints {
+		out = append(out, EndpointInfo{
+			Address:     endpoint.Address.String(),
+			Weight:      endpoint.Weight,
+			Outstanding: endpoint.outstanding.Load(),
+			Ejected:     endpoint.isEjected(now),
+			Unhealthy:   endpoint.unhealthy.Load(),
+		})
+	}
+	return out
+}
+
+// Healthy reports whether any endpoint passes its active health checks.
+func (balancer *Balancer) Healthy() bool {
+	return balancer.level(time.Now().UnixNano()) != ELIGIBLE_ANY
+}
+
+// Available reports whether any endpoint is healthy and not ejected.
+func (balancer *Balancer) Available() bool {
+	return balancer.level(time.Now().UnixNano()) == ELIGIBLE_AVAILABLE
+}
+
+func (balancer *Balancer) level(now int64) eligibility {
+	level := ELIGIBLE_ANY
+	for _, endpoint := range balancer.Endpoints {
+		if endpoint.unhealthy.Load() {
+			continue
+		}
+		if !endpoint.isEjected(now) {
+			return ELIGIBLE_AVAILABLE
+		}
+		level = ELIGIBLE_HEALTHY
+	}
+	return level
+}
+
+// roundRobin is a smooth weighted round robin: every pick raises each
+// endpoint by its weight and lowers the chosen one by the total.
+func (balancer *Balancer) roundRobin(now int64, level eligibility) *Endpoint {
+	balancer.mut.Lock()
+	defer balancer.mut.Unlock()
+	var best *Endpoint
+	total := 0
+	for _, endpoint := range balancer.Endpoints {
+		if !endpoint.isEligible(now, level) {
+			continue
+		}
+		endpoint.current += endpoint.Weight
+		total += endpoint.Weight
+		if best == nil || endpoint.current > best.current {
+			best = endpoint
+		}
+	}
+	best.current -= total
+	return best
+}
+
+// leastRequests picks the endpoint with the fewest outstanding calls per
+// unit of weight, starting the scan at a rotating offset to spread ties.
+func (balancer *Balancer) leastRequests(now int64, level eligibility) *Endpoint {
+	var best *Endpoint
+	var bestLoad float64
+	offset := int(balancer.next.Add(1) % uint64(len(balancer.Endpoints)))
+	for i := range balancer.Endpoints {
+		endpoint := balancer.Endpoints[(offset+i)%len(balancer.Endpoints)]
+		if !endpoint.isEligible(now, level) {
+			continue
+		}
+		load := float64(endpoint.outstanding.Load()) / float64(endpoint.Weight)
+		if best == nil || load < bestLoad {
+			best = endpoint
+			bestLoad = load
+		}
+	}
+	return best
+}
+
+func (balancer *Balancer) hash(key string, now int64, level eligibility) *Endpoint {
+	hash := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(balancer.ring), func(i int) bool {
+		return balancer.ring[i].hash >= hash
+	})
+	for i := 0; i < len(balancer.ring); i++ {
+		endpoint := balancer.ring[(start+i)%len(balancer.ring)].endpoint
+		if endpoint.isEligible(now, level) {
+			return endpoint
+		}
+	}
+	return nil
+}
+
+func (hashKey HashKey) value(r *http.Request, rv netio.RouteValues) string {
+	if len(hashKey.Header) != 0 {
+		if value := r.Header.Get(hashKey.Header); len(value) != 0 {
+			return value
+		}
+	}
+	if len(hashKey.Cookie) != 0 {
+		if cookie, err := r.Cookie(hashKey.Cookie); err == nil && len(cookie.Value) != 0 {
+			return cookie.Value
+		}
+	}
+	if len(hashKey.RouteValue) != 0 {
+		return rv[hashKey.RouteValue]
+	}
+	return ""
+}
+
+func (endpoint *Endpoint) isEjected(now int64) bool {
+	return endpoint.ejectedUntil.Load() > now
+}
+
+func (endpoint *Endpoint) isEligible(now int64, level eligibility) bool {
+	switch level {
+	case ELIGIBLE_AVAILABLE:
+		{
+			return !endpoint.unhealthy.Load() && !endpoint.isEjected(now)
+		}
+	case ELIGIBLE_HEALTHY:
+		{
+			return !endpoint.unhealthy.Load()
+		}
+	}
+	return true
+}
@@ -0,0 +1,225 @@
+package proxies
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vedadiyan/iceberg/internal/common/netio"
+)
+
+func endpoints(weights ...int) []*Endpoint {
+	endpoints := make([]*Endpoint, 0)
+	for index, weight := range weights {
+		endpoints = append(endpoints, &Endpoint{
+			Address: &url.URL{Scheme: "http", Host: fmt.Sprintf("backend-%d", index)},
+			Weight:  weight,
+		})
+	}
+	return endpoints
+}
+
+func picks(balancer *Balancer, n int, r *http.Request, rv netio.RouteValues) string {
+	out := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		endpoint := balancer.Pick(r, rv)
+		balancer.Done(endpoint, false)
+		out = append(out, strings.TrimPrefix(endpoint.Address.Host, "backend-"))
+	}
+	return strings.Join(out, "")
+}
+
+func TestParseStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		want     Strategy
+		wantErr  bool
+	}{
+		{name: "default", strategy: "", want: STRATEGY_ROUND_ROBIN},
+		{name: "round robin", strategy: "roundRobin", want: STRATEGY_ROUND_ROBIN},
+		{name: "least requests", strategy: "LEASTREQUESTS", want: STRATEGY_LEAST_REQUESTS},
+		{name: "hash", strategy: "hash", want: STRATEGY_HASH},
+		{name: "unknown", strategy: "random", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy, err := ParseStrategy(test.strategy)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if strategy != test.want {
+				t.Fatalf("expected %v, got %v", test.want, strategy)
+			}
+		})
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+		want    string
+	}{
+		{name: "even", weights: []int{1, 1, 1}, want: "012012"},
+		{name: "weighted", weights: []int{3, 1}, want: "00100010"},
+		{name: "smooth", weights: []int{5, 1, 1}, want: "0010200"},
+		{name: "default weight", weights: []int{0, -1}, want: "0101"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			balancer := NewBalancer(&Balancer{Endpoints: endpoints(test.weights...)})
+			r := httptest.NewRequest("GET", "/", nil)
+			if order := picks(balancer, len(test.want), r, nil); order != test.want {
+				t.Fatalf("expected %s, got %s", test.want, order)
+			}
+		})
+	}
+}
+
+func TestLeastRequests(t *testing.T) {
+	balancer := NewBalancer(&Balancer{Endpoints: endpoints(1, 2, 1), Strategy: STRATEGY_LEAST_REQUESTS})
+	r := httptest.NewRequest("GET", "/", nil)
+	held := make([]*Endpoint, 0)
+	for i := 0; i < 4; i++ {
+		held = append(held, balancer.Pick(r, nil))
+	}
+	want := []int64{1, 2, 1}
+	for index, endpoint := range balancer.Endpoints {
+		if outstanding := endpoint.outstanding.Load(); outstanding != want[index] {
+			t.Fatalf("endpoint %d: expected %d outstanding, got %d", index, want[index], outstanding)
+		}
+	}
+	for _, endpoint := range held {
+		balancer.Done(endpoint, false)
+	}
+	for index, endpoint := range balancer.Endpoints {
+		if outstanding := endpoint.outstanding.Load(); outstanding != 0 {
+			t.Fatalf("endpoint %d: expected no outstanding calls, got %d", index, outstanding)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	tests := []struct {
+		name string
+		key  HashKey
+		r    func() *http.Request
+		rv   netio.RouteValues
+	}{
+		{name: "header", key: HashKey{Header: "X-User"}, r: func() *http.Request {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-User", "alice")
+			return r
+		}},
+		{name: "cookie", key: HashKey{Cookie: "session"}, r: func() *http.Request {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(&http.Cookie{Name: "session", Value: "s-1"})
+			return r
+		}},
+		{name: "route value", key: HashKey{RouteValue: "id"}, r: func() *http.Request {
+			return httptest.NewRequest("GET", "/", nil)
+		}, rv: netio.RouteValues{"id": "42"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			balancer := NewBalancer(&Balancer{Endpoints: endpoints(1, 1, 1, 1), Strategy: STRATEGY_HASH, Hash: test.key})
+			order := picks(balancer, 8, test.r(), test.rv)
+			if order != strings.Repeat(order[:1], 8) {
+				t.Fatalf("expected every pick to reach the same endpoint, got %s", order)
+			}
+		})
+	}
+	balancer := NewBalancer(&Balancer{Endpoints: endpoints(1, 1), Strategy: STRATEGY_HASH, Hash: HashKey{Header: "X-User"}})
+	if order := picks(balancer, 4, httptest.NewRequest("GET", "/", nil), nil); order != "0101" {
+		t.Fatalf("expected requests without a key to fall back to round robin, got %s", order)
+	}
+}
+
+func TestEjection(t *testing.T) {
+	balancer := NewBalancer(&Balancer{Endpoints: endpoints(1, 1), Ejection: Ejection{Failures: 2, Duration: time.Minute}})
+	r := httptest.NewRequest("GET", "/", nil)
+	failing := balancer.Endpoints[0]
+	balancer.Done(balancer.Pick(r, nil), true)
+	balancer.Done(balancer.Pick(r, nil), false)
+	if failing.isEjected(time.Now().UnixNano()) {
+		t.Fatalf("expected a single failure to keep the endpoint")
+	}
+	failing.outstanding.Add(2)
+	balancer.Done(failing, true)
+	balancer.Done(failing, true)
+	if !failing.isEjected(time.Now().UnixNano()) {
+		t.Fatalf("expected consecutive failures to eject the endpoint")
+	}
+	if order := picks(balancer, 4, r, nil); order != "1111" {
+		t.Fatalf("expected the ejected endpoint to be skipped, got %s", order)
+	}
+	balancer.Endpoints[1].ejectedUntil.Store(time.Now().Add(time.Minute).UnixNano())
+	if order := picks(balancer, 4, r, nil); order != "0101" && order != "1010" {
+		t.Fatalf("expected every endpoint to be used once all are ejected, got %s", order)
+	}
+	want := []EndpointInfo{
+		{Address: "http://backend-0", Weight: 1, Ejected: true},
+		{Address: "http://backend-1", Weight: 1, Ejected: true},
+	}
+	if info := balancer.Describe(); !reflect.DeepEqual(info, want) {
+		t.Fatalf("expected %v, got %v", want, info)
+	}
+}
+
+func TestEjectionReset(t *testing.T) {
+	balancer := NewBalancer(&Balancer{Endpoints: endpoints(1), Ejection: Ejection{Failures: 2}})
+	endpoint := balancer.Endpoints[0]
+	for _, failed := range []bool{true, false, true, false} {
+		endpoint.outstanding.Add(1)
+		balancer.Done(endpoint, failed)
+	}
+	if endpoint.isEjected(time.Now().UnixNano()) {
+		t.Fatalf("expected a success to reset the failure count")
+	}
+	if balancer.Ejection.Duration != EJECTION_DURATION {
+		t.Fatalf("expected %v, got %v", EJECTION_DURATION, balancer.Ejection.Duration)
+	}
+}
+
+func TestHandleBalanced(t *testing.T) {
+	hits := make([]atomic.Int64, 2)
+	backends := make([]*Endpoint, 0)
+	for index := range hits {
+		index := index
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[index].Add(1)
+		}))
+		defer server.Close()
+		address, err := url.Parse(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backends = append(backends, &Endpoint{Address: address, Weight: index + 1})
+	}
+	handler, err := NewProxy(&Proxy{
+		Name:     "balanced",
+		Address:  backends[0].Address,
+		Balancer: NewBalancer(&Balancer{Endpoints: backends}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	for i := 0; i < 6; i++ {
+		w := httptest.NewRecorder()
+		handler.Handle(w, httptest.NewRequest("GET", "/", nil), nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected %d, got %d", http.StatusOK, w.Code)
+		}
+	}
+	if a, b := hits[0].Load(), hits[1].Load(); a != 2 || b != 4 {
+		t.Fatalf("expected 2 and 4 calls, got %d and %d", a, b)
+	}
+}
@@ -1,39 +1,160 @@
 package proxies
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"time"
 
+	"github.com/vedadiyan/iceberg/internal/common/breaker"
 	"github.com/vedadiyan/iceberg/internal/common/netio"
+	"github.com/vedadiyan/iceberg/internal/common/retry"
+	"github.com/vedadiyan/iceberg/internal/common/transport"
 )
 
 type (
 	Handler interface {
 		Handle(w http.ResponseWriter, r *http.Request, rv netio.RouteValues)
+		Pipeline() []netio.Caller
+		Check(ctx context.Context) error
+		Describe() any
+		// Release frees the handler once it no longer receives requests,
+		// leaving what it still serves running. Close ends everything.
+		Release() error
+		Close() error
 	}
+	Description struct {
+		Name      string             `json:"name"`
+		Backend   string             `json:"backend"`
+		Endpoints []EndpointInfo     `json:"endpoints,omitempty"`
+		Breaker   string             `json:"circuitBreaker,omitempty"`
+		Pipeline  []netio.CallerInfo `json:"pipeline"`
+	}
+	// Proxy forwards to Address, or to the endpoints of Balancer when there
+	// are several, in which case Address is the first of them. Error
+	// responses of the backend are passed through, unless FailOnStatus
+	// turns them into errors that only keep the status. Health and Released
+	// are shared with the proxies built by later reloads.
 	Proxy struct {
-		Name    string
-		Address *url.URL
-		Timeout time.Duration
-		Callers []netio.Caller
+		Name         string
+		Address      *url.URL
+		Balancer     *Balancer
+		HealthCheck  *HealthCheck
+		Retry        *retry.Policy
+		Breaker      *breaker.Breaker
+		FailOnStatus bool
+		Rewrite      *netio.Rewrite
+		Body         *netio.BodyOptions
+		Transport    *transport.Options
+		Timeout      time.Duration
+		Callers      []netio.Caller
+		Health       *Health
+		Released     *Released
+
+		health *healthChecker
 	}
 )
 
-func NewProxy(address *url.URL, callers []netio.Caller) (Handler, error) {
-	proxy := new(Proxy)
-	proxy.Address = address
-	proxy.Callers = callers
+func NewProxy(proxy *Proxy) (Handler, error) {
+	if proxy.Balancer == nil {
+		proxy.Balancer = NewBalancer(&Balancer{
+			Endpoints: []*Endpoint{{Address: proxy.Address}},
+		})
+	}
+	var handler Handler
+	var err error
 	switch proxy.Address.Scheme {
 	case "http", "https":
 		{
-			return NewHttpProxy(proxy), nil
+			handler, err = NewHttpProxy(proxy)
 		}
 	case "ws", "wss":
 		{
-			return NewWebSocket(proxy), nil
+			handler, err = NewWebSocket(proxy)
+		}
+	default:
+		{
+			return nil, fmt.Errorf("protocol not supported")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if proxy.HealthCheck != nil {
+		proxy.health, err = newHealthChecker(proxy)
+		if err != nil {
+			return nil, errors.Join(err, handler.Close())
 		}
 	}
-	return nil, fmt.Errorf("protocol not supported")
+	return handler, nil
+}
+
+func (p *Proxy) GetAddress() *url.URL {
+	return p.Address
+}
+
+// Check reports the backend as ready when any of its endpoints passes its
+// health checks or, without health checks, accepts a connection.
+func (p *Proxy) Check(ctx context.Context) error {
+	if p.health != nil {
+		if !p.Balancer.Healthy() {
+			return fmt.Errorf("no healthy endpoint")
+		}
+		return nil
+	}
+	errs := make([]error, 0)
+	for _, endpoint := range p.Balancer.Endpoints {
+		err := dial(ctx, endpoint.Address)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Proxy) describe(pipeline []netio.Caller) *Description {
+	description := &Description{
+		Name:     p.Name,
+		Backend:  p.Address.String(),
+		Pipeline: netio.Describe(pipeline...),
+	}
+	if len(p.Balancer.Endpoints) > 1 || p.health != nil {
+		description.Endpoints = p.Balancer.Describe()
+	}
+	if p.Breaker != nil {
+		description.Breaker = p.Breaker.State().String()
+	}
+	return description
+}
+
+// unavailable fails a request fast while no endpoint of the backend is
+// healthy, telling the client when to try again.
+func (p *Proxy) unavailable(w http.ResponseWriter) bool {
+	if p.health == nil || p.Balancer.Healthy() {
+		return false
+	}
+	w.Header().Set("Retry-After", p.HealthCheck.RetryAfter())
+	http.Error(w, "no healthy backend endpoint", http.StatusServiceUnavailable)
+	return true
+}
+
+func dial(ctx context.Context, address *url.URL) error {
+	host := address.Host
+	if len(address.Port()) == 0 {
+		port := "80"
+		if address.Scheme == "https" || address.Scheme == "wss" {
+			port = "443"
+		}
+		host = net.JoinHostPort(address.Hostname(), port)
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
 }
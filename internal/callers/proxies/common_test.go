@@ -0,0 +1,42 @@
+package proxies
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+	tests := []struct {
+		name      string
+		addresses []string
+		wantErr   bool
+	}{
+		{name: "up", addresses: []string{up.URL}},
+		{name: "down", addresses: []string{down.URL}, wantErr: true},
+		{name: "any endpoint up", addresses: []string{down.URL, up.URL}},
+		{name: "every endpoint down", addresses: []string{down.URL, down.URL}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endpoints := make([]*Endpoint, 0)
+			for _, address := range test.addresses {
+				address, err := url.Parse(address)
+				if err != nil {
+					t.Fatal(err)
+				}
+				endpoints = append(endpoints, &Endpoint{Address: address})
+			}
+			proxy := &Proxy{Name: test.name, Address: endpoints[0].Address, Balancer: NewBalancer(&Balancer{Endpoints: endpoints})}
+			if err := proxy.Check(context.TODO()); (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package proxies
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vedadiyan/iceberg/internal/common/metrics"
+	"github.com/vedadiyan/iceberg/internal/common/transport"
+)
+
+type (
+	// HealthCheck polls every endpoint of a backend. An endpoint starts
+	// unhealthy, becomes healthy after HealthyThreshold passing checks and
+	// unhealthy again after UnhealthyThreshold failing ones.
+	HealthCheck struct {
+		Path               string
+		Status             []int
+		Interval           time.Duration
+		Timeout            time.Duration
+		HealthyThreshold   int
+		UnhealthyThreshold int
+	}
+	// Health keeps the last known health of checked endpoints and their
+	// iceberg_backend_endpoint_healthy gauges while any proxy checks them,
+	// so that the proxies of a reload do not start known endpoints over as
+	// unhealthy.
+	Health struct {
+		mut       sync.Mutex
+		endpoints map[healthKey]*healthState
+	}
+	healthKey struct {
+		name    string
+		address string
+		path    string
+	}
+	healthState struct {
+		unhealthy bool
+		refs      int
+	}
+	healthChecker struct {
+		check  *HealthCheck
+		health *Health
+		name   string
+		client *http.Client
+		stop   chan struct{}
+		wg     sync.WaitGroup
+		keys   []healthKey
+	}
+)
+
+const (
+	HEALTH_CHECK_INTERVAL = time.Second * 10
+	HEALTH_CHECK_TIMEOUT  = time.Second * 2
+	HEALTHY_THRESHOLD     = 1
+	UNHEALTHY_THRESHOLD   = 3
+)
+
+func NewHealth() *Health {
+	return &Health{
+		endpoints: make(map[healthKey]*healthState),
+	}
+}
+
+func newHealthChecker(p *Proxy) (*healthChecker, error) {
+	check := *p.HealthCheck
+	if check.Interval <= 0 {
+		check.Interval = HEALTH_CHECK_INTERVAL
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = HEALTH_CHECK_TIMEOUT
+	}
+	if check.HealthyThreshold <= 0 {
+		check.HealthyThreshold = HEALTHY_THRESHOLD
+	}
+	if check.UnhealthyThreshold <= 0 {
+		check.UnhealthyThreshold = UNHEALTHY_THRESHOLD
+	}
+	p.HealthCheck = &check
+	client, err := transport.GetClient(p.Transport)
+	if err != nil {
+		return nil, err
+	}
+	health := p.Health
+	if health == nil {
+		health = NewHealth()
+	}
+	healthChecker := &healthChecker{
+		check:  &check,
+		health: health,
+		name:   p.Name,
+		client: client,
+		stop:   make(chan struct{}),
+	}
+	for _, endpoint := range p.Balancer.Endpoints {
+		key := healthChecker.key(endpoint)
+		healthChecker.keys = append(healthChecker.keys, key)
+		endpoint.unhealthy.Store(health.acquire(key))
+		healthChecker.wg.Add(1)
+		go healthChecker.run(endpoint)
+	}
+	return healthChecker, nil
+}
+
+// acquire returns the health of an endpoint, which starts unhealthy unless
+// another proxy already checks it.
+func (health *Health) acquire(key healthKey) bool {
+	health.mut.Lock()
+	defer health.mut.Unlock()
+	state, ok := health.endpoints[key]
+	if !ok {
+		state = &healthState{unhealthy: true}
+		health.endpoints[key] = state
+		metrics.BackendHealthy.WithLabelValues(key.name, key.address).Set(0)
+	}
+	state.refs++
+	return state.unhealthy
+}
+
+func (health *Health) set(key healthKey, unhealthy bool) {
+	health.mut.Lock()
+	defer health.mut.Unlock()
+	state, ok := health.endpoints[key]
+	if !ok {
+		return
+	}
+	state.unhealthy = unhealthy
+	healthy := 1.0
+	if unhealthy {
+		healthy = 0
+	}
+	metrics.BackendHealthy.WithLabelValues(key.name, key.address).Set(healthy)
+}
+
+// release forgets an endpoint, and removes its gauge, once no proxy checks
+// it anymore.
+func (health *Health) release(key healthKey) {
+	health.mut.Lock()
+	defer health.mut.Unlock()
+	state, ok := health.endpoints[key]
+	if !ok {
+		return
+	}
+	state.refs--
+	if state.refs > 0 {
+		return
+	}
+	delete(health.endpoints, key)
+	for other := range health.endpoints {
+		if other.name == key.name && other.address == key.address {
+			return
+		}
+	}
+	metrics.BackendHealthy.DeleteLabelValues(key.name, key.address)
+}
+
+func (healthChecker *healthChecker) run(endpoint *Endpoint) {
+	defer healthChecker.wg.Done()
+	passed, failed := 0, 0
+	ticker := time.NewTicker(healthChecker.check.Interval)
+	defer ticker.Stop()
+	for {
+		err := healthChecker.probe(endpoint.Address)
+		if err == nil {
+			passed, failed = passed+1, 0
+		} else {
+			passed, failed = 0, failed+1
+		}
+		switch {
+		case passed >= healthChecker.check.HealthyThreshold && endpoint.unhealthy.Load():
+			{
+				healthChecker.set(endpoint, false)
+			}
+		case failed >= healthChecker.check.UnhealthyThreshold && !endpoint.unhealthy.Load():
+			{
+				healthChecker.set(endpoint, true)
+			}
+		}
+		select {
+		case <-healthChecker.stop:
+			{
+				return
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+func (healthChecker *healthChecker) set(endpoint *Endpoint, unhealthy bool) {
+	endpoint.unhealthy.Store(unhealthy)
+	healthChecker.health.set(healthChecker.key(endpoint), unhealthy)
+}
+
+func (healthChecker *healthChecker) key(endpoint *Endpoint) healthKey {
+	return healthKey{
+		name:    healthChecker.name,
+		address: endpoint.Address.String(),
+		path:    healthChecker.check.Path,
+	}
+}
+
+func (healthChecker *healthChecker) probe(address *url.URL) error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthChecker.check.Timeout)
+	defer cancel()
+	target := *address
+	switch strings.ToLower(target.Scheme) {
+	case "ws":
+		{
+			target.Scheme = "http"
+		}
+	case "wss":
+		{
+			target.Scheme = "https"
+		}
+	}
+	target.Path = healthChecker.check.Path
+	target.RawPath = ""
+	target.RawQuery = ""
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := healthChecker.client.Do(r)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	if !healthChecker.check.expects(res.StatusCode) {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (healthChecker *healthChecker) close() error {
+	if healthChecker == nil {
+		return nil
+	}
+	close(healthChecker.stop)
+	healthChecker.wg.Wait()
+	for _, key := range healthChecker.keys {
+		healthChecker.health.release(key)
+	}
+	return transport.ReleaseClient(healthChecker.client)
+}
+
+func (check *HealthCheck) expects(status int) bool {
+	if len(check.Status) == 0 {
+		return status >= 200 && status < 400
+	}
+	for _, expected := range check.Status {
+		if status == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryAfter is the Retry-After value, in seconds, sent while a backend has
+// no healthy endpoint.
+func (check *HealthCheck) RetryAfter() string {
+	seconds := int((check.Interval + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return fmt.Sprint(seconds)
+}
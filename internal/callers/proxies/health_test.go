@@ -0,0 +1,208 @@
+package proxies
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vedadiyan/iceberg/internal/common/metrics"
+)
+
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met in time")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func TestHealthCheckExpects(t *testing.T) {
+	tests := []struct {
+		name   string
+		status []int
+		code   int
+		want   bool
+	}{
+		{name: "default success", code: http.StatusNoContent, want: true},
+		{name: "default redirect", code: http.StatusFound, want: true},
+		{name: "default error", code: http.StatusInternalServerError},
+		{name: "listed", status: []int{http.StatusOK, http.StatusTeapot}, code: http.StatusTeapot, want: true},
+		{name: "not listed", status: []int{http.StatusOK}, code: http.StatusNoContent},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			check := &HealthCheck{Status: test.status}
+			if expected := check.expects(test.code); expected != test.want {
+				t.Fatalf("expected %v, got %v", test.want, expected)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		want     string
+	}{
+		{name: "seconds", interval: time.Second * 10, want: "10"},
+		{name: "rounded up", interval: time.Millisecond * 1500, want: "2"},
+		{name: "at least one", interval: time.Millisecond, want: "1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			check := &HealthCheck{Interval: test.interval}
+			if retryAfter := check.RetryAfter(); retryAfter != test.want {
+				t.Fatalf("expected %s, got %s", test.want, retryAfter)
+			}
+		})
+	}
+}
+
+func TestHealthThresholds(t *testing.T) {
+	var failing atomic.Bool
+	var probes atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		probes.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer backend.Close()
+	address, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing.Store(true)
+	handler, err := NewProxy(&Proxy{
+		Name:    "health",
+		Address: address,
+		HealthCheck: &HealthCheck{
+			Path:               "/healthz",
+			Interval:           time.Millisecond * 10,
+			HealthyThreshold:   2,
+			UnhealthyThreshold: 3,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	proxy := handler.(*HttpProxy)
+	endpoint := proxy.Balancer.Endpoints[0]
+	gauge := metrics.BackendHealthy.WithLabelValues("health", address.String())
+
+	eventually(t, func() bool { return probes.Load() >= 3 })
+	if !endpoint.unhealthy.Load() || testutil.ToFloat64(gauge) != 0 {
+		t.Fatalf("expected the endpoint to start unhealthy")
+	}
+	w := httptest.NewRecorder()
+	handler.Handle(w, httptest.NewRequest("GET", "/", nil), nil)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected a fast 503 with Retry-After, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	failing.Store(false)
+	from := probes.Load()
+	eventually(t, func() bool { return !endpoint.unhealthy.Load() })
+	if passed := probes.Load() - from; passed < 2 {
+		t.Fatalf("expected %d passing checks before turning healthy, got %d", 2, passed)
+	}
+	if testutil.ToFloat64(gauge) != 1 {
+		t.Fatalf("expected the healthy gauge to be 1")
+	}
+	w = httptest.NewRecorder()
+	handler.Handle(w, httptest.NewRequest("GET", "/", nil), nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected the request to reach the backend, got %d", w.Code)
+	}
+
+	failing.Store(true)
+	from = probes.Load()
+	eventually(t, func() bool { return endpoint.unhealthy.Load() })
+	if failed := probes.Load() - from; failed < 3 {
+		t.Fatalf("expected %d failing checks before turning unhealthy, got %d", 3, failed)
+	}
+	if testutil.ToFloat64(gauge) != 0 {
+		t.Fatalf("expected the healthy gauge to be 0")
+	}
+}
+
+func TestHealthAcrossReloads(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	address, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	health := NewHealth()
+	build := func() (Handler, *Endpoint) {
+		handler, err := NewProxy(&Proxy{
+			Name:        "reloaded",
+			Address:     address,
+			HealthCheck: &HealthCheck{Path: "/", Interval: time.Hour},
+			Health:      health,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handler, handler.(*HttpProxy).Balancer.Endpoints[0]
+	}
+	old, endpoint := build()
+	eventually(t, func() bool { return !endpoint.unhealthy.Load() })
+	new, endpoint := build()
+	if endpoint.unhealthy.Load() {
+		t.Fatalf("expected the endpoint to keep its health across a reload")
+	}
+	if err := old.Close(); err != nil {
+		t.Fatal(err)
+	}
+	gauge := metrics.BackendHealthy.WithLabelValues("reloaded", address.String())
+	if testutil.ToFloat64(gauge) != 1 {
+		t.Fatalf("expected the gauge to be kept while the endpoint is checked")
+	}
+	if err := new.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(health.endpoints) != 0 {
+		t.Fatalf("expected no endpoint to be kept, got %v", health.endpoints)
+	}
+	if metrics.BackendHealthy.DeleteLabelValues("reloaded", address.String()) {
+		t.Fatalf("expected the gauge to be removed once the endpoint is no longer checked")
+	}
+}
+
+func TestPickHealthy(t *testing.T) {
+	balancer := NewBalancer(&Balancer{Endpoints: endpoints(1, 1, 1)})
+	r := httptest.NewRequest("GET", "/", nil)
+	balancer.Endpoints[0].unhealthy.Store(true)
+	balancer.Endpoints[1].ejectedUntil.Store(time.Now().Add(time.Minute).UnixNano())
+	if order := picks(balancer, 3, r, nil); order != "222" {
+		t.Fatalf("expected only the healthy, available endpoint, got %s", order)
+	}
+	balancer.Endpoints[2].unhealthy.Store(true)
+	if !balancer.Healthy() || balancer.Available() {
+		t.Fatalf("expected a healthy but unavailable backend")
+	}
+	if order := picks(balancer, 3, r, nil); order != "111" {
+		t.Fatalf("expected the ejected healthy endpoint before unhealthy ones, got %s", order)
+	}
+	balancer.Endpoints[1].unhealthy.Store(true)
+	if balancer.Healthy() {
+		t.Fatalf("expected an unhealthy backend")
+	}
+	if order := picks(balancer, 3, r, nil); len(order) != 3 {
+		t.Fatalf("expected picks to go on once no endpoint is healthy, got %s", order)
+	}
+}
@@ -2,10 +2,14 @@ package proxies
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"time"
 
+	"github.com/vedadiyan/iceberg/internal/common/breaker"
+	"github.com/vedadiyan/iceberg/internal/common/metrics"
 	"github.com/vedadiyan/iceberg/internal/common/netio"
+	"github.com/vedadiyan/iceberg/internal/common/transport"
 )
 
 type (
@@ -15,18 +19,25 @@ type (
 
 		RequestUpdaters  []netio.RequestUpdater
 		ResponseUpdaters []netio.ResponseUpdater
+
+		client *http.Client
 	}
 )
 
-func NewHttpProxy(p *Proxy) *HttpProxy {
+func NewHttpProxy(p *Proxy) (*HttpProxy, error) {
+	client, err := transport.GetClient(p.Transport)
+	if err != nil {
+		return nil, err
+	}
 	httpProxy := new(HttpProxy)
+	httpProxy.client = client
 	httpProxy.Proxy = p
 	httpProxy.Callers = netio.Sort(append(httpProxy.Callers, httpProxy)...)
 	httpProxy.ResponseUpdaters = make([]netio.ResponseUpdater, 0)
 	httpProxy.RequestUpdaters = make([]netio.RequestUpdater, 0)
-	httpProxy.RequestUpdaters = append(httpProxy.RequestUpdaters, netio.ReqReplaceBody(), netio.ReqReplaceHeader(), netio.ReqReplaceTailer())
+	httpProxy.RequestUpdaters = append(httpProxy.RequestUpdaters, netio.ReqReplaceBody(), netio.ReqReplaceHeader(), netio.ReqReplaceTailer(), netio.ReqReplaceStatus())
 	httpProxy.ResponseUpdaters = append(httpProxy.ResponseUpdaters, netio.ResReplaceBody(), netio.ResReplaceHeader(), netio.ResReplaceTailer())
-	return httpProxy
+	return httpProxy, nil
 }
 
 func (f *HttpProxy) GetRequestUpdaters() []netio.RequestUpdater {
@@ -57,16 +68,16 @@ func (f *HttpProxy) GetIsParallel() bool {
 	return false
 }
 
-func (f *HttpProxy) GetContext() context.Context {
-	ctx, cancel := context.WithCancel(context.TODO())
+func (f *HttpProxy) GetContext(parent context.Context) (context.Context, context.CancelFunc) {
 	timeout := f.Timeout
 	if timeout == 0 {
 		timeout = time.Second * 30
 	}
-	time.AfterFunc(time.Until(time.Now().Add(timeout)), func() {
-		cancel()
-	})
-	return ctx
+	return netio.WithTimeout(parent, timeout)
+}
+
+func (f *HttpProxy) StreamsBody() bool {
+	return true
 }
 
 func (f *HttpProxy) GetLevel() netio.Level {
@@ -74,32 +85,111 @@ func (f *HttpProxy) GetLevel() netio.Level {
 }
 
 func (f *HttpProxy) Call(ctx context.Context, rv netio.RouteValues, c netio.Cloner, _ netio.Cloner) (netio.Next, *http.Response, netio.Error) {
-	r, err := c(netio.WithUrl(f.Address, rv), netio.WithContext(ctx))
+	done, ok := f.Breaker.Allow()
+	if !ok {
+		return netio.TERM, nil, netio.NewOpenError(breaker.ErrOpen.Error())
+	}
+	next, res, err := f.Retry.Do(ctx, f.Name, c, func(ctx context.Context, c netio.Cloner) (netio.Next, *http.Response, netio.Error) {
+		return f.call(ctx, rv, c)
+	})
+	done(breaker.Classify(ctx, res, err))
+	return next, res, err
+}
+
+func (f *HttpProxy) call(ctx context.Context, rv netio.RouteValues, c netio.Cloner) (netio.Next, *http.Response, netio.Error) {
+	r, err := c(netio.WithContext(ctx), netio.WithoutHopByHop())
 	if err != nil {
 		return netio.TERM, nil, netio.NewError(err.Error(), http.StatusInternalServerError)
 	}
-	res, err := http.DefaultClient.Do(r)
+	endpoint := f.Balancer.Pick(r, rv)
+	netio.WithRewrite(endpoint.Address, f.Rewrite, rv)(r)
+	start := time.Now()
+	res, err := f.client.Do(r)
 	if err != nil {
-		return netio.TERM, nil, netio.NewError(err.Error(), http.StatusBadGateway)
+		metrics.BackendDuration.WithLabelValues(f.Name, "error").Observe(metrics.Since(start))
+		if errors.Is(err, netio.ErrBodyTooLarge) {
+			f.Balancer.Done(endpoint, false)
+			return netio.TERM, nil, netio.NewError(err.Error(), http.StatusRequestEntityTooLarge)
+		}
+		f.Balancer.Done(endpoint, !errors.Is(err, context.Canceled))
+		return netio.TERM, nil, netio.NewTransportError(err)
 	}
-	if res.StatusCode > 399 {
-		return netio.TERM, nil, netio.NewError(res.Status, res.StatusCode)
+	f.Balancer.Done(endpoint, res.StatusCode == http.StatusBadGateway || res.StatusCode == http.StatusServiceUnavailable || res.StatusCode == http.StatusGatewayTimeout)
+	metrics.BackendDuration.WithLabelValues(f.Name, metrics.Status(res.StatusCode)).Observe(metrics.Since(start))
+	if f.FailOnStatus && res.StatusCode > 399 {
+		res.Body.Close()
+		return netio.TERM, nil, netio.NewStatusError(res.Status, res.StatusCode)
 	}
+	netio.RemoveHopByHop(res.Header)
 	res.Header.Add("X-Request-Id", r.Header.Get("X-Request-Id"))
 	return netio.CONTINUE, res, nil
 }
 
+func (f *HttpProxy) Pipeline() []netio.Caller {
+	return f.Callers
+}
+
+func (f *HttpProxy) Describe() any {
+	return f.describe(f.Pipeline())
+}
+
+func (f *HttpProxy) Release() error {
+	return f.Close()
+}
+
+func (f *HttpProxy) Close() error {
+	callers := make([]netio.Caller, 0)
+	for _, caller := range f.Callers {
+		if caller == netio.Caller(f) {
+			continue
+		}
+		callers = append(callers, caller)
+	}
+	return errors.Join(f.health.close(), netio.Close(callers...), transport.ReleaseClient(f.client))
+}
+
 func (f *HttpProxy) Handle(w http.ResponseWriter, r *http.Request, rv netio.RouteValues) {
-	in, err := netio.NewShadowRequest(r)
+	start := time.Now()
+	status := http.StatusOK
+	defer func() {
+		labels := []string{f.Name, r.Method, metrics.Status(status)}
+		metrics.Requests.WithLabelValues(labels...).Inc()
+		metrics.RequestDuration.WithLabelValues(labels...).Observe(metrics.Since(start))
+	}()
+	if f.unavailable(w) {
+		status = http.StatusServiceUnavailable
+		return
+	}
+	spool := f.Body.NewSpool(f.Callers...)
+	defer spool.Close()
+	if spool.TooLarge(r.ContentLength) {
+		status = http.StatusRequestEntityTooLarge
+		http.Error(w, netio.ErrBodyTooLarge.Error(), status)
+		return
+	}
+	in, err := netio.NewSpooledRequest(r, spool)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		status = http.StatusInternalServerError
+		if errors.Is(err, netio.ErrBodyTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
 	in.RouteValues = rv
 	out, _err := netio.Cascade(in, f.Callers...)
 	if _err != nil {
-		http.Error(w, _err.Message(), _err.Status())
+		status = _err.Status()
+		http.Error(w, _err.Message(), status)
 		return
 	}
+	if out == nil {
+		status = http.StatusBadGateway
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if out.StatusCode != 0 {
+		status = out.StatusCode
+	}
 	out.Write(w)
 }
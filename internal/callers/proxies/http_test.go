@@ -0,0 +1,235 @@
+package proxies
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/vedadiyan/iceberg/internal/common/metrics"
+	"github.com/vedadiyan/iceberg/internal/common/netio"
+)
+
+type (
+	// chunked hides the length of a body so that it is sent chunked.
+	chunked struct {
+		io.Reader
+	}
+	// terminator ends the request without a response.
+	terminator struct {
+		netio.Caller
+	}
+)
+
+func (terminator) GetLevel() netio.Level {
+	return netio.LEVEL_REQUEST
+}
+
+func (terminator) GetIsParallel() bool {
+	return false
+}
+
+func (terminator) GetName() string {
+	return "terminator"
+}
+
+func (terminator) GetAwaitList() []string {
+	return nil
+}
+
+func (terminator) GetContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithCancel(parent)
+}
+
+func (terminator) Call(context.Context, netio.RouteValues, netio.Cloner, netio.Cloner) (netio.Next, *http.Response, netio.Error) {
+	return netio.TERM, nil, nil
+}
+
+func TestHandleMetrics(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, err := strconv.Atoi(r.Header.Get("X-Status"))
+		if err != nil {
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
+	}))
+	defer backend.Close()
+	address, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		method     string
+		wantStatus string
+	}{
+		{name: "success", method: "POST", wantStatus: "201"},
+		{name: "backend error", method: "GET", wantStatus: "503"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler, err := NewProxy(&Proxy{Name: "metrics", Address: address})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer handler.Close()
+			requests := metrics.Requests.WithLabelValues("metrics", test.method, test.wantStatus)
+			duration := metrics.BackendDuration.WithLabelValues("metrics", test.wantStatus)
+			before, beforeDuration := testutil.ToFloat64(requests), samples(t, duration)
+			r := httptest.NewRequest(test.method, "/", nil)
+			r.Header.Set("X-Status", test.wantStatus)
+			handler.Handle(httptest.NewRecorder(), r, nil)
+			if after := testutil.ToFloat64(requests); after != before+1 {
+				t.Fatalf("expected requests_total to grow by 1, got %v -> %v", before, after)
+			}
+			if after := samples(t, duration); after != beforeDuration+1 {
+				t.Fatalf("expected one backend duration sample, got %d -> %d", beforeDuration, after)
+			}
+		})
+	}
+}
+
+func TestHandleBodyLimit(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(strconv.Itoa(len(body))))
+	}))
+	defer backend.Close()
+	address, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		stream     bool
+		memory     int64
+		size       int
+		chunked    bool
+		wantStatus int
+	}{
+		{name: "under limit", size: 32, wantStatus: http.StatusOK},
+		{name: "spilled under limit", memory: 8, size: 32, wantStatus: http.StatusOK},
+		{name: "content length over limit", size: 33, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "chunked over limit", size: 33, chunked: true, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "spilled chunked over limit", memory: 8, size: 33, chunked: true, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "streamed under limit", stream: true, size: 32, chunked: true, wantStatus: http.StatusOK},
+		{name: "streamed content length over limit", stream: true, size: 33, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "streamed chunked over limit", stream: true, size: 33, chunked: true, wantStatus: http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler, err := NewProxy(&Proxy{
+				Name:    "test",
+				Address: address,
+				Body: &netio.BodyOptions{
+					Stream:  test.stream,
+					Memory:  test.memory,
+					MaxSize: 32,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer handler.Close()
+			var body io.Reader = bytes.NewReader(bytes.Repeat([]byte("x"), test.size))
+			if test.chunked {
+				body = chunked{body}
+			}
+			r := httptest.NewRequest("POST", "/", body)
+			if test.chunked {
+				r.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			handler.Handle(w, r, nil)
+			if w.Code != test.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", test.wantStatus, w.Code, w.Body.String())
+			}
+			if test.wantStatus == http.StatusOK && w.Body.String() != strconv.Itoa(test.size) {
+				t.Fatalf("expected the backend to read %d bytes, got %s", test.size, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandlePassThrough(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.Header().Set("X-Reason", "missing")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"title":"not found"}`))
+	}))
+	defer backend.Close()
+	address, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		failOnStatus bool
+		wantBody     string
+		wantType     string
+		wantReason   string
+	}{
+		{name: "pass through", wantBody: `{"title":"not found"}`, wantType: "application/problem+json", wantReason: "missing"},
+		{name: "fail on status", failOnStatus: true, wantBody: "404 Not Found\n", wantType: "text/plain; charset=utf-8"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler, err := NewProxy(&Proxy{Name: "errors", Address: address, FailOnStatus: test.failOnStatus})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer handler.Close()
+			w := httptest.NewRecorder()
+			handler.Handle(w, httptest.NewRequest("GET", "/", nil), nil)
+			if w.Code != http.StatusNotFound {
+				t.Fatalf("expected %d, got %d", http.StatusNotFound, w.Code)
+			}
+			if w.Body.String() != test.wantBody {
+				t.Fatalf("expected %q, got %q", test.wantBody, w.Body.String())
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != test.wantType {
+				t.Fatalf("expected %s, got %s", test.wantType, contentType)
+			}
+			if reason := w.Header().Get("X-Reason"); reason != test.wantReason {
+				t.Fatalf("expected %q, got %q", test.wantReason, reason)
+			}
+		})
+	}
+}
+
+func TestHandleTerminated(t *testing.T) {
+	address, err := url.Parse("http://backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := NewProxy(&Proxy{Name: "terminated", Address: address, Callers: []netio.Caller{terminator{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	w := httptest.NewRecorder()
+	handler.Handle(w, httptest.NewRequest("GET", "/", nil), nil)
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("expected %d, got %d", http.StatusBadGateway, w.Code)
+	}
+}
+
+func samples(t *testing.T, observer prometheus.Observer) uint64 {
+	var metric dto.Metric
+	if err := observer.(prometheus.Metric).Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
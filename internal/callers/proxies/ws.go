@@ -3,12 +3,19 @@ package proxies
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
+	"log"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/vedadiyan/iceberg/internal/common/breaker"
+	"github.com/vedadiyan/iceberg/internal/common/metrics"
 	"github.com/vedadiyan/iceberg/internal/common/netio"
+	"github.com/vedadiyan/iceberg/internal/common/transport"
 )
 
 type (
@@ -17,9 +24,39 @@ type (
 		ConnectCallers  []netio.Caller
 		RequestCallers  []netio.Caller
 		ResponseCallers []netio.Caller
+
+		dialer   *websocket.Dialer
+		mut      sync.Mutex
+		sessions map[*WebSocketSession]struct{}
+		closed   bool
+		released bool
+		freed    bool
+		free     sync.Once
+		freeErr  error
+	}
+	// Released keeps the WebSocket proxies released by a reload while
+	// sessions were still open on them, so that shutdown can close those
+	// sessions.
+	Released struct {
+		mut     sync.Mutex
+		proxies map[*WebSocketProxy]struct{}
+	}
+	WebSocketSession struct {
+		in         *websocket.Conn
+		out        *websocket.Conn
+		generation int
+		mut        sync.Mutex
+		dial       sync.Mutex
+		done       chan struct{}
+		once       sync.Once
 	}
 )
 
+const (
+	CLOSE_TIMEOUT      = time.Second
+	RECONNECT_INTERVAL = time.Second * 5
+)
+
 var (
 	upgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
@@ -27,12 +64,18 @@ var (
 	}
 )
 
-func NewWebSocket(p *Proxy) *WebSocketProxy {
+func NewWebSocket(p *Proxy) (*WebSocketProxy, error) {
+	dialer, err := NewDialer(p.Transport)
+	if err != nil {
+		return nil, err
+	}
 	webSocketProxy := new(WebSocketProxy)
 	webSocketProxy.Proxy = p
+	webSocketProxy.dialer = dialer
 	webSocketProxy.ConnectCallers = make([]netio.Caller, 0)
 	webSocketProxy.RequestCallers = make([]netio.Caller, 0)
 	webSocketProxy.ResponseCallers = make([]netio.Caller, 0)
+	webSocketProxy.sessions = make(map[*WebSocketSession]struct{})
 	for _, caller := range p.Callers {
 		switch caller.GetLevel() {
 		case netio.LEVEL_CONNECT:
@@ -71,7 +114,30 @@ func NewWebSocket(p *Proxy) *WebSocketProxy {
 			}
 		}
 	}
-	return webSocketProxy
+	return webSocketProxy, nil
+}
+
+// NewDialer creates the dialer of a WebSocket backend. Only the dial,
+// keep-alive, handshake and TLS settings of the transport apply.
+func NewDialer(options *transport.Options) (*websocket.Dialer, error) {
+	if options == nil {
+		return websocket.DefaultDialer, nil
+	}
+	tlsConfig, err := transport.TLSConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	netDialer := &net.Dialer{
+		Timeout:   options.DialTimeout,
+		KeepAlive: options.KeepAlive,
+	}
+	dialer := *websocket.DefaultDialer
+	dialer.NetDialContext = netDialer.DialContext
+	dialer.TLSClientConfig = tlsConfig
+	if options.TLSHandshakeTimeout != 0 {
+		dialer.HandshakeTimeout = options.TLSHandshakeTimeout
+	}
+	return &dialer, nil
 }
 
 func (f *WebSocketProxy) GetRequestUpdaters() []netio.RequestUpdater {
@@ -94,49 +160,187 @@ func (f *WebSocketProxy) GetIsParallel() bool {
 	return false
 }
 
-func (f *WebSocketProxy) GetContext() context.Context {
-	ctx, cancel := context.WithCancel(context.TODO())
+func (f *WebSocketProxy) GetContext(parent context.Context) (context.Context, context.CancelFunc) {
 	timeout := f.Timeout
 	if timeout == 0 {
 		timeout = time.Second * 30
 	}
-	time.AfterFunc(time.Until(time.Now().Add(timeout)), func() {
-		cancel()
-	})
-	return ctx
+	return netio.WithTimeout(parent, timeout)
 }
 
 func (f *WebSocketProxy) GetLevel() netio.Level {
 	return netio.LEVEL_NONE
 }
 
+func (f *WebSocketProxy) Pipeline() []netio.Caller {
+	return netio.Sort(f.Callers...)
+}
+
+func (f *WebSocketProxy) Describe() any {
+	return f.describe(f.Pipeline())
+}
+
+// Release leaves the open sessions running on the proxy after a reload, and
+// frees the proxy once the last of them ends.
+func (f *WebSocketProxy) Release() error {
+	f.mut.Lock()
+	f.released = true
+	idle := len(f.sessions) == 0
+	f.freed = f.freed || idle
+	f.mut.Unlock()
+	if idle {
+		return f.release()
+	}
+	f.Released.add(f)
+	return nil
+}
+
+func (f *WebSocketProxy) Close() error {
+	f.mut.Lock()
+	sessions := f.sessions
+	f.sessions = nil
+	f.closed = true
+	f.mut.Unlock()
+	for session := range sessions {
+		session.Close(websocket.CloseGoingAway, "server shutting down")
+	}
+	return f.release()
+}
+
+func (f *WebSocketProxy) release() error {
+	f.free.Do(func() {
+		f.Released.remove(f)
+		f.freeErr = errors.Join(f.health.close(), netio.Close(f.Callers...))
+	})
+	return f.freeErr
+}
+
+func NewReleased() *Released {
+	return &Released{
+		proxies: make(map[*WebSocketProxy]struct{}),
+	}
+}
+
+func (released *Released) add(f *WebSocketProxy) {
+	if released == nil {
+		return
+	}
+	released.mut.Lock()
+	defer released.mut.Unlock()
+	released.proxies[f] = struct{}{}
+}
+
+func (released *Released) remove(f *WebSocketProxy) {
+	if released == nil {
+		return
+	}
+	released.mut.Lock()
+	defer released.mut.Unlock()
+	delete(released.proxies, f)
+}
+
+// Close closes the sessions still open on the released proxies.
+func (released *Released) Close() error {
+	released.mut.Lock()
+	proxies := make([]*WebSocketProxy, 0, len(released.proxies))
+	for f := range released.proxies {
+		proxies = append(proxies, f)
+	}
+	released.mut.Unlock()
+	errs := make([]error, 0)
+	for _, f := range proxies {
+		errs = append(errs, f.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (f *WebSocketProxy) track(session *WebSocketSession) bool {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	if f.closed || f.freed {
+		return false
+	}
+	f.sessions[session] = struct{}{}
+	return true
+}
+
+func (f *WebSocketProxy) untrack(session *WebSocketSession) {
+	f.mut.Lock()
+	delete(f.sessions, session)
+	idle := f.released && !f.freed && len(f.sessions) == 0
+	f.freed = f.freed || idle
+	f.mut.Unlock()
+	if idle {
+		err := f.release()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
 func (inProxy *WebSocketProxy) Handle(w http.ResponseWriter, r *http.Request, rv netio.RouteValues) {
+	if inProxy.unavailable(w) {
+		return
+	}
 	req, err := netio.NewShadowRequest(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 	_, _err := netio.Cascade(req, inProxy.ConnectCallers...)
 	if _err != nil {
 		http.Error(w, _err.Message(), _err.Status())
+		return
 	}
-	in, err := upgrader.Upgrade(w, r, http.Header{})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
-
-	inProxy.Address.Path = r.URL.Path
 
 	handler := func() (*websocket.Conn, error) {
-		out, _, err := websocket.DefaultDialer.Dial(inProxy.Address.String(), nil)
+		done, ok := inProxy.Breaker.Allow()
+		if !ok {
+			return nil, breaker.ErrOpen
+		}
+		endpoint := inProxy.Balancer.Pick(r, rv)
+		target := inProxy.Rewrite.URL(endpoint.Address, r.URL, rv).String()
+		header := http.Header{}
+		for _, key := range netio.FORWARDED_HEADERS {
+			if values := r.Header.Values(key); len(values) != 0 {
+				header[key] = values
+			}
+		}
+		if host := inProxy.Rewrite.HostHeader(endpoint.Address, r.Host); host != endpoint.Address.Host {
+			header.Set("Host", host)
+		}
+		out, _, err := inProxy.dialer.Dial(target, header)
+		inProxy.Balancer.Done(endpoint, err != nil)
+		done(breaker.Failure(err != nil))
 		return out, err
 	}
 
 	out, err := handler()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		status := http.StatusBadGateway
+		if errors.Is(err, breaker.ErrOpen) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	in, err := upgrader.Upgrade(w, r, http.Header{})
+	if err != nil {
+		out.Close()
+		return
+	}
+
+	session := NewWebSocketSession(in, out)
+	if !inProxy.track(session) {
+		session.Close(websocket.CloseGoingAway, "server shutting down")
+		return
 	}
 
+	metrics.WebSocketSessions.WithLabelValues(inProxy.Name).Inc()
 	go func() {
+		defer metrics.WebSocketSessions.WithLabelValues(inProxy.Name).Dec()
+		defer inProxy.untrack(session)
+		defer session.Close(websocket.CloseNormalClosure, "")
 		for {
 			_, message, err := in.ReadMessage()
 			if err != nil {
@@ -159,28 +363,49 @@ func (inProxy *WebSocketProxy) Handle(w http.ResponseWriter, r *http.Request, rv
 				continue
 			}
 			for {
+				out, generation := session.Out()
 				err = out.WriteMessage(websocket.TextMessage, message)
 				if err == nil {
 					break
 				}
-				o, err := handler()
+				err = session.Reconnect(generation, handler)
 				if err == nil {
-					out = o
-					break
+					continue
+				}
+				if !inProxy.Balancer.Available() {
+					session.Close(websocket.CloseTryAgainLater, "backend unavailable")
+					return
+				}
+				select {
+				case <-session.Done():
+					{
+						return
+					}
+				case <-time.After(RECONNECT_INTERVAL):
 				}
-				<-time.After(time.Second * 5)
 			}
 		}
 	}()
 
 	go func() {
 		for {
+			out, generation := session.Out()
 			_, message, err := out.ReadMessage()
 			if err != nil {
-				<-time.After(time.Second * 5)
-				o, err := handler()
+				select {
+				case <-session.Done():
+					{
+						return
+					}
+				case <-time.After(RECONNECT_INTERVAL):
+				}
+				err := session.Reconnect(generation, handler)
 				if err == nil {
-					out = o
+					continue
+				}
+				if !inProxy.Balancer.Available() {
+					session.Close(websocket.CloseTryAgainLater, "backend unavailable")
+					return
 				}
 				continue
 			}
@@ -205,3 +430,77 @@ func (inProxy *WebSocketProxy) Handle(w http.ResponseWriter, r *http.Request, rv
 		}
 	}()
 }
+
+func NewWebSocketSession(in *websocket.Conn, out *websocket.Conn) *WebSocketSession {
+	session := new(WebSocketSession)
+	session.in = in
+	session.out = out
+	session.done = make(chan struct{})
+	return session
+}
+
+// Out returns the backend connection and its generation, which grows with
+// every reconnect.
+func (session *WebSocketSession) Out() (*websocket.Conn, int) {
+	session.mut.Lock()
+	defer session.mut.Unlock()
+	return session.out, session.generation
+}
+
+// Reconnect replaces the backend connection of generation with one from dial.
+// Both directions of a session reconnect on failure, so a connection the
+// other one already replaced is not dialed again.
+func (session *WebSocketSession) Reconnect(generation int, dial func() (*websocket.Conn, error)) error {
+	session.dial.Lock()
+	defer session.dial.Unlock()
+	select {
+	case <-session.done:
+		{
+			return net.ErrClosed
+		}
+	default:
+	}
+	if _, current := session.Out(); current != generation {
+		return nil
+	}
+	out, err := dial()
+	if err != nil {
+		return err
+	}
+	return session.SetOut(out)
+}
+
+func (session *WebSocketSession) SetOut(out *websocket.Conn) error {
+	session.mut.Lock()
+	defer session.mut.Unlock()
+	select {
+	case <-session.done:
+		{
+			out.Close()
+			return net.ErrClosed
+		}
+	default:
+	}
+	session.out.Close()
+	session.out = out
+	session.generation++
+	return nil
+}
+
+func (session *WebSocketSession) Done() <-chan struct{} {
+	return session.done
+}
+
+func (session *WebSocketSession) Close(code int, text string) {
+	session.once.Do(func() {
+		session.mut.Lock()
+		defer session.mut.Unlock()
+		close(session.done)
+		deadline := time.Now().Add(CLOSE_TIMEOUT)
+		message := websocket.FormatCloseMessage(code, text)
+		session.in.WriteControl(websocket.CloseMessage, message, deadline)
+		session.out.WriteControl(websocket.CloseMessage, message, deadline)
+		session.in.Close()
+		session.out.Close()
+	})
+}
@@ -0,0 +1,175 @@
+package proxies
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func echo(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		kind, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(kind, message); err != nil {
+			return
+		}
+	}
+}
+
+func TestWebSocketClose(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(echo))
+	defer backend.Close()
+	address, err := url.Parse(strings.Replace(backend.URL, "http", "ws", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := NewProxy(&Proxy{Name: "test", Address: address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frontend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.Handle(w, r, nil)
+	}))
+	defer frontend.Close()
+	url := strings.Replace(frontend.URL, "http", "ws", 1)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, message, err := conn.ReadMessage(); err != nil || string(message) != "ping" {
+		t.Fatalf("expected the backend to echo ping, got %s %v", message, err)
+	}
+
+	if err := handler.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("expected a going away close, got %v", err)
+	}
+
+	late, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer late.Close()
+	late.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, _, err = late.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("expected sessions opened after close to be closed, got %v", err)
+	}
+}
+
+func TestWebSocketReconnect(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(echo))
+	defer backend.Close()
+	url := strings.Replace(backend.URL, "http", "ws", 1)
+	var dials atomic.Int64
+	dial := func() (*websocket.Conn, error) {
+		dials.Add(1)
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		return conn, err
+	}
+	in, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := NewWebSocketSession(in, out)
+	dials.Store(0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := session.Reconnect(0, dial); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, generation := session.Out(); dials.Load() != 1 || generation != 1 {
+		t.Fatalf("expected a single redial, got %d dials and generation %d", dials.Load(), generation)
+	}
+	if err := session.Reconnect(0, dial); err != nil || dials.Load() != 1 {
+		t.Fatalf("expected a replaced connection not to be dialed again, got %d dials and %v", dials.Load(), err)
+	}
+
+	session.Close(websocket.CloseNormalClosure, "")
+	if err := session.Reconnect(1, dial); err == nil || dials.Load() != 1 {
+		t.Fatalf("expected a closed session not to reconnect, got %d dials and %v", dials.Load(), err)
+	}
+}
+
+func TestWebSocketReleased(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(echo))
+	defer backend.Close()
+	address, err := url.Parse(strings.Replace(backend.URL, "http", "ws", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	released := NewReleased()
+	handler, err := NewProxy(&Proxy{Name: "released", Address: address, Released: released})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frontend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.Handle(w, r, nil)
+	}))
+	defer frontend.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(strings.Replace(frontend.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	ping := func() {
+		t.Helper()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		if _, message, err := conn.ReadMessage(); err != nil || string(message) != "ping" {
+			t.Fatalf("expected the backend to echo ping, got %s %v", message, err)
+		}
+	}
+	ping()
+	if err := handler.Release(); err != nil {
+		t.Fatal(err)
+	}
+	ping()
+	if err := released.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("expected a going away close, got %v", err)
+	}
+	if len(released.proxies) != 0 {
+		t.Fatalf("expected the closed proxy to leave the released set")
+	}
+}
@@ -0,0 +1,275 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/vedadiyan/iceberg/internal/common/metrics"
+	"github.com/vedadiyan/iceberg/internal/common/netio"
+)
+
+type (
+	State int
+	// Outcome is what a call allowed by a breaker counts as.
+	Outcome int
+	// Breaker stops calls to a failing backend or filter. It opens after
+	// ConsecutiveFailures failed calls in a row, or once ErrorRate of at
+	// least MinRequests calls in the last Window failed. After OpenDuration
+	// it lets HalfOpenRequests probe calls through, closing again when they
+	// all succeed and reopening on the first failure.
+	Breaker struct {
+		Name                string
+		ConsecutiveFailures int
+		ErrorRate           float64
+		MinRequests         int
+		Window              time.Duration
+		OpenDuration        time.Duration
+		HalfOpenRequests    int
+
+		mut         sync.Mutex
+		state       State
+		generation  int
+		consecutive int
+		openUntil   time.Time
+		probes      int
+		successes   int
+		buckets     []bucket
+	}
+	bucket struct {
+		second   int64
+		calls    int
+		failures int
+	}
+)
+
+const (
+	STATE_CLOSED State = iota
+	STATE_OPEN
+	STATE_HALF_OPEN
+
+	CONSECUTIVE_FAILURES = 5
+	MIN_REQUESTS         = 20
+	WINDOW               = time.Second * 10
+	OPEN_DURATION        = time.Second * 30
+	HALF_OPEN_REQUESTS   = 1
+)
+
+const (
+	OUTCOME_SUCCESS Outcome = iota
+	OUTCOME_FAILURE
+	OUTCOME_IGNORED
+)
+
+var (
+	ErrOpen = errors.New("circuit breaker is open")
+)
+
+func NewBreaker(breaker *Breaker) *Breaker {
+	if breaker.ConsecutiveFailures <= 0 {
+		breaker.ConsecutiveFailures = CONSECUTIVE_FAILURES
+	}
+	if breaker.MinRequests <= 0 {
+		breaker.MinRequests = MIN_REQUESTS
+	}
+	if breaker.Window < time.Second {
+		breaker.Window = WINDOW
+	}
+	if breaker.OpenDuration <= 0 {
+		breaker.OpenDuration = OPEN_DURATION
+	}
+	if breaker.HalfOpenRequests <= 0 {
+		breaker.HalfOpenRequests = HALF_OPEN_REQUESTS
+	}
+	breaker.buckets = make([]bucket, int(breaker.Window/time.Second))
+	metrics.CircuitBreakerState.WithLabelValues(breaker.Name).Set(float64(STATE_CLOSED))
+	return breaker
+}
+
+// Allow reports whether a call may go through and returns the function that
+// records its outcome. A nil breaker allows every call.
+func (breaker *Breaker) Allow() (func(Outcome), bool) {
+	if breaker == nil {
+		return func(Outcome) {}, true
+	}
+	breaker.mut.Lock()
+	defer breaker.mut.Unlock()
+	if breaker.state == STATE_OPEN {
+		if time.Now().Before(breaker.openUntil) {
+			metrics.CircuitBreakerRejections.WithLabelValues(breaker.Name).Inc()
+			return nil, false
+		}
+		breaker.transition(STATE_HALF_OPEN)
+	}
+	if breaker.state == STATE_HALF_OPEN {
+		if breaker.probes >= breaker.HalfOpenRequests {
+			metrics.CircuitBreakerRejections.WithLabelValues(breaker.Name).Inc()
+			return nil, false
+		}
+		breaker.probes++
+	}
+	generation := breaker.generation
+	var once sync.Once
+	return func(outcome Outcome) {
+		once.Do(func() {
+			breaker.done(generation, outcome)
+		})
+	}, true
+}
+
+func (breaker *Breaker) State() State {
+	if breaker == nil {
+		return STATE_CLOSED
+	}
+	breaker.mut.Lock()
+	defer breaker.mut.Unlock()
+	if breaker.state == STATE_OPEN && !time.Now().Before(breaker.openUntil) {
+		return STATE_HALF_OPEN
+	}
+	return breaker.state
+}
+
+// done records the outcome of a call allowed in generation. Outcomes of calls
+// allowed before the last state change are ignored. An ignored outcome only
+// gives back the probe slot of a half open breaker.
+func (breaker *Breaker) done(generation int, outcome Outcome) {
+	breaker.mut.Lock()
+	defer breaker.mut.Unlock()
+	if generation != breaker.generation {
+		return
+	}
+	if outcome == OUTCOME_IGNORED {
+		if breaker.state == STATE_HALF_OPEN {
+			breaker.probes--
+		}
+		return
+	}
+	failed := outcome == OUTCOME_FAILURE
+	switch breaker.state {
+	case STATE_HALF_OPEN:
+		{
+			if failed {
+				breaker.transition(STATE_OPEN)
+				return
+			}
+			breaker.successes++
+			if breaker.successes >= breaker.HalfOpenRequests {
+				breaker.transition(STATE_CLOSED)
+			}
+		}
+	case STATE_CLOSED:
+		{
+			bucket := breaker.bucket(time.Now().Unix())
+			bucket.calls++
+			if !failed {
+				breaker.consecutive = 0
+				return
+			}
+			bucket.failures++
+			breaker.consecutive++
+			if breaker.consecutive >= breaker.ConsecutiveFailures || breaker.tripsOnRate() {
+				breaker.transition(STATE_OPEN)
+			}
+		}
+	}
+}
+
+func (breaker *Breaker) tripsOnRate() bool {
+	if breaker.ErrorRate <= 0 {
+		return false
+	}
+	now := time.Now().Unix()
+	calls, failures := 0, 0
+	for _, bucket := range breaker.buckets {
+		if bucket.second > now-int64(len(breaker.buckets)) {
+			calls += bucket.calls
+			failures += bucket.failures
+		}
+	}
+	return calls >= breaker.MinRequests && float64(failures) >= breaker.ErrorRate*float64(calls)
+}
+
+func (breaker *Breaker) transition(state State) {
+	breaker.state = state
+	breaker.generation++
+	breaker.consecutive = 0
+	breaker.probes = 0
+	breaker.successes = 0
+	switch state {
+	case STATE_OPEN:
+		{
+			breaker.openUntil = time.Now().Add(breaker.OpenDuration)
+		}
+	case STATE_CLOSED:
+		{
+			for i := range breaker.buckets {
+				breaker.buckets[i] = bucket{}
+			}
+		}
+	}
+	metrics.CircuitBreakerState.WithLabelValues(breaker.Name).Set(float64(state))
+}
+
+func (breaker *Breaker) bucket(second int64) *bucket {
+	bucket := &breaker.buckets[second%int64(len(breaker.buckets))]
+	if bucket.second != second {
+		bucket.second = second
+		bucket.calls = 0
+		bucket.failures = 0
+	}
+	return bucket
+}
+
+func (state State) String() string {
+	switch state {
+	case STATE_OPEN:
+		{
+			return "open"
+		}
+	case STATE_HALF_OPEN:
+		{
+			return "halfOpen"
+		}
+	}
+	return "closed"
+}
+
+// Classify tells what the outcome of a call counts as: transport failures,
+// timeouts and 5xx statuses are failures, and calls the client gave up on are
+// ignored.
+func Classify(ctx context.Context, res *http.Response, err netio.Error) Outcome {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return OUTCOME_IGNORED
+	}
+	if err == nil {
+		return Failure(res != nil && res.StatusCode >= 500)
+	}
+	switch err.Kind() {
+	case netio.ERROR_KIND_TRANSPORT:
+		{
+			if errors.Is(netio.Cause(err), context.Canceled) {
+				return OUTCOME_IGNORED
+			}
+			return OUTCOME_FAILURE
+		}
+	case netio.ERROR_KIND_TIMEOUT:
+		{
+			return OUTCOME_FAILURE
+		}
+	case netio.ERROR_KIND_STATUS:
+		{
+			return Failure(err.Status() >= 500)
+		}
+	}
+	return OUTCOME_SUCCESS
+}
+
+// Failure counts a call as failed or succeeded.
+func Failure(failed bool) Outcome {
+	if failed {
+		return OUTCOME_FAILURE
+	}
+	return OUTCOME_SUCCESS
+}
@@ -0,0 +1,230 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/vedadiyan/iceberg/internal/common/netio"
+)
+
+type (
+	step struct {
+		action string
+		want   State
+	}
+)
+
+const (
+	OPEN_FOR = time.Millisecond * 20
+)
+
+var (
+	outcomes = map[string]Outcome{
+		"success": OUTCOME_SUCCESS,
+		"failure": OUTCOME_FAILURE,
+		"ignored": OUTCOME_IGNORED,
+	}
+)
+
+func TestBreaker(t *testing.T) {
+	tests := []struct {
+		name    string
+		breaker *Breaker
+		steps   []step
+	}{
+		{
+			name:    "consecutive failures",
+			breaker: &Breaker{ConsecutiveFailures: 3},
+			steps: []step{
+				{"failure", STATE_CLOSED},
+				{"failure", STATE_CLOSED},
+				{"failure", STATE_OPEN},
+				{"rejected", STATE_OPEN},
+			},
+		},
+		{
+			name:    "success resets consecutive failures",
+			breaker: &Breaker{ConsecutiveFailures: 3},
+			steps: []step{
+				{"failure", STATE_CLOSED},
+				{"failure", STATE_CLOSED},
+				{"success", STATE_CLOSED},
+				{"failure", STATE_CLOSED},
+				{"failure", STATE_CLOSED},
+			},
+		},
+		{
+			name:    "error rate",
+			breaker: &Breaker{ConsecutiveFailures: 100, ErrorRate: 0.5, MinRequests: 4},
+			steps: []step{
+				{"success", STATE_CLOSED},
+				{"failure", STATE_CLOSED},
+				{"success", STATE_CLOSED},
+				{"failure", STATE_OPEN},
+			},
+		},
+		{
+			name:    "error rate below threshold",
+			breaker: &Breaker{ConsecutiveFailures: 100, ErrorRate: 0.5, MinRequests: 4},
+			steps: []step{
+				{"success", STATE_CLOSED},
+				{"success", STATE_CLOSED},
+				{"success", STATE_CLOSED},
+				{"failure", STATE_CLOSED},
+			},
+		},
+		{
+			name:    "half open closes",
+			breaker: &Breaker{ConsecutiveFailures: 1},
+			steps: []step{
+				{"failure", STATE_OPEN},
+				{"rejected", STATE_OPEN},
+				{"wait", STATE_HALF_OPEN},
+				{"success", STATE_CLOSED},
+				{"success", STATE_CLOSED},
+			},
+		},
+		{
+			name:    "half open reopens",
+			breaker: &Breaker{ConsecutiveFailures: 1},
+			steps: []step{
+				{"failure", STATE_OPEN},
+				{"wait", STATE_HALF_OPEN},
+				{"failure", STATE_OPEN},
+				{"rejected", STATE_OPEN},
+			},
+		},
+		{
+			name:    "half open limits probes",
+			breaker: &Breaker{ConsecutiveFailures: 1, HalfOpenRequests: 2},
+			steps: []step{
+				{"failure", STATE_OPEN},
+				{"wait", STATE_HALF_OPEN},
+				{"hold", STATE_HALF_OPEN},
+				{"success", STATE_HALF_OPEN},
+				{"rejected", STATE_HALF_OPEN},
+				{"succeed held", STATE_CLOSED},
+			},
+		},
+		{
+			name:    "ignored outcomes keep consecutive failures",
+			breaker: &Breaker{ConsecutiveFailures: 2},
+			steps: []step{
+				{"failure", STATE_CLOSED},
+				{"ignored", STATE_CLOSED},
+				{"failure", STATE_OPEN},
+			},
+		},
+		{
+			name:    "half open gives back ignored probes",
+			breaker: &Breaker{ConsecutiveFailures: 1},
+			steps: []step{
+				{"failure", STATE_OPEN},
+				{"wait", STATE_HALF_OPEN},
+				{"ignored", STATE_HALF_OPEN},
+				{"ignored", STATE_HALF_OPEN},
+				{"success", STATE_CLOSED},
+			},
+		},
+		{
+			name:    "stale outcomes are ignored",
+			breaker: &Breaker{ConsecutiveFailures: 2},
+			steps: []step{
+				{"hold", STATE_CLOSED},
+				{"failure", STATE_CLOSED},
+				{"failure", STATE_OPEN},
+				{"wait", STATE_HALF_OPEN},
+				{"success", STATE_CLOSED},
+				{"fail held", STATE_CLOSED},
+				{"failure", STATE_CLOSED},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.breaker.Name = "test"
+			test.breaker.OpenDuration = OPEN_FOR
+			breaker := NewBreaker(test.breaker)
+			held := make([]func(Outcome), 0)
+			for index, step := range test.steps {
+				switch step.action {
+				case "success", "failure", "ignored", "hold":
+					{
+						done, ok := breaker.Allow()
+						if !ok {
+							t.Fatalf("step %d: expected %s to be allowed", index, step.action)
+						}
+						if step.action == "hold" {
+							held = append(held, done)
+							break
+						}
+						done(outcomes[step.action])
+					}
+				case "rejected":
+					{
+						if _, ok := breaker.Allow(); ok {
+							t.Fatalf("step %d: expected the call to be rejected", index)
+						}
+					}
+				case "wait":
+					{
+						time.Sleep(OPEN_FOR * 2)
+					}
+				case "succeed held", "fail held":
+					{
+						held[0](Failure(step.action == "fail held"))
+						held = held[1:]
+					}
+				}
+				if state := breaker.State(); state != step.want {
+					t.Fatalf("step %d: expected %s after %s, got %s", index, step.want, step.action, state)
+				}
+			}
+		})
+	}
+}
+
+func TestNilBreaker(t *testing.T) {
+	var breaker *Breaker
+	done, ok := breaker.Allow()
+	if !ok {
+		t.Fatalf("expected a nil breaker to allow calls")
+	}
+	done(OUTCOME_FAILURE)
+	if state := breaker.State(); state != STATE_CLOSED {
+		t.Fatalf("expected %s, got %s", STATE_CLOSED, state)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		res  *http.Response
+		err  netio.Error
+		want Outcome
+	}{
+		{name: "success", ctx: context.Background(), res: &http.Response{StatusCode: 200}},
+		{name: "client error", ctx: context.Background(), res: &http.Response{StatusCode: 404}},
+		{name: "server error", ctx: context.Background(), res: &http.Response{StatusCode: 503}, want: OUTCOME_FAILURE},
+		{name: "transport", ctx: context.Background(), err: netio.NewTransportError(errors.New("connection reset by peer")), want: OUTCOME_FAILURE},
+		{name: "transport canceled", ctx: context.Background(), err: netio.NewTransportError(context.Canceled), want: OUTCOME_IGNORED},
+		{name: "timeout", ctx: context.Background(), err: netio.NewTimeoutError(context.DeadlineExceeded.Error()), want: OUTCOME_FAILURE},
+		{name: "status error", ctx: context.Background(), err: netio.NewStatusError("502 Bad Gateway", 502), want: OUTCOME_FAILURE},
+		{name: "client status error", ctx: context.Background(), err: netio.NewStatusError("403 Forbidden", 403)},
+		{name: "internal", ctx: context.Background(), err: netio.NewError("boom", 500)},
+		{name: "client gave up", ctx: canceled, err: netio.NewTimeoutError(context.DeadlineExceeded.Error()), want: OUTCOME_IGNORED},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if outcome := Classify(test.ctx, test.res, test.err); outcome != test.want {
+				t.Fatalf("expected %v, got %v", test.want, outcome)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	NAMESPACE = "iceberg"
+)
+
+var (
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "requests_total",
+		Help:      "Requests served, by resource, method and status.",
+	}, []string{"resource", "method", "status"})
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "request_duration_seconds",
+		Help:      "End to end request latency, including filters, by resource, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"resource", "method", "status"})
+	BackendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "backend_duration_seconds",
+		Help:      "Backend call latency, by resource and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"resource", "status"})
+	FilterDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "filter_duration_seconds",
+		Help:      "Filter call latency, by filter, transport and level.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"filter", "transport", "level"})
+	FilterErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "filter_errors_total",
+		Help:      "Failed filter calls, by filter, transport and kind (timeout, transport, status, internal).",
+	}, []string{"filter", "transport", "kind"})
+	CacheOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "cache_operations_total",
+		Help:      "Cache lookups and stores, by result (hit, miss, store, error).",
+	}, []string{"result"})
+	OpaDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "opa_decisions_total",
+		Help:      "OPA decisions, by type (http, send, receive) and decision (allow, deny, error).",
+	}, []string{"type", "decision"})
+	WebSocketSessions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "websocket_sessions",
+		Help:      "Active proxied WebSocket sessions, by resource.",
+	}, []string{"resource"})
+	Retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "retries_total",
+		Help:      "Retried backend and filter calls, by caller and reason.",
+	}, []string{"caller", "reason"})
+	RetryBudgetExhausted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "retry_budget_exhausted_total",
+		Help:      "Retries denied by the retry budget, by caller.",
+	}, []string{"caller"})
+	CircuitBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "circuit_breaker_state",
+		Help:      "Circuit breaker state (0 closed, 1 open, 2 half-open), by caller.",
+	}, []string{"caller"})
+	CircuitBreakerRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "circuit_breaker_rejections_total",
+		Help:      "Calls rejected by an open circuit breaker, by caller.",
+	}, []string{"caller"})
+	BackendHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "backend_endpoint_healthy",
+		Help:      "Whether a health-checked backend endpoint is healthy (1) or not (0), by resource and endpoint.",
+	}, []string{"resource", "endpoint"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		Requests,
+		RequestDuration,
+		BackendDuration,
+		FilterDuration,
+		FilterErrors,
+		CacheOperations,
+		OpaDecisions,
+		WebSocketSessions,
+		BackendHealthy,
+		Retries,
+		RetryBudgetExhausted,
+		CircuitBreakerState,
+		CircuitBreakerRejections,
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+func Status(status int) string {
+	return strconv.Itoa(status)
+}
+
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
@@ -0,0 +1,285 @@
+package netio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+type (
+	// Body holds a buffered or streamed request or response body.
+	Body struct {
+		data   []byte
+		file   *os.File
+		size   int64
+		stream io.ReadCloser
+	}
+	// Spool owns the temporary files and streams of a single request.
+	Spool struct {
+		Memory    int64
+		MaxSize   int64
+		Streaming bool
+		mut       sync.Mutex
+		refs      int
+		closers   []io.Closer
+	}
+	// BodyOptions configures how a resource buffers bodies.
+	BodyOptions struct {
+		Stream  bool
+		Memory  int64
+		MaxSize int64
+	}
+	// BodyStreamer is implemented by callers that forward the request body
+	// unbuffered.
+	BodyStreamer interface {
+		StreamsBody() bool
+	}
+	// BodyReader is implemented by callers that may not read bodies.
+	BodyReader interface {
+		ReadsBody() bool
+	}
+	bodyReader struct {
+		io.Reader
+		body *Body
+	}
+	limitReader struct {
+		io.ReadCloser
+		remaining int64
+	}
+)
+
+const (
+	BODY_MEMORY = 4 << 20
+)
+
+var (
+	ErrBodyTooLarge = errors.New("request body too large")
+	_emptyBody      = &Body{}
+)
+
+func NewSpool(memory int64, maxSize int64, stream bool) *Spool {
+	if memory <= 0 {
+		memory = BODY_MEMORY
+	}
+	return &Spool{
+		Memory:    memory,
+		MaxSize:   maxSize,
+		Streaming: stream,
+		refs:      1,
+	}
+}
+
+// NewSpool creates the spool of a request handled by callers.
+func (options *BodyOptions) NewSpool(callers ...Caller) *Spool {
+	if options == nil {
+		return NewSpool(0, 0, false)
+	}
+	return NewSpool(options.Memory, options.MaxSize, options.Stream && !ReadsBody(callers...))
+}
+
+// TooLarge reports whether contentLength exceeds MaxSize.
+func (spool *Spool) TooLarge(contentLength int64) bool {
+	return spool != nil && spool.MaxSize > 0 && contentLength > spool.MaxSize
+}
+
+// Hold keeps the spool open until the returned function is called.
+func (spool *Spool) Hold() func() {
+	if spool == nil {
+		return func() {}
+	}
+	spool.mut.Lock()
+	spool.refs++
+	spool.mut.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			spool.Close()
+		})
+	}
+}
+
+func (spool *Spool) Close() error {
+	if spool == nil {
+		return nil
+	}
+	spool.mut.Lock()
+	spool.refs--
+	if spool.refs > 0 {
+		spool.mut.Unlock()
+		return nil
+	}
+	closers := spool.closers
+	spool.closers = nil
+	spool.mut.Unlock()
+	errs := make([]error, 0)
+	for _, closer := range closers {
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (spool *Spool) track(closer io.Closer) {
+	spool.mut.Lock()
+	defer spool.mut.Unlock()
+	spool.closers = append(spool.closers, closer)
+}
+
+// Limit fails reads past MaxSize with ErrBodyTooLarge.
+func (spool *Spool) Limit(body io.ReadCloser) io.ReadCloser {
+	if spool == nil || spool.MaxSize <= 0 || body == nil || body == http.NoBody {
+		return body
+	}
+	if _, ok := body.(*bodyReader); ok {
+		return body
+	}
+	return &limitReader{ReadCloser: body, remaining: spool.MaxSize}
+}
+
+// Read buffers a request body, failing with ErrBodyTooLarge past MaxSize.
+func (spool *Spool) Read(r io.Reader) (*Body, error) {
+	if spool == nil {
+		return spool.read(r, 0)
+	}
+	return spool.read(r, spool.MaxSize)
+}
+
+func (spool *Spool) buffer(r io.Reader) (*Body, error) {
+	return spool.read(r, 0)
+}
+
+func (spool *Spool) read(r io.Reader, maxSize int64) (*Body, error) {
+	if r == nil || r == http.NoBody {
+		return _emptyBody, nil
+	}
+	if reader, ok := r.(*bodyReader); ok {
+		return reader.body, nil
+	}
+	if spool == nil {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return NewBody(data), nil
+	}
+	if maxSize > 0 {
+		r = io.LimitReader(r, maxSize+1)
+	}
+	buffer := new(bytes.Buffer)
+	_, err := io.CopyN(buffer, r, spool.Memory+1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if maxSize > 0 && int64(buffer.Len()) > maxSize {
+		return nil, ErrBodyTooLarge
+	}
+	if err == io.EOF {
+		return NewBody(buffer.Bytes()), nil
+	}
+	file, err := os.CreateTemp("", "iceberg-body-*")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(file.Name())
+	spool.track(file)
+	size, err := io.Copy(file, io.MultiReader(buffer, r))
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && size > maxSize {
+		return nil, ErrBodyTooLarge
+	}
+	return &Body{file: file, size: size}, nil
+}
+
+// Stream wraps body without reading it.
+func (spool *Spool) Stream(body io.ReadCloser) *Body {
+	if body == nil || body == http.NoBody {
+		return _emptyBody
+	}
+	if reader, ok := body.(*bodyReader); ok {
+		return reader.body
+	}
+	if spool != nil {
+		spool.track(body)
+	}
+	return &Body{stream: body, size: -1}
+}
+
+func NewBody(data []byte) *Body {
+	return &Body{data: data, size: int64(len(data))}
+}
+
+func (body *Body) Reader() io.ReadCloser {
+	switch {
+	case body.stream != nil:
+		{
+			return &bodyReader{Reader: body.stream, body: body}
+		}
+	case body.file != nil:
+		{
+			return &bodyReader{Reader: io.NewSectionReader(body.file, 0, body.size), body: body}
+		}
+	}
+	return &bodyReader{Reader: bytes.NewReader(body.data), body: body}
+}
+
+// Len returns the size of a buffered body, or -1 for a streamed one.
+func (body *Body) Len() int64 {
+	return body.size
+}
+
+func (body *Body) IsStream() bool {
+	return body.stream != nil
+}
+
+// Replayable reports whether the body can be sent again.
+func Replayable(r *http.Request) bool {
+	reader, ok := r.Body.(*bodyReader)
+	return !ok || !reader.body.IsStream()
+}
+
+func (reader *bodyReader) Close() error {
+	return nil
+}
+
+func (reader *limitReader) Read(p []byte) (int, error) {
+	if reader.remaining < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > reader.remaining+1 {
+		p = p[:reader.remaining+1]
+	}
+	n, err := reader.ReadCloser.Read(p)
+	reader.remaining -= int64(n)
+	if reader.remaining < 0 {
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// StreamsBody reports whether any of the callers streams the request body.
+func StreamsBody(callers ...Caller) bool {
+	for _, caller := range callers {
+		if streamer, ok := caller.(BodyStreamer); ok && streamer.StreamsBody() {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadsBody reports whether any of the callers needs bodies buffered.
+func ReadsBody(callers ...Caller) bool {
+	for _, caller := range callers {
+		if streamer, ok := caller.(BodyStreamer); ok && streamer.StreamsBody() {
+			continue
+		}
+		reader, ok := caller.(BodyReader)
+		if !ok || reader.ReadsBody() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package netio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSpooledRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		memory    int64
+		maxSize   int64
+		size      int
+		wantFile  bool
+		wantErr   error
+		withSpool bool
+	}{
+		{name: "no spool", size: 64},
+		{name: "empty", withSpool: true, memory: 16},
+		{name: "in memory", withSpool: true, memory: 16, size: 16},
+		{name: "spilled", withSpool: true, memory: 16, size: 17, wantFile: true},
+		{name: "at limit", withSpool: true, memory: 16, maxSize: 32, size: 32, wantFile: true},
+		{name: "over limit in memory", withSpool: true, memory: 64, maxSize: 32, size: 33, wantErr: ErrBodyTooLarge},
+		{name: "over limit spilled", withSpool: true, memory: 16, maxSize: 32, size: 33, wantErr: ErrBodyTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var spool *Spool
+			if test.withSpool {
+				spool = NewSpool(test.memory, test.maxSize, false)
+				defer spool.Close()
+			}
+			data := bytes.Repeat([]byte("x"), test.size)
+			in, err := NewSpooledRequest(httptest.NewRequest("POST", "/", bytes.NewReader(data)), spool)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if test.wantErr != nil {
+				return
+			}
+			if spilled := in.body.file != nil; spilled != test.wantFile {
+				t.Fatalf("expected spilled %v, got %v", test.wantFile, spilled)
+			}
+			for i := 0; i < 2; i++ {
+				r, err := in.CloneRequest()
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(body, data) || r.ContentLength != int64(test.size) {
+					t.Fatalf("read %d: expected %d bytes, got %d with content length %d", i, test.size, len(body), r.ContentLength)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamedRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxSize int64
+		size    int
+		wantErr error
+	}{
+		{name: "unlimited", size: 64},
+		{name: "at limit", maxSize: 32, size: 32},
+		{name: "over limit", maxSize: 32, size: 33, wantErr: ErrBodyTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spool := NewSpool(0, test.maxSize, true)
+			defer spool.Close()
+			data := bytes.Repeat([]byte("x"), test.size)
+			in, err := NewSpooledRequest(httptest.NewRequest("POST", "/", bytes.NewReader(data)), spool)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cloned, err := in.CloneRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body, _ := io.ReadAll(cloned.Body); len(body) != 0 {
+				t.Fatalf("expected a clone to leave a streamed body out, got %d bytes", len(body))
+			}
+			streamed, err := in.StreamRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if Replayable(streamed) {
+				t.Fatalf("expected a streamed request not to be replayable")
+			}
+			body, err := io.ReadAll(streamed.Body)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if test.wantErr == nil && !bytes.Equal(body, data) {
+				t.Fatalf("expected %d bytes, got %d", len(data), len(body))
+			}
+		})
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	tests := []struct {
+		name          string
+		spool         *Spool
+		contentLength int64
+		want          bool
+	}{
+		{name: "no spool", contentLength: 1 << 30},
+		{name: "no limit", spool: NewSpool(0, 0, false), contentLength: 1 << 30},
+		{name: "unknown length", spool: NewSpool(0, 32, false), contentLength: -1},
+		{name: "at limit", spool: NewSpool(0, 32, false), contentLength: 32},
+		{name: "over limit", spool: NewSpool(0, 32, false), contentLength: 33, want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if tooLarge := test.spool.TooLarge(test.contentLength); tooLarge != test.want {
+				t.Fatalf("expected %v, got %v", test.want, tooLarge)
+			}
+		})
+	}
+}
+
+func TestSpoolHold(t *testing.T) {
+	spool := NewSpool(1, 0, false)
+	body, err := spool.Read(strings.NewReader("spilled"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := spool.Hold()
+	spool.Close()
+	if _, err := body.file.Stat(); err != nil {
+		t.Fatalf("expected the file to stay open while held, got %v", err)
+	}
+	release()
+	release()
+	if _, err := body.file.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected the file to be closed after the last release, got %v", err)
+	}
+}
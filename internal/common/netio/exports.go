@@ -2,23 +2,30 @@ package netio
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type (
-	Next     bool
-	Level    int
-	Cloner   func(options ...RequestOption) (*http.Request, error)
-	Response struct {
+	Next      bool
+	Level     int
+	ErrorKind int
+	Cloner    func(options ...RequestOption) (*http.Request, error)
+	Response  struct {
 		*http.Response
 		Error
 	}
 	Error interface {
 		Message() string
 		Status() int
+		Kind() ErrorKind
 	}
 	Caller interface {
 		GetLevel() Level
@@ -30,11 +37,45 @@ type (
 		GetResponseUpdaters() []ResponseUpdater
 		OverrideRequestUpdaters([]RequestUpdater)
 		OverrideResponseUpdaters([]ResponseUpdater)
-		GetContext() context.Context
+		GetContext(context.Context) (context.Context, context.CancelFunc)
 	}
 	httpError struct {
 		message string
 		status  int
+		kind    ErrorKind
+		cause   error
+	}
+	Addresser interface {
+		GetAddress() *url.URL
+	}
+	CallerInfo struct {
+		Name     string   `json:"name,omitempty"`
+		Type     string   `json:"type"`
+		Level    string   `json:"level"`
+		Address  string   `json:"address,omitempty"`
+		Parallel bool     `json:"parallel,omitempty"`
+		Await    []string `json:"await,omitempty"`
+		Breaker  string   `json:"circuitBreaker,omitempty"`
+	}
+	// Guarded is implemented by callers behind a circuit breaker.
+	Guarded interface {
+		BreakerState() string
+	}
+	Drainer interface {
+		Drain() error
+		IsClosed() bool
+	}
+	task struct {
+		caller    Caller
+		ctx       context.Context
+		parent    context.Context
+		ch        <-chan *Response
+		res       *Response
+		exchanged bool
+	}
+	cancelReader struct {
+		io.ReadCloser
+		cancel context.CancelFunc
 	}
 )
 
@@ -48,15 +89,74 @@ const (
 	LEVEL_REQUEST  Level = 8
 	LEVEL_RESPONSE Level = 16
 	LEVEL_POST     Level = 32
+
+	ERROR_KIND_INTERNAL  ErrorKind = 1
+	ERROR_KIND_TRANSPORT ErrorKind = 2
+	ERROR_KIND_TIMEOUT   ErrorKind = 3
+	ERROR_KIND_STATUS    ErrorKind = 4
+	ERROR_KIND_OPEN      ErrorKind = 5
+
+	DRAIN_POLL_INTERVAL = time.Millisecond * 50
+)
+
+var (
+	_background sync.WaitGroup
 )
 
 func NewError(message string, status int) Error {
 	return &httpError{
 		message: message,
 		status:  status,
+		kind:    ERROR_KIND_INTERNAL,
+	}
+}
+
+func NewStatusError(message string, status int) Error {
+	return &httpError{
+		message: message,
+		status:  status,
+		kind:    ERROR_KIND_STATUS,
+	}
+}
+
+func NewTimeoutError(message string) Error {
+	return &httpError{
+		message: message,
+		status:  http.StatusGatewayTimeout,
+		kind:    ERROR_KIND_TIMEOUT,
+	}
+}
+
+// NewOpenError is returned instead of calling a backend or filter whose
+// circuit breaker is open.
+func NewOpenError(message string) Error {
+	return &httpError{
+		message: message,
+		status:  http.StatusServiceUnavailable,
+		kind:    ERROR_KIND_OPEN,
+	}
+}
+
+func NewTransportError(err error) Error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return NewTimeoutError(err.Error())
+	}
+	return &httpError{
+		message: err.Error(),
+		status:  http.StatusBadGateway,
+		kind:    ERROR_KIND_TRANSPORT,
+		cause:   err,
 	}
 }
 
+// Cause returns the error behind a transport error, or nil.
+func Cause(err Error) error {
+	if httpError, ok := err.(*httpError); ok {
+		return httpError.cause
+	}
+	return nil
+}
+
 func Sort(callers ...Caller) []Caller {
 	main := make([]Caller, 0)
 	connect := make([]Caller, 0)
@@ -96,6 +196,154 @@ func Sort(callers ...Caller) []Caller {
 	return final
 }
 
+func (level Level) String() string {
+	switch level {
+	case LEVEL_NONE:
+		{
+			return "main"
+		}
+	case LEVEL_CONNECT:
+		{
+			return "connect"
+		}
+	case LEVEL_PRE:
+		{
+			return "pre"
+		}
+	case LEVEL_REQUEST:
+		{
+			return "request"
+		}
+	case LEVEL_RESPONSE:
+		{
+			return "response"
+		}
+	case LEVEL_POST:
+		{
+			return "post"
+		}
+	}
+	return fmt.Sprintf("level(%d)", int(level))
+}
+
+func (kind ErrorKind) String() string {
+	switch kind {
+	case ERROR_KIND_INTERNAL:
+		{
+			return "internal"
+		}
+	case ERROR_KIND_TRANSPORT:
+		{
+			return "transport"
+		}
+	case ERROR_KIND_TIMEOUT:
+		{
+			return "timeout"
+		}
+	case ERROR_KIND_STATUS:
+		{
+			return "status"
+		}
+	case ERROR_KIND_OPEN:
+		{
+			return "open"
+		}
+	}
+	return fmt.Sprintf("kind(%d)", int(kind))
+}
+
+func Describe(callers ...Caller) []CallerInfo {
+	out := make([]CallerInfo, 0)
+	for _, caller := range callers {
+		info := CallerInfo{
+			Name:     caller.GetName(),
+			Type:     fmt.Sprintf("%T", caller),
+			Level:    caller.GetLevel().String(),
+			Parallel: caller.GetIsParallel(),
+			Await:    caller.GetAwaitList(),
+		}
+		if addresser, ok := caller.(Addresser); ok && addresser.GetAddress() != nil {
+			info.Address = addresser.GetAddress().String()
+		}
+		if guarded, ok := caller.(Guarded); ok {
+			info.Breaker = guarded.BreakerState()
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+func Close(callers ...Caller) error {
+	errs := make([]error, 0)
+	for _, caller := range callers {
+		closer, ok := caller.(io.Closer)
+		if !ok {
+			continue
+		}
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func WithTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, timeout)
+}
+
+// CancelOnClose ties cancel to the body of res, which is read after the call
+// that got it returns. Without a response, cancel is called at once.
+func CancelOnClose(res *http.Response, cancel context.CancelFunc) *http.Response {
+	if res == nil || res.Body == nil {
+		cancel()
+		return res
+	}
+	res.Body = &cancelReader{ReadCloser: res.Body, cancel: cancel}
+	return res
+}
+
+func (reader *cancelReader) Close() error {
+	defer reader.cancel()
+	return reader.ReadCloser.Close()
+}
+
+func Go(fn func()) {
+	_background.Add(1)
+	go func() {
+		defer _background.Done()
+		fn()
+	}()
+}
+
+func Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_background.Wait()
+	}()
+	select {
+	case <-done:
+		{
+			return nil
+		}
+	case <-ctx.Done():
+		{
+			return ctx.Err()
+		}
+	}
+}
+
+func Drain(drainer Drainer) error {
+	err := drainer.Drain()
+	if err != nil {
+		return err
+	}
+	Go(func() {
+		for !drainer.IsClosed() {
+			<-time.After(DRAIN_POLL_INTERVAL)
+		}
+	})
+	return nil
+}
+
 func Cascade(in *ShadowRequest, callers ...Caller) (*ShadowResponse, Error) {
 	if callers == nil {
 		return nil, nil
@@ -105,28 +353,33 @@ func Cascade(in *ShadowRequest, callers ...Caller) (*ShadowResponse, Error) {
 		mut sync.RWMutex
 	)
 	in.Header.Add("X-Request-Id", uuid.New().String())
-	tasks := make(map[string]<-chan *Response)
-	ctx := make(map[string]context.Context)
+	tasks := make(map[string]*task)
 	or, err := in.CloneShadowRequest()
-	or.RouteValues = in.RouteValues
 	if err != nil {
 		return nil, NewError(err.Error(), http.StatusInternalServerError)
 	}
+	or.RouteValues = cloneRouteValues(in.RouteValues)
 	for _, cal := range callers {
-		err := await(cal, &mut, ctx, tasks, in, out)
+		var err Error
+		out, err = await(cal, &mut, tasks, in, out)
 		if err != nil {
 			return nil, err
 		}
 		if cal.GetIsParallel() {
-			spin(cal, &mut, ctx, tasks, in, or)
+			spin(cal, &mut, tasks, in, or)
 			continue
 		}
-		term, res, err := cal.Call(cal.GetContext(), or.RouteValues, in.CloneRequest, or.CloneRequest)
+		ctx, cancel := cal.GetContext(in.Context())
+		term, res, err := cal.Call(ctx, in.RouteValues, in.cloner(cal), or.CloneRequest)
+		res = CancelOnClose(res, cancel)
 		if err != nil {
 			return nil, err
 		}
 		if term {
-			res, err := NewShandowResponse(res)
+			if res == nil {
+				break
+			}
+			res, err := NewSpooledResponse(res, in.spool)
 			if err != nil {
 				return nil, NewError(err.Error(), http.StatusInternalServerError)
 			}
@@ -135,20 +388,10 @@ func Cascade(in *ShadowRequest, callers ...Caller) (*ShadowResponse, Error) {
 		if res == nil {
 			continue
 		}
-		out, err = createOrUpdateResponse(out, res, cal.GetResponseUpdaters())
+		out, err = exchange(cal, in, out, res)
 		if err != nil {
 			return nil, err
 		}
-		tmp, _err := out.CreateRequest()
-		if _err != nil {
-			return nil, NewError(_err.Error(), http.StatusInternalServerError)
-		}
-
-		_err = UpdateRequest(in, tmp.Request, append(cal.GetRequestUpdaters(), ReqUpdateHeader("X-Request-Id")))
-		if _err != nil {
-			return nil, NewError(_err.Error(), http.StatusInternalServerError)
-		}
-		in.Reset()
 	}
 	if out != nil {
 		out.Reset()
@@ -156,62 +399,88 @@ func Cascade(in *ShadowRequest, callers ...Caller) (*ShadowResponse, Error) {
 	return out, nil
 }
 
-func await(cal Caller, mut *sync.RWMutex, ctxs map[string]context.Context, tsks map[string]<-chan *Response, in *ShadowRequest, out *ShadowResponse) Error {
+func exchange(cal Caller, in *ShadowRequest, out *ShadowResponse, res *http.Response) (*ShadowResponse, Error) {
+	shadowResponse, err := NewSpooledResponse(res, in.spool)
+	if err != nil {
+		return nil, NewError(err.Error(), http.StatusInternalServerError)
+	}
+	tmp, err := shadowResponse.CreateRequest()
+	if err != nil {
+		return nil, NewError(err.Error(), http.StatusInternalServerError)
+	}
+	for _, updater := range append(cal.GetRequestUpdaters(), ReqUpdateHeader("X-Request-Id")) {
+		tmp.Reset()
+		err := updater(in, tmp.Request)
+		if err != nil {
+			return nil, NewError(err.Error(), http.StatusInternalServerError)
+		}
+	}
+	in.Reset()
+	if cal.GetLevel()&(LEVEL_CONNECT|LEVEL_REQUEST) != 0 {
+		return out, nil
+	}
+	shadowResponse.Reset()
+	return createOrUpdateResponse(out, shadowResponse.Response, in.spool, cal.GetResponseUpdaters())
+}
+
+func await(cal Caller, mut *sync.RWMutex, tsks map[string]*task, in *ShadowRequest, out *ShadowResponse) (*ShadowResponse, Error) {
 	if cal.GetAwaitList() == nil {
-		return nil
+		return out, nil
 	}
-	for _, task := range cal.GetAwaitList() {
-		var err Error
+	for _, name := range cal.GetAwaitList() {
 		mut.RLock()
-		ch, chFound := tsks[task]
-		ctx, ctxFound := ctxs[task]
+		task, found := tsks[name]
 		mut.RUnlock()
-		if !chFound || !ctxFound {
-			return NewError("task not found", http.StatusInternalServerError)
-		}
-		var cr *Response
-		select {
-		case cr = <-ch:
-			{
-				break
-			}
-		case <-ctx.Done():
-			{
-				cr = &Response{
-					Error: NewError(context.DeadlineExceeded.Error(), http.StatusGatewayTimeout),
-				}
-			}
+		if !found {
+			return nil, NewError("task not found", http.StatusInternalServerError)
 		}
+		cr := task.wait()
 		if cr.Error != nil {
-			return cr.Error
-		}
-		out, err = createOrUpdateResponse(out, cr.Response, cal.GetResponseUpdaters())
-		if err != nil {
-			return err
+			return nil, cr.Error
 		}
-		tmp, _err := out.CreateRequest()
-		if _err != nil {
-			return NewError(_err.Error(), http.StatusInternalServerError)
+		if task.exchanged || cr.Response == nil {
+			continue
 		}
-
-		_err = UpdateRequest(in, tmp.Request, cal.GetRequestUpdaters())
-		if _err != nil {
-			return NewError(_err.Error(), http.StatusInternalServerError)
+		task.exchanged = true
+		var err Error
+		out, err = exchange(task.caller, in, out, cr.Response)
+		if err != nil {
+			return nil, err
 		}
-		in.Reset()
 	}
-	return nil
+	return out, nil
 }
 
-func spin(cal Caller, mut *sync.RWMutex, ctxs map[string]context.Context, tsks map[string]<-chan *Response, in *ShadowRequest, or *ShadowRequest) {
+func spin(cal Caller, mut *sync.RWMutex, tsks map[string]*task, in *ShadowRequest, or *ShadowRequest) {
 	ch := make(chan *Response, 1)
+	snapshot, err := in.CloneShadowRequest()
+	if err != nil {
+		ch <- &Response{
+			Error: NewError(err.Error(), http.StatusInternalServerError),
+		}
+		close(ch)
+	}
+	ctx, cancel := cal.GetContext(context.Background())
+	task := &task{
+		caller: cal,
+		ctx:    ctx,
+		parent: in.Context(),
+		ch:     ch,
+	}
 	mut.Lock()
-	tsks[cal.GetName()] = ch
-	ctxs[cal.GetName()] = cal.GetContext()
+	tsks[cal.GetName()] = task
 	mut.Unlock()
-	go func() {
+	if err != nil {
+		cancel()
+		return
+	}
+	rv := cloneRouteValues(in.RouteValues)
+	release := in.spool.Hold()
+	Go(func() {
+		defer release()
 		defer close(ch)
-		_, r, err := cal.Call(cal.GetContext(), or.RouteValues, in.CloneRequest, or.CloneRequest)
+		_, r, err := cal.Call(task.ctx, rv, snapshot.cloner(cal), or.CloneRequest)
+		r = CancelOnClose(r, cancel)
 		if err != nil {
 			ch <- &Response{
 				Error: err,
@@ -221,12 +490,69 @@ func spin(cal Caller, mut *sync.RWMutex, ctxs map[string]context.Context, tsks m
 		ch <- &Response{
 			Response: r,
 		}
-	}()
+	})
 }
 
-func createOrUpdateResponse(in *ShadowResponse, res *http.Response, ru []ResponseUpdater) (*ShadowResponse, Error) {
+func (in *ShadowRequest) cloner(cal Caller) Cloner {
+	if StreamsBody(cal) {
+		return in.StreamRequest
+	}
+	return in.CloneRequest
+}
+
+func (task *task) wait() *Response {
+	if task.res != nil {
+		return task.res
+	}
+	select {
+	case cr, ok := <-task.ch:
+		{
+			task.res = received(cr, ok)
+		}
+	case <-task.ctx.Done():
+		{
+			// A call without a response cancels its context once it is
+			// done, so only a deadline means the task timed out.
+			if errors.Is(task.ctx.Err(), context.Canceled) {
+				cr, ok := <-task.ch
+				task.res = received(cr, ok)
+				break
+			}
+			task.res = &Response{
+				Error: NewTimeoutError(context.DeadlineExceeded.Error()),
+			}
+		}
+	case <-task.parent.Done():
+		{
+			task.res = &Response{
+				Error: NewTransportError(task.parent.Err()),
+			}
+		}
+	}
+	return task.res
+}
+
+func received(cr *Response, ok bool) *Response {
+	if !ok {
+		return &Response{}
+	}
+	return cr
+}
+
+func cloneRouteValues(rv RouteValues) RouteValues {
+	if rv == nil {
+		return nil
+	}
+	out := make(RouteValues)
+	for key, value := range rv {
+		out[key] = value
+	}
+	return out
+}
+
+func createOrUpdateResponse(in *ShadowResponse, res *http.Response, spool *Spool, ru []ResponseUpdater) (*ShadowResponse, Error) {
 	if in == nil {
-		res, err := NewShandowResponse(res)
+		res, err := NewSpooledResponse(res, spool)
 		if err != nil {
 			return nil, NewError(err.Error(), http.StatusInternalServerError)
 		}
@@ -247,3 +573,7 @@ func (httpError *httpError) Message() string {
 func (httpError *httpError) Status() int {
 	return httpError.status
 }
+
+func (httpError *httpError) Kind() ErrorKind {
+	return httpError.kind
+}
@@ -0,0 +1,310 @@
+package netio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type (
+	testCaller struct {
+		name             string
+		level            Level
+		next             Next
+		header           http.Header
+		body             string
+		err              Error
+		requestUpdaters  []RequestUpdater
+		responseUpdaters []ResponseUpdater
+		ctx              context.Context
+	}
+)
+
+func (caller *testCaller) GetLevel() Level {
+	return caller.level
+}
+
+func (caller *testCaller) GetIsParallel() bool {
+	return false
+}
+
+func (caller *testCaller) GetName() string {
+	return caller.name
+}
+
+func (caller *testCaller) GetAwaitList() []string {
+	return nil
+}
+
+func (caller *testCaller) Call(ctx context.Context, rv RouteValues, c Cloner, o Cloner) (Next, *http.Response, Error) {
+	caller.ctx = ctx
+	if caller.err != nil {
+		return TERM, nil, caller.err
+	}
+	if caller.header == nil && len(caller.body) == 0 {
+		return caller.next, nil, nil
+	}
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     caller.header.Clone(),
+		Body:       io.NopCloser(strings.NewReader(caller.body)),
+	}
+	if res.Header == nil {
+		res.Header = http.Header{}
+	}
+	return caller.next, res, nil
+}
+
+func (caller *testCaller) GetRequestUpdaters() []RequestUpdater {
+	return caller.requestUpdaters
+}
+
+func (caller *testCaller) GetResponseUpdaters() []ResponseUpdater {
+	return caller.responseUpdaters
+}
+
+func (caller *testCaller) OverrideRequestUpdaters(requestUpdaters []RequestUpdater) {
+	caller.requestUpdaters = requestUpdaters
+}
+
+func (caller *testCaller) OverrideResponseUpdaters(responseUpdaters []ResponseUpdater) {
+	caller.responseUpdaters = responseUpdaters
+}
+
+func (caller *testCaller) GetContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithCancel(parent)
+}
+
+func TestCascadeExchange(t *testing.T) {
+	header := http.Header{
+		"X-User-Id":        {"42"},
+		"X-User-Role":      {"admin"},
+		"X-Other":          {"other"},
+		HEADER_URL:         {"/v2/users?view=full"},
+		HEADER_QUERY:       {"page=3"},
+		HEADER_ROUTE_VALUE: {"id=7", "tenant=acme"},
+	}
+	tests := []struct {
+		name            string
+		updaters        []RequestUpdater
+		wantURL         string
+		wantHeader      http.Header
+		wantRouteValues RouteValues
+	}{
+		{name: "nothing exchanged", wantURL: "/users?page=1&sort=asc", wantHeader: http.Header{"X-User-Id": nil, "X-Other": nil}},
+		{name: "header patterns", updaters: []RequestUpdater{ReqUpdateHeader("x-user-*")}, wantURL: "/users?page=1&sort=asc", wantHeader: http.Header{"X-User-Id": {"42"}, "X-User-Role": {"admin"}, "X-Other": nil}},
+		{name: "control headers are not copied", updaters: []RequestUpdater{ReqUpdateHeader("*")}, wantURL: "/users?page=1&sort=asc", wantHeader: http.Header{"X-Other": {"other"}, HEADER_QUERY: nil, HEADER_URL: nil}},
+		{name: "replaced headers drop control headers", updaters: []RequestUpdater{ReqReplaceHeader()}, wantURL: "/users?page=1&sort=asc", wantHeader: http.Header{"X-Other": {"other"}, "Accept": nil, HEADER_ROUTE_VALUE: nil}},
+		{name: "query keys", updaters: []RequestUpdater{ReqUpdateQuery("page")}, wantURL: "/users?page=3&sort=asc"},
+		{name: "replaced query", updaters: []RequestUpdater{ReqReplaceQuery()}, wantURL: "/users?page=3"},
+		{name: "route values", updaters: []RequestUpdater{ReqUpdateRouteValues("id")}, wantURL: "/users?page=1&sort=asc", wantRouteValues: RouteValues{"id": "7", "tenant": "default"}},
+		{name: "url", updaters: []RequestUpdater{ReqReplaceURL()}, wantURL: "/v2/users?view=full"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/users?page=1&sort=asc", nil)
+			r.Header.Set("Accept", "application/json")
+			in, err := NewShadowRequest(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			in.RouteValues = RouteValues{"id": "1", "tenant": "default"}
+			out, cerr := Cascade(in, &testCaller{
+				name:            "filter",
+				level:           LEVEL_REQUEST,
+				header:          header,
+				requestUpdaters: test.updaters,
+			})
+			if cerr != nil {
+				t.Fatal(cerr.Message())
+			}
+			if out != nil {
+				t.Fatalf("expected a request level filter to leave the response empty")
+			}
+			if in.URL.String() != test.wantURL {
+				t.Fatalf("expected url %s, got %s", test.wantURL, in.URL.String())
+			}
+			for key, values := range test.wantHeader {
+				if !reflect.DeepEqual(in.Header.Values(key), values) && !(len(values) == 0 && len(in.Header.Values(key)) == 0) {
+					t.Fatalf("expected header %s to be %v, got %v", key, values, in.Header.Values(key))
+				}
+			}
+			if test.wantRouteValues != nil && !reflect.DeepEqual(in.RouteValues, test.wantRouteValues) {
+				t.Fatalf("expected route values %v, got %v", test.wantRouteValues, in.RouteValues)
+			}
+		})
+	}
+}
+
+func TestCascadeForm(t *testing.T) {
+	r := httptest.NewRequest("POST", "/users?source=web", strings.NewReader("name=old"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	in, err := NewShadowRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cerr := Cascade(in, &testCaller{
+		level:           LEVEL_REQUEST,
+		body:            "name=new&role=admin",
+		requestUpdaters: []RequestUpdater{ReqReplaceForm()},
+	})
+	if cerr != nil {
+		t.Fatal(cerr.Message())
+	}
+	if in.PostForm.Encode() != "name=new&role=admin" {
+		t.Fatalf("expected the form to be replaced, got %s", in.PostForm.Encode())
+	}
+	if in.Form.Encode() != "name=new&role=admin&source=web" {
+		t.Fatalf("expected the form to include the query, got %s", in.Form.Encode())
+	}
+	body, err := io.ReadAll(in.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "name=new&role=admin" || in.ContentLength != int64(len(body)) {
+		t.Fatalf("expected the body to carry the form, got %s with content length %d", body, in.ContentLength)
+	}
+}
+
+func TestCascadeTerminate(t *testing.T) {
+	tests := []struct {
+		name       string
+		callers    []Caller
+		wantBody   string
+		wantStatus int
+	}{
+		{name: "terminate with response", callers: []Caller{&testCaller{level: LEVEL_REQUEST, next: TERM, body: "denied"}, &testCaller{level: LEVEL_NONE, body: "backend"}}, wantBody: "denied"},
+		{name: "error", callers: []Caller{&testCaller{level: LEVEL_REQUEST, err: NewError("unauthorized", http.StatusUnauthorized)}, &testCaller{level: LEVEL_NONE, body: "backend"}}, wantStatus: http.StatusUnauthorized},
+		{name: "continue", callers: []Caller{&testCaller{level: LEVEL_REQUEST}, &testCaller{level: LEVEL_NONE, body: "backend"}}, wantBody: "backend"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			in, err := NewShadowRequest(httptest.NewRequest("GET", "/", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, cerr := Cascade(in, test.callers...)
+			if test.wantStatus != 0 {
+				if cerr == nil || cerr.Status() != test.wantStatus {
+					t.Fatalf("expected status %d, got %v", test.wantStatus, cerr)
+				}
+				return
+			}
+			if cerr != nil {
+				t.Fatal(cerr.Message())
+			}
+			body, err := io.ReadAll(out.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != test.wantBody {
+				t.Fatalf("expected %s, got %s", test.wantBody, body)
+			}
+		})
+	}
+}
+
+func TestCascadeContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	in, err := NewShadowRequest(httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	caller := &testCaller{level: LEVEL_REQUEST}
+	if _, err := Cascade(in, caller); err != nil {
+		t.Fatal(err.Message())
+	}
+	if caller.ctx.Err() != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, caller.ctx.Err())
+	}
+}
+
+func TestCancelOnClose(t *testing.T) {
+	tests := []struct {
+		name string
+		res  *http.Response
+	}{
+		{name: "no response"},
+		{name: "no body", res: &http.Response{}},
+		{name: "body", res: &http.Response{Body: io.NopCloser(strings.NewReader("body"))}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			res := CancelOnClose(test.res, cancel)
+			if test.res == nil || test.res.Body == nil {
+				if ctx.Err() != context.Canceled {
+					t.Fatalf("expected %v, got %v", context.Canceled, ctx.Err())
+				}
+				return
+			}
+			if ctx.Err() != nil {
+				t.Fatalf("expected the context to live until the body is closed, got %v", ctx.Err())
+			}
+			res.Body.Close()
+			if ctx.Err() != context.Canceled {
+				t.Fatalf("expected %v, got %v", context.Canceled, ctx.Err())
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		key      string
+		want     bool
+	}{
+		{name: "exact", patterns: []string{"Authorization"}, key: "Authorization", want: true},
+		{name: "case insensitive", patterns: []string{"x-user-id"}, key: "X-User-Id", want: true},
+		{name: "glob", patterns: []string{"X-User-*"}, key: "X-User-Role", want: true},
+		{name: "any", patterns: []string{"*"}, key: "Cookie", want: true},
+		{name: "no match", patterns: []string{"X-User-*", "Authorization"}, key: "Cookie"},
+		{name: "no patterns", key: "Cookie"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if match := Match(test.patterns, test.key); match != test.want {
+				t.Fatalf("expected %v, got %v", test.want, match)
+			}
+		})
+	}
+}
+
+type (
+	testDrainer struct {
+		closed atomic.Bool
+	}
+)
+
+func (drainer *testDrainer) Drain() error {
+	return nil
+}
+
+func (drainer *testDrainer) IsClosed() bool {
+	return drainer.closed.Load()
+}
+
+func TestDrain(t *testing.T) {
+	drainer := new(testDrainer)
+	if err := Drain(drainer); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DRAIN_POLL_INTERVAL*2)
+	defer cancel()
+	if err := Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected wait to time out while draining, got %v", err)
+	}
+	drainer.closed.Store(true)
+	if err := Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/url"
+	"path"
+	"regexp"
 	"strings"
 )
 
@@ -15,31 +19,188 @@ type (
 	ShadowRequest struct {
 		*http.Request
 		RouteValues RouteValues
-		data        []byte
+		body        *Body
+		spool       *Spool
 	}
 	RequestOption  func(*http.Request)
 	RequestUpdater func(*ShadowRequest, *http.Request) error
+	Rewrite        struct {
+		StripPrefix string
+		AddPrefix   string
+		Regex       []RegexRewrite
+		Query       map[string]string
+		Host        string
+	}
+	RegexRewrite struct {
+		Match   *regexp.Regexp
+		Replace string
+	}
+)
+
+const (
+	HEADER_URL         = "X-Iceberg-Url"
+	HEADER_QUERY       = "X-Iceberg-Query"
+	HEADER_ROUTE_VALUE = "X-Iceberg-Route-Value"
+	HEADER_STATUS      = "X-Iceberg-Status"
+
+	HEADER_CLIENT_IP         = "X-Client-Ip"
+	HEADER_FORWARDED         = "Forwarded"
+	HEADER_X_FORWARDED_FOR   = "X-Forwarded-For"
+	HEADER_X_FORWARDED_PROTO = "X-Forwarded-Proto"
+	HEADER_X_FORWARDED_HOST  = "X-Forwarded-Host"
+
+	MAX_MULTIPART_MEMORY = 32 << 20
+
+	HOST_BACKEND  = "backend"
+	HOST_PRESERVE = "preserve"
+)
+
+var (
+	FORWARDED_HEADERS  = []string{HEADER_CLIENT_IP, HEADER_FORWARDED, HEADER_X_FORWARDED_FOR, HEADER_X_FORWARDED_PROTO, HEADER_X_FORWARDED_HOST}
+	HOP_BY_HOP_HEADERS = []string{"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Te", "Upgrade"}
 )
 
 func WithUrl(url *url.URL, rv map[string]string) RequestOption {
 	return func(r *http.Request) {
-		path := url.Path
-		for key, value := range rv {
-			path = strings.ReplaceAll(path, fmt.Sprintf("{%s}", key), value)
-		}
 		(*r).URL.Host = url.Host
 		(*r).URL.Scheme = url.Scheme
-		(*r).URL.Path = path
+		(*r).URL.Path = Template(url.Path, rv)
 		(*r).Host = url.Host
 	}
 }
 
+func WithRewrite(url *url.URL, rewrite *Rewrite, rv map[string]string) RequestOption {
+	return func(r *http.Request) {
+		(*r).Host = rewrite.HostHeader(url, r.Host)
+		(*r).URL = rewrite.URL(url, r.URL, rv)
+	}
+}
+
+// Template replaces {key} placeholders with route values.
+func Template(template string, rv map[string]string) string {
+	for key, value := range rv {
+		template = strings.ReplaceAll(template, fmt.Sprintf("{%s}", key), value)
+	}
+	return template
+}
+
+// URL resolves the backend URL of an incoming request. A backend path is a
+// template filled with route values, and without one the incoming path is
+// kept. Rewrites then apply in order: strip prefix, regular expressions and
+// add prefix. Query parameters of the backend URL and of the rewrite are
+// templates set on top of the incoming query, and an empty one removes the
+// parameter.
+func (rewrite *Rewrite) URL(backend *url.URL, in *url.URL, rv map[string]string) *url.URL {
+	out := cloneURL(in)
+	out.Scheme = backend.Scheme
+	out.Host = backend.Host
+	out.RawPath = ""
+	out.Path = in.Path
+	if len(backend.Path) != 0 {
+		out.Path = Template(backend.Path, rv)
+	}
+	templates := make(map[string]string)
+	for key, values := range backend.Query() {
+		templates[key] = values[0]
+	}
+	if rewrite != nil {
+		out.Path = rewrite.path(out.Path)
+		for key, value := range rewrite.Query {
+			templates[key] = value
+		}
+	}
+	if len(templates) != 0 {
+		query := out.Query()
+		for key, template := range templates {
+			value := Template(template, rv)
+			if len(value) == 0 {
+				query.Del(key)
+				continue
+			}
+			query.Set(key, value)
+		}
+		out.RawQuery = query.Encode()
+	}
+	return out
+}
+
+func (rewrite *Rewrite) path(path string) string {
+	if prefix := strings.TrimSuffix(rewrite.StripPrefix, "/"); len(prefix) != 0 {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			path = path[len(prefix):]
+		}
+	}
+	for _, regex := range rewrite.Regex {
+		path = regex.Match.ReplaceAllString(path, regex.Replace)
+	}
+	if len(rewrite.AddPrefix) != 0 {
+		path = strings.TrimSuffix(rewrite.AddPrefix, "/") + "/" + strings.TrimPrefix(path, "/")
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// HostHeader returns the Host header sent to the backend: the backend host
+// by default, the incoming one when preserved, or a fixed value.
+func (rewrite *Rewrite) HostHeader(backend *url.URL, host string) string {
+	if rewrite == nil {
+		return backend.Host
+	}
+	switch rewrite.Host {
+	case "", HOST_BACKEND:
+		{
+			return backend.Host
+		}
+	case HOST_PRESERVE:
+		{
+			return host
+		}
+	}
+	return rewrite.Host
+}
+
 func WithContext(ctx context.Context) RequestOption {
 	return func(r *http.Request) {
 		*r = *r.WithContext(ctx)
 	}
 }
 
+func WithoutHopByHop() RequestOption {
+	return func(r *http.Request) {
+		RemoveHopByHop(r.Header)
+	}
+}
+
+// RemoveHopByHop removes the headers meant for a single connection, along
+// with those named by Connection, except the forwarding headers set by
+// iceberg which clients must not be able to drop. "TE: trailers" is kept,
+// since backends need it to send trailers.
+func RemoveHopByHop(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, key := range strings.Split(value, ",") {
+			if key = strings.TrimSpace(key); len(key) != 0 && !Match(FORWARDED_HEADERS, key) {
+				header.Del(key)
+			}
+		}
+	}
+	trailers := false
+	for _, value := range header.Values("Te") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "trailers") {
+				trailers = true
+			}
+		}
+	}
+	for _, key := range HOP_BY_HOP_HEADERS {
+		header.Del(key)
+	}
+	if trailers {
+		header.Set("Te", "trailers")
+	}
+}
+
 func WithMethod(method string) RequestOption {
 	return func(r *http.Request) {
 		r.Method = method
@@ -48,8 +209,11 @@ func WithMethod(method string) RequestOption {
 
 func ReqUpdateHeader(keys ...string) RequestUpdater {
 	return func(shadowRequest *ShadowRequest, r *http.Request) error {
-		for _, key := range keys {
-			shadowRequest.Header.Set(key, r.Header.Get(key))
+		for key, values := range r.Header {
+			if isControlHeader(key) || !Match(keys, key) {
+				continue
+			}
+			shadowRequest.Header[key] = append([]string(nil), values...)
 		}
 		return nil
 	}
@@ -57,8 +221,65 @@ func ReqUpdateHeader(keys ...string) RequestUpdater {
 
 func ReqUpdateTailer(keys ...string) RequestUpdater {
 	return func(shadowRequest *ShadowRequest, r *http.Request) error {
-		for _, key := range keys {
-			shadowRequest.Trailer.Set(key, r.Header.Get(key))
+		for key, values := range r.Trailer {
+			if !Match(keys, key) {
+				continue
+			}
+			if shadowRequest.Trailer == nil {
+				shadowRequest.Trailer = http.Header{}
+			}
+			shadowRequest.Trailer[key] = append([]string(nil), values...)
+		}
+		return nil
+	}
+}
+
+func ReqUpdateQuery(keys ...string) RequestUpdater {
+	return func(shadowRequest *ShadowRequest, r *http.Request) error {
+		if len(r.Header.Values(HEADER_QUERY)) == 0 {
+			return nil
+		}
+		values, err := url.ParseQuery(strings.Join(r.Header.Values(HEADER_QUERY), "&"))
+		if err != nil {
+			return err
+		}
+		query := shadowRequest.URL.Query()
+		for key, value := range values {
+			if !Match(keys, key) {
+				continue
+			}
+			query[key] = value
+		}
+		shadowRequest.URL.RawQuery = query.Encode()
+		return nil
+	}
+}
+
+func ReqReplaceQuery() RequestUpdater {
+	return func(shadowRequest *ShadowRequest, r *http.Request) error {
+		if len(r.Header.Values(HEADER_QUERY)) == 0 {
+			return nil
+		}
+		values, err := url.ParseQuery(strings.Join(r.Header.Values(HEADER_QUERY), "&"))
+		if err != nil {
+			return err
+		}
+		shadowRequest.URL.RawQuery = values.Encode()
+		return nil
+	}
+}
+
+func ReqUpdateRouteValues(keys ...string) RequestUpdater {
+	return func(shadowRequest *ShadowRequest, r *http.Request) error {
+		for _, value := range r.Header.Values(HEADER_ROUTE_VALUE) {
+			key, value, found := strings.Cut(value, "=")
+			if !found || !Match(keys, strings.TrimSpace(key)) {
+				continue
+			}
+			if shadowRequest.RouteValues == nil {
+				shadowRequest.RouteValues = make(RouteValues)
+			}
+			shadowRequest.RouteValues[strings.TrimSpace(key)] = strings.TrimSpace(value)
 		}
 		return nil
 	}
@@ -66,12 +287,12 @@ func ReqUpdateTailer(keys ...string) RequestUpdater {
 
 func ReqReplaceBody() RequestUpdater {
 	return func(shadowRequest *ShadowRequest, r *http.Request) error {
-		body, err := io.ReadAll(r.Body)
+		body, err := shadowRequest.spool.buffer(r.Body)
 		if err != nil {
 			return err
 		}
-		shadowRequest.data = body
-		(*shadowRequest.Request).Body = io.NopCloser(bytes.NewReader(body))
+		shadowRequest.body = body
+		(*shadowRequest.Request).Body = body.Reader()
 		return nil
 	}
 }
@@ -79,6 +300,11 @@ func ReqReplaceBody() RequestUpdater {
 func ReqReplaceHeader() RequestUpdater {
 	return func(shadowRequest *ShadowRequest, r *http.Request) error {
 		shadowRequest.Header = cloneHeader(r.Header)
+		for key := range shadowRequest.Header {
+			if isControlHeader(key) {
+				shadowRequest.Header.Del(key)
+			}
+		}
 		return nil
 	}
 }
@@ -90,36 +316,127 @@ func ReqReplaceTailer(keys ...string) RequestUpdater {
 	}
 }
 
+// ReqReplaceStatus passes the status of a backend response on to the
+// response filters, which receive it in the X-Iceberg-Status header.
+func ReqReplaceStatus() RequestUpdater {
+	return func(shadowRequest *ShadowRequest, r *http.Request) error {
+		shadowRequest.Header.Del(HEADER_STATUS)
+		if status := r.Header.Get(HEADER_STATUS); len(status) != 0 {
+			shadowRequest.Header.Set(HEADER_STATUS, status)
+		}
+		return nil
+	}
+}
+
 func ReqReplaceForm() RequestUpdater {
 	return func(shadowRequest *ShadowRequest, r *http.Request) error {
-		shadowRequest.Form = cloneURLValues(r.Form)
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return err
+		}
+		shadowRequest.PostForm = form
+		shadowRequest.Form = shadowRequest.URL.Query()
+		for key, values := range form {
+			shadowRequest.Form[key] = append(shadowRequest.Form[key], values...)
+		}
+		shadowRequest.MultipartForm = nil
+		shadowRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		shadowRequest.setBody([]byte(form.Encode()))
 		return nil
 	}
 }
 
 func ReqReplaceURL() RequestUpdater {
 	return func(shadowRequest *ShadowRequest, r *http.Request) error {
-		shadowRequest.URL = cloneURL(r.URL)
+		value := r.Header.Get(HEADER_URL)
+		if len(value) == 0 {
+			return nil
+		}
+		url, err := url.Parse(value)
+		if err != nil {
+			return err
+		}
+		shadowRequest.URL.Path = url.Path
+		shadowRequest.URL.RawPath = url.RawPath
+		shadowRequest.URL.RawQuery = url.RawQuery
 		return nil
 	}
 }
 
 func ReqReplaceMultipartForm() RequestUpdater {
 	return func(shadowRequest *ShadowRequest, r *http.Request) error {
-		shadowRequest.MultipartForm = cloneMultipartForm(r.MultipartForm)
+		contentType := r.Header.Get("Content-Type")
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(mediaType, "multipart/") {
+			return fmt.Errorf("expected a multipart body but found %s", mediaType)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		form, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(MAX_MULTIPART_MEMORY)
+		if err != nil {
+			return err
+		}
+		shadowRequest.MultipartForm = form
+		shadowRequest.PostForm = url.Values(form.Value)
+		shadowRequest.Form = shadowRequest.URL.Query()
+		for key, values := range form.Value {
+			shadowRequest.Form[key] = append(shadowRequest.Form[key], values...)
+		}
+		shadowRequest.Header.Set("Content-Type", contentType)
+		shadowRequest.setBody(body)
 		return nil
 	}
 }
 
-func NewShadowRequest(request *http.Request) (*ShadowRequest, error) {
-	body, err := io.ReadAll(request.Body)
-	if err != nil {
-		return nil, err
+func Match(patterns []string, key string) bool {
+	key = strings.ToLower(key)
+	for _, pattern := range patterns {
+		ok, _ := path.Match(strings.ToLower(pattern), key)
+		if ok {
+			return true
+		}
 	}
+	return false
+}
+
+func isControlHeader(key string) bool {
+	switch http.CanonicalHeaderKey(key) {
+	case HEADER_URL, HEADER_QUERY, HEADER_ROUTE_VALUE, HEADER_STATUS:
+		{
+			return true
+		}
+	}
+	return false
+}
+
+func NewShadowRequest(request *http.Request) (*ShadowRequest, error) {
+	return NewSpooledRequest(request, nil)
+}
+
+// NewSpooledRequest wraps request, buffering its body through spool.
+func NewSpooledRequest(request *http.Request, spool *Spool) (*ShadowRequest, error) {
 	r := ShadowRequest{}
 	r.Request = request
-	(*r.Request).Body = io.NopCloser(bytes.NewReader(body))
-	r.data = body
+	r.spool = spool
+	if spool != nil && spool.Streaming {
+		r.body = spool.Stream(spool.Limit(request.Body))
+	} else {
+		body, err := spool.Read(request.Body)
+		if err != nil {
+			return nil, err
+		}
+		r.body = body
+	}
+	(*r.Request).Body = r.body.Reader()
 	return &r, nil
 }
 
@@ -136,40 +453,59 @@ func UpdateRequest(shadowRequest *ShadowRequest, request *http.Request, requestU
 	return nil
 }
 
+func (shadowRequest *ShadowRequest) setBody(body []byte) {
+	shadowRequest.body = NewBody(body)
+	shadowRequest.ContentLength = int64(len(body))
+	shadowRequest.Header.Del("Content-Length")
+	(*shadowRequest.Request).Body = shadowRequest.body.Reader()
+}
+
 func (shadowRequest *ShadowRequest) Reset() {
-	(*shadowRequest.Request).Body = io.NopCloser(bytes.NewReader(shadowRequest.data))
+	(*shadowRequest.Request).Body = shadowRequest.body.Reader()
 }
 
+// CloneRequest clones the request, leaving out a streamed body.
 func (shadowRequest *ShadowRequest) CloneRequest(options ...RequestOption) (*http.Request, error) {
-	r := shadowRequest.Request
-	req := new(http.Request)
-	req.Header = cloneHeader(r.Header)
-	req.Trailer = cloneHeader(r.Trailer)
-	req.Form = cloneURLValues(r.Form)
-	req.PostForm = cloneURLValues(r.PostForm)
-	req.TransferEncoding = cloneTransferEncoding(r.TransferEncoding)
-	req.Body = io.NopCloser(bytes.NewReader(shadowRequest.data))
-	req.URL = cloneURL(r.URL)
-	req.MultipartForm = cloneMultipartForm(r.MultipartForm)
-	for _, option := range options {
-		option(req)
-	}
-	return req, nil
+	return shadowRequest.clone(false, options...), nil
+}
+
+// StreamRequest clones the request along with a streamed body.
+func (shadowRequest *ShadowRequest) StreamRequest(options ...RequestOption) (*http.Request, error) {
+	return shadowRequest.clone(true, options...), nil
 }
 
+// CloneShadowRequest snapshots the request, leaving out a streamed body.
 func (shadowRequest *ShadowRequest) CloneShadowRequest(options ...RequestOption) (*ShadowRequest, error) {
+	return NewSpooledRequest(shadowRequest.clone(false, options...), shadowRequest.spool)
+}
+
+func (shadowRequest *ShadowRequest) clone(stream bool, options ...RequestOption) *http.Request {
 	r := shadowRequest.Request
+	body := shadowRequest.body
+	if body.IsStream() && !stream {
+		body = _emptyBody
+	}
 	req := new(http.Request)
+	req.Method = r.Method
+	req.Host = r.Host
+	req.ContentLength = body.Len()
+	if body.IsStream() {
+		req.ContentLength = r.ContentLength
+	}
 	req.Header = cloneHeader(r.Header)
 	req.Trailer = cloneHeader(r.Trailer)
 	req.Form = cloneURLValues(r.Form)
 	req.PostForm = cloneURLValues(r.PostForm)
 	req.TransferEncoding = cloneTransferEncoding(r.TransferEncoding)
-	req.Body = io.NopCloser(bytes.NewReader(shadowRequest.data))
+	req.Body = body.Reader()
+	if body == _emptyBody {
+		req.Body = http.NoBody
+		req.TransferEncoding = nil
+	}
 	req.URL = cloneURL(r.URL)
 	req.MultipartForm = cloneMultipartForm(r.MultipartForm)
 	for _, option := range options {
 		option(req)
 	}
-	return NewShadowRequest(req)
+	return req
 }
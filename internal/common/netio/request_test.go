@@ -0,0 +1,82 @@
+package netio
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+)
+
+func TestRewriteURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend string
+		in      string
+		rewrite *Rewrite
+		rv      map[string]string
+		want    string
+	}{
+		{name: "no rewrite", backend: "http://backend:8080", in: "/api/users?page=2", want: "http://backend:8080/api/users?page=2"},
+		{name: "backend path", backend: "http://backend/v2/users/{id}", in: "/api/users/42?page=2", rv: map[string]string{"id": "42"}, want: "http://backend/v2/users/42?page=2"},
+		{name: "strip prefix", backend: "http://backend", in: "/api/users", rewrite: &Rewrite{StripPrefix: "/api"}, want: "http://backend/users"},
+		{name: "strip prefix with slash", backend: "http://backend", in: "/api/users", rewrite: &Rewrite{StripPrefix: "/api/"}, want: "http://backend/users"},
+		{name: "strip whole path", backend: "http://backend", in: "/api", rewrite: &Rewrite{StripPrefix: "/api"}, want: "http://backend/"},
+		{name: "strip prefix on segment boundary", backend: "http://backend", in: "/apix/users", rewrite: &Rewrite{StripPrefix: "/api"}, want: "http://backend/apix/users"},
+		{name: "add prefix", backend: "http://backend", in: "/users", rewrite: &Rewrite{AddPrefix: "/v1/"}, want: "http://backend/v1/users"},
+		{name: "regex", backend: "http://backend", in: "/users/42", rewrite: &Rewrite{Regex: []RegexRewrite{{Match: regexp.MustCompile(`^/users/(\d+)$`), Replace: "/people/$1"}}}, want: "http://backend/people/42"},
+		{name: "regex without leading slash", backend: "http://backend", in: "/users", rewrite: &Rewrite{Regex: []RegexRewrite{{Match: regexp.MustCompile(`^/`), Replace: ""}}}, want: "http://backend/users"},
+		{
+			name:    "rewrites in order",
+			backend: "http://backend",
+			in:      "/api/users/42",
+			rewrite: &Rewrite{
+				StripPrefix: "/api",
+				Regex:       []RegexRewrite{{Match: regexp.MustCompile(`^/users/`), Replace: "/people/"}},
+				AddPrefix:   "/internal",
+			},
+			want: "http://backend/internal/people/42",
+		},
+		{name: "backend query", backend: "http://backend?tenant={tenant}", in: "/users?page=2", rv: map[string]string{"tenant": "acme"}, want: "http://backend/users?page=2&tenant=acme"},
+		{name: "rewrite query overrides backend query", backend: "http://backend?tenant=fixed", in: "/users", rewrite: &Rewrite{Query: map[string]string{"tenant": "{tenant}"}}, rv: map[string]string{"tenant": "acme"}, want: "http://backend/users?tenant=acme"},
+		{name: "empty query removes parameter", backend: "http://backend", in: "/users?debug=1&page=2", rewrite: &Rewrite{Query: map[string]string{"debug": ""}}, want: "http://backend/users?page=2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend, err := url.Parse(test.backend)
+			if err != nil {
+				t.Fatal(err)
+			}
+			in, err := url.Parse(test.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out := test.rewrite.URL(backend, in, test.rv).String(); out != test.want {
+				t.Fatalf("expected %s, got %s", test.want, out)
+			}
+			if in.String() != test.in {
+				t.Fatalf("expected the incoming URL to be left as %s, got %s", test.in, in.String())
+			}
+		})
+	}
+}
+
+func TestRewriteHostHeader(t *testing.T) {
+	backend := &url.URL{Scheme: "http", Host: "backend:8080"}
+	tests := []struct {
+		name    string
+		rewrite *Rewrite
+		want    string
+	}{
+		{name: "no rewrite", want: "backend:8080"},
+		{name: "default", rewrite: &Rewrite{}, want: "backend:8080"},
+		{name: "backend", rewrite: &Rewrite{Host: HOST_BACKEND}, want: "backend:8080"},
+		{name: "preserve", rewrite: &Rewrite{Host: HOST_PRESERVE}, want: "api.example.com"},
+		{name: "fixed", rewrite: &Rewrite{Host: "internal.example.com"}, want: "internal.example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if host := test.rewrite.HostHeader(backend, "api.example.com"); host != test.want {
+				t.Fatalf("expected %s, got %s", test.want, host)
+			}
+		})
+	}
+}
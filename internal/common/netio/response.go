@@ -1,28 +1,40 @@
 package netio
 
 import (
-	"bytes"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 )
 
 type (
 	ShadowResponse struct {
 		*http.Response
-		data []byte
+		body  *Body
+		spool *Spool
 	}
 	ResponseUpdater func(*ShadowResponse, *http.Response) error
 )
 
 func NewShandowResponse(response *http.Response) (*ShadowResponse, error) {
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		return nil, err
-	}
+	return NewSpooledResponse(response, nil)
+}
+
+// NewSpooledResponse wraps response, buffering its body through spool.
+func NewSpooledResponse(response *http.Response, spool *Spool) (*ShadowResponse, error) {
 	r := ShadowResponse{}
 	r.Response = response
-	(*r.Response).Body = io.NopCloser(bytes.NewBuffer(body))
-	r.data = body
+	r.spool = spool
+	if spool != nil && spool.Streaming {
+		r.body = spool.Stream(response.Body)
+	} else {
+		body, err := spool.buffer(response.Body)
+		if err != nil {
+			return nil, err
+		}
+		r.body = body
+	}
+	(*r.Response).Body = r.body.Reader()
 	return &r, nil
 }
 
@@ -63,8 +75,14 @@ func ResUpdateTailers() ResponseUpdater {
 
 func ResUpdateHeader(keys ...string) ResponseUpdater {
 	return func(shadowResponse *ShadowResponse, r *http.Response) error {
-		for _, key := range keys {
-			shadowResponse.Header.Set(key, r.Header.Get(key))
+		for key, values := range r.Header {
+			if isControlHeader(key) || !Match(keys, key) {
+				continue
+			}
+			if shadowResponse.Header == nil {
+				shadowResponse.Header = http.Header{}
+			}
+			shadowResponse.Header[key] = append([]string(nil), values...)
 		}
 		return nil
 	}
@@ -72,8 +90,14 @@ func ResUpdateHeader(keys ...string) ResponseUpdater {
 
 func ResUpdateTailer(keys ...string) ResponseUpdater {
 	return func(shadowResponse *ShadowResponse, r *http.Response) error {
-		for _, key := range keys {
-			shadowResponse.Trailer.Set(key, r.Header.Get(key))
+		for key, values := range r.Trailer {
+			if !Match(keys, key) {
+				continue
+			}
+			if shadowResponse.Trailer == nil {
+				shadowResponse.Trailer = http.Header{}
+			}
+			shadowResponse.Trailer[key] = append([]string(nil), values...)
 		}
 		return nil
 	}
@@ -81,12 +105,12 @@ func ResUpdateTailer(keys ...string) ResponseUpdater {
 
 func ResReplaceBody() ResponseUpdater {
 	return func(shadowResponse *ShadowResponse, r *http.Response) error {
-		body, err := io.ReadAll(r.Body)
+		body, err := shadowResponse.spool.buffer(r.Body)
 		if err != nil {
 			return err
 		}
-		shadowResponse.data = body
-		(*shadowResponse.Response).Body = io.NopCloser(bytes.NewReader(body))
+		shadowResponse.body = body
+		(*shadowResponse.Response).Body = body.Reader()
 		return nil
 	}
 }
@@ -105,33 +129,65 @@ func ResReplaceTailer(keys ...string) ResponseUpdater {
 	}
 }
 
+// ResReplaceStatus sets the status of the response to the one returned in
+// the X-Iceberg-Status header.
+func ResReplaceStatus() ResponseUpdater {
+	return func(shadowResponse *ShadowResponse, r *http.Response) error {
+		value := r.Header.Get(HEADER_STATUS)
+		if len(value) == 0 {
+			return nil
+		}
+		status, err := strconv.Atoi(value)
+		if err != nil || status < 100 || status > 599 {
+			return fmt.Errorf("invalid status %s", value)
+		}
+		shadowResponse.StatusCode = status
+		shadowResponse.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+		return nil
+	}
+}
+
 func (shadowResponse *ShadowResponse) Reset() {
-	(*shadowResponse.Response).Body = io.NopCloser(bytes.NewReader(shadowResponse.data))
+	(*shadowResponse.Response).Body = shadowResponse.body.Reader()
 }
 
 func (shadowResponse *ShadowResponse) CreateRequest() (*ShadowRequest, error) {
+	header := cloneHeader(shadowResponse.Header)
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Del(HEADER_STATUS)
+	if shadowResponse.StatusCode != 0 {
+		header.Set(HEADER_STATUS, strconv.Itoa(shadowResponse.StatusCode))
+	}
 	req := http.Request{
-		Header:           cloneHeader(shadowResponse.Header),
+		Header:           header,
 		Trailer:          cloneHeader(shadowResponse.Trailer),
 		TransferEncoding: cloneTransferEncoding(shadowResponse.TransferEncoding),
-		Body:             io.NopCloser(bytes.NewReader(shadowResponse.data)),
+		Body:             shadowResponse.body.Reader(),
 	}
-	return NewShadowRequest(&req)
+	return NewSpooledRequest(&req, shadowResponse.spool)
 }
 
 func (shadowResponse *ShadowResponse) CloneResponse() (*http.Response, error) {
 	r := shadowResponse.Response
 	res := *r
-	res.Body = io.NopCloser(bytes.NewReader(shadowResponse.data))
+	res.Body = shadowResponse.body.Reader()
 	return &res, nil
 }
 
 func (shadowResponse *ShadowResponse) Write(w http.ResponseWriter) {
 	shadowResponse.Response.Header.Del("Content-Length")
 	for key, values := range shadowResponse.Response.Header {
+		if isControlHeader(key) {
+			continue
+		}
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
 	}
-	_, _ = w.Write(shadowResponse.data)
+	if shadowResponse.StatusCode != 0 {
+		w.WriteHeader(shadowResponse.StatusCode)
+	}
+	_, _ = io.Copy(w, shadowResponse.body.Reader())
 }
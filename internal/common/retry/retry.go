@@ -0,0 +1,284 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/vedadiyan/iceberg/internal/common/metrics"
+	"github.com/vedadiyan/iceberg/internal/common/netio"
+)
+
+type (
+	Condition string
+	// Policy retries a failed call up to Attempts times in total, waiting
+	// an exponential backoff with full jitter between attempts. A call is
+	// retried when it fails with one of Conditions or Statuses. Calls that
+	// may have reached the backend are only retried for Methods, which
+	// defaults to the idempotent methods, and a streamed body is never
+	// replayed. Retries are also capped by Budget, if any.
+	Policy struct {
+		Attempts      int
+		PerTryTimeout time.Duration
+		Backoff       time.Duration
+		MaxBackoff    time.Duration
+		Conditions    []Condition
+		Statuses      []int
+		Methods       []string
+		Budget        *Budget
+	}
+	// Budget caps retries across the policies sharing it at Ratio of the
+	// calls made in the last BUDGET_WINDOW seconds, plus MinPerSecond
+	// retries per second.
+	Budget struct {
+		Ratio        float64
+		MinPerSecond int
+
+		mut     sync.Mutex
+		buckets [BUDGET_WINDOW]bucket
+	}
+	bucket struct {
+		second  int64
+		calls   int
+		retries int
+	}
+	Call func(context.Context, netio.Cloner) (netio.Next, *http.Response, netio.Error)
+)
+
+const (
+	CONDITION_CONNECT       Condition = "connect"
+	CONDITION_RESET         Condition = "reset"
+	CONDITION_TIMEOUT       Condition = "timeout"
+	CONDITION_NO_RESPONDERS Condition = "noResponders"
+
+	ATTEMPTS    = 3
+	BACKOFF     = time.Millisecond * 25
+	MAX_BACKOFF = time.Millisecond * 250
+
+	BUDGET_RATIO          = 0.2
+	BUDGET_MIN_PER_SECOND = 10
+	BUDGET_WINDOW         = 10
+)
+
+var (
+	DEFAULT_CONDITIONS = []Condition{CONDITION_CONNECT, CONDITION_RESET, CONDITION_NO_RESPONDERS}
+	IDEMPOTENT_METHODS = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete}
+)
+
+func ParseCondition(condition string) (Condition, error) {
+	switch strings.ToLower(condition) {
+	case string(CONDITION_CONNECT):
+		{
+			return CONDITION_CONNECT, nil
+		}
+	case string(CONDITION_RESET):
+		{
+			return CONDITION_RESET, nil
+		}
+	case string(CONDITION_TIMEOUT):
+		{
+			return CONDITION_TIMEOUT, nil
+		}
+	case strings.ToLower(string(CONDITION_NO_RESPONDERS)):
+		{
+			return CONDITION_NO_RESPONDERS, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported retry condition %s", condition)
+}
+
+func NewPolicy(policy *Policy) *Policy {
+	if policy.Attempts <= 0 {
+		policy.Attempts = ATTEMPTS
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = BACKOFF
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = MAX_BACKOFF
+	}
+	if policy.MaxBackoff < policy.Backoff {
+		policy.MaxBackoff = policy.Backoff
+	}
+	if policy.Conditions == nil && policy.Statuses == nil {
+		policy.Conditions = DEFAULT_CONDITIONS
+	}
+	if policy.Methods == nil {
+		policy.Methods = IDEMPOTENT_METHODS
+	}
+	return policy
+}
+
+// Do runs call, retrying it as the policy allows. Every attempt gets a fresh
+// request from c. A nil policy runs call once.
+func (policy *Policy) Do(ctx context.Context, name string, c netio.Cloner, call Call) (netio.Next, *http.Response, netio.Error) {
+	if policy == nil || policy.Attempts <= 1 {
+		return call(ctx, c)
+	}
+	policy.Budget.deposit()
+	method, replayable, cloned := "", true, false
+	cloner := func(options ...netio.RequestOption) (*http.Request, error) {
+		r, err := c(options...)
+		if err == nil && !cloned {
+			method, replayable, cloned = r.Method, netio.Replayable(r), true
+		}
+		return r, err
+	}
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := policy.context(ctx)
+		next, res, err := call(attemptCtx, cloner)
+		res = netio.CancelOnClose(res, cancel)
+		if attempt >= policy.Attempts || !replayable || ctx.Err() != nil {
+			return next, res, err
+		}
+		reason, delivered := policy.reason(res, err)
+		if len(reason) == 0 || (delivered && !policy.allows(method)) {
+			return next, res, err
+		}
+		if !policy.Budget.withdraw() {
+			metrics.RetryBudgetExhausted.WithLabelValues(name).Inc()
+			return next, res, err
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+		metrics.Retries.WithLabelValues(name, reason).Inc()
+		select {
+		case <-ctx.Done():
+			{
+				return netio.TERM, nil, netio.NewTransportError(ctx.Err())
+			}
+		case <-time.After(policy.backoff(attempt)):
+		}
+	}
+}
+
+func (policy *Policy) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if policy.PerTryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return netio.WithTimeout(ctx, policy.PerTryTimeout)
+}
+
+// reason tells why a failed attempt may be retried, and whether the backend
+// may have seen it. An empty reason means the attempt must not be retried.
+func (policy *Policy) reason(res *http.Response, err netio.Error) (string, bool) {
+	if err == nil {
+		if res != nil && policy.retries(res.StatusCode) {
+			return strconv.Itoa(res.StatusCode), true
+		}
+		return "", false
+	}
+	cause := netio.Cause(err)
+	switch err.Kind() {
+	case netio.ERROR_KIND_TRANSPORT:
+		{
+			var opErr *net.OpError
+			switch {
+			case errors.Is(cause, nats.ErrNoResponders):
+				{
+					return policy.on(CONDITION_NO_RESPONDERS), false
+				}
+			case errors.As(cause, &opErr) && opErr.Op == "dial":
+				{
+					return policy.on(CONDITION_CONNECT), false
+				}
+			}
+			return policy.on(CONDITION_RESET), true
+		}
+	case netio.ERROR_KIND_TIMEOUT:
+		{
+			return policy.on(CONDITION_TIMEOUT), true
+		}
+	case netio.ERROR_KIND_STATUS:
+		{
+			if policy.retries(err.Status()) {
+				return strconv.Itoa(err.Status()), true
+			}
+		}
+	}
+	return "", false
+}
+
+func (policy *Policy) on(condition Condition) string {
+	for _, value := range policy.Conditions {
+		if value == condition {
+			return string(condition)
+		}
+	}
+	return ""
+}
+
+func (policy *Policy) retries(status int) bool {
+	for _, value := range policy.Statuses {
+		if value == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy *Policy) allows(method string) bool {
+	for _, value := range policy.Methods {
+		if value == "*" || strings.EqualFold(value, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy *Policy) backoff(attempt int) time.Duration {
+	backoff := policy.MaxBackoff
+	if shift := attempt - 1; shift < 32 && policy.Backoff<<shift < policy.MaxBackoff {
+		backoff = policy.Backoff << shift
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+func (budget *Budget) deposit() {
+	if budget == nil {
+		return
+	}
+	budget.mut.Lock()
+	defer budget.mut.Unlock()
+	budget.bucket(time.Now().Unix()).calls++
+}
+
+func (budget *Budget) withdraw() bool {
+	if budget == nil {
+		return true
+	}
+	budget.mut.Lock()
+	defer budget.mut.Unlock()
+	now := time.Now().Unix()
+	calls, retries := 0, 0
+	for _, bucket := range budget.buckets {
+		if bucket.second > now-BUDGET_WINDOW {
+			calls += bucket.calls
+			retries += bucket.retries
+		}
+	}
+	if float64(retries) >= budget.Ratio*float64(calls)+float64(budget.MinPerSecond*BUDGET_WINDOW) {
+		return false
+	}
+	budget.bucket(now).retries++
+	return true
+}
+
+func (budget *Budget) bucket(second int64) *bucket {
+	bucket := &budget.buckets[second%BUDGET_WINDOW]
+	if bucket.second != second {
+		bucket.second = second
+		bucket.calls = 0
+		bucket.retries = 0
+	}
+	return bucket
+}
@@ -0,0 +1,186 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/vedadiyan/iceberg/internal/common/netio"
+)
+
+type (
+	attempt struct {
+		status int
+		err    netio.Error
+	}
+)
+
+var (
+	_dial    = netio.NewTransportError(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")})
+	_reset   = netio.NewTransportError(&net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")})
+	_timeout = netio.NewTimeoutError(context.DeadlineExceeded.Error())
+	_nats    = netio.NewTransportError(nats.ErrNoResponders)
+)
+
+func request(t *testing.T, method string, streamed bool) netio.Cloner {
+	t.Helper()
+	var body *netio.Body
+	if streamed {
+		body = netio.NewSpool(0, 0, true).Stream(io.NopCloser(strings.NewReader("payload")))
+	} else {
+		body = netio.NewBody([]byte("payload"))
+	}
+	return func(...netio.RequestOption) (*http.Request, error) {
+		r, err := http.NewRequest(method, "http://backend/", body.Reader())
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+}
+
+func TestDo(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     *Policy
+		method     string
+		streamed   bool
+		attempts   []attempt
+		wantCalls  int
+		wantStatus int
+		wantErr    netio.Error
+	}{
+		{name: "no policy", method: "GET", attempts: []attempt{{err: _dial}}, wantCalls: 1, wantErr: _dial},
+		{name: "success", policy: &Policy{}, method: "GET", attempts: []attempt{{status: 200}}, wantCalls: 1, wantStatus: 200},
+		{name: "connect", policy: &Policy{}, method: "POST", attempts: []attempt{{err: _dial}, {err: _dial}, {status: 200}}, wantCalls: 3, wantStatus: 200},
+		{name: "attempts exhausted", policy: &Policy{Attempts: 2}, method: "GET", attempts: []attempt{{err: _dial}, {err: _dial}, {status: 200}}, wantCalls: 2, wantErr: _dial},
+		{name: "reset on idempotent method", policy: &Policy{}, method: "PUT", attempts: []attempt{{err: _reset}, {status: 200}}, wantCalls: 2, wantStatus: 200},
+		{name: "reset on non-idempotent method", policy: &Policy{}, method: "POST", attempts: []attempt{{err: _reset}, {status: 200}}, wantCalls: 1, wantErr: _reset},
+		{name: "reset on allowed method", policy: &Policy{Methods: []string{"*"}}, method: "POST", attempts: []attempt{{err: _reset}, {status: 200}}, wantCalls: 2, wantStatus: 200},
+		{name: "no responders", policy: &Policy{}, method: "POST", attempts: []attempt{{err: _nats}, {status: 200}}, wantCalls: 2, wantStatus: 200},
+		{name: "timeout not retried by default", policy: &Policy{}, method: "GET", attempts: []attempt{{err: _timeout}, {status: 200}}, wantCalls: 1, wantErr: _timeout},
+		{name: "timeout", policy: &Policy{Conditions: []Condition{CONDITION_TIMEOUT}}, method: "GET", attempts: []attempt{{err: _timeout}, {status: 200}}, wantCalls: 2, wantStatus: 200},
+		{name: "status", policy: &Policy{Statuses: []int{503}}, method: "GET", attempts: []attempt{{status: 503}, {status: 200}}, wantCalls: 2, wantStatus: 200},
+		{name: "status error", policy: &Policy{Statuses: []int{503}}, method: "GET", attempts: []attempt{{err: netio.NewStatusError("503 Service Unavailable", 503)}, {status: 200}}, wantCalls: 2, wantStatus: 200},
+		{name: "status not listed", policy: &Policy{Statuses: []int{503}}, method: "GET", attempts: []attempt{{status: 500}, {status: 200}}, wantCalls: 1, wantStatus: 500},
+		{name: "statuses replace default conditions", policy: &Policy{Statuses: []int{503}}, method: "GET", attempts: []attempt{{err: _dial}, {status: 200}}, wantCalls: 1, wantErr: _dial},
+		{name: "streamed body", policy: &Policy{}, method: "GET", streamed: true, attempts: []attempt{{err: _dial}, {status: 200}}, wantCalls: 1, wantErr: _dial},
+		{name: "buffered body", policy: &Policy{}, method: "GET", attempts: []attempt{{err: _dial}, {status: 200}}, wantCalls: 2, wantStatus: 200},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := test.policy
+			if policy != nil {
+				policy.Backoff = time.Microsecond
+				policy.Budget = &Budget{Ratio: BUDGET_RATIO, MinPerSecond: BUDGET_MIN_PER_SECOND}
+				policy = NewPolicy(policy)
+			}
+			calls := 0
+			_, res, err := policy.Do(context.Background(), "test", request(t, test.method, test.streamed), func(ctx context.Context, c netio.Cloner) (netio.Next, *http.Response, netio.Error) {
+				r, cloneErr := c()
+				if cloneErr != nil {
+					t.Fatalf("unexpected error %v", cloneErr)
+				}
+				io.Copy(io.Discard, r.Body)
+				attempt := test.attempts[calls]
+				calls++
+				if attempt.err != nil {
+					return netio.TERM, nil, attempt.err
+				}
+				return netio.CONTINUE, &http.Response{StatusCode: attempt.status, Body: http.NoBody}, nil
+			})
+			if calls != test.wantCalls {
+				t.Fatalf("expected %d calls, got %d", test.wantCalls, calls)
+			}
+			if err != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if test.wantErr == nil && res.StatusCode != test.wantStatus {
+				t.Fatalf("expected status %d, got %d", test.wantStatus, res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestDoBudget(t *testing.T) {
+	tests := []struct {
+		name      string
+		budget    *Budget
+		wantCalls int
+	}{
+		{name: "exhausted", budget: &Budget{}, wantCalls: 1},
+		{name: "no budget", wantCalls: ATTEMPTS},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			_, _, err := NewPolicy(&Policy{Backoff: time.Microsecond, Budget: test.budget}).Do(context.Background(), "test", request(t, "GET", false), func(ctx context.Context, c netio.Cloner) (netio.Next, *http.Response, netio.Error) {
+				calls++
+				return netio.TERM, nil, _dial
+			})
+			if calls != test.wantCalls || err != _dial {
+				t.Fatalf("expected %d calls failing with %v, got %d calls and %v", test.wantCalls, _dial, calls, err)
+			}
+		})
+	}
+}
+
+func TestBudget(t *testing.T) {
+	tests := []struct {
+		name         string
+		ratio        float64
+		minPerSecond int
+		calls        int
+		want         int
+	}{
+		{name: "ratio", ratio: 0.2, calls: 50, want: 10},
+		{name: "minimum", minPerSecond: 1, want: BUDGET_WINDOW},
+		{name: "ratio and minimum", ratio: 0.5, minPerSecond: 1, calls: 10, want: 5 + BUDGET_WINDOW},
+		{name: "empty", want: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			budget := &Budget{Ratio: test.ratio, MinPerSecond: test.minPerSecond}
+			for i := 0; i < test.calls; i++ {
+				budget.deposit()
+			}
+			retries := 0
+			for budget.withdraw() {
+				retries++
+				if retries > test.want {
+					break
+				}
+			}
+			if retries != test.want {
+				t.Fatalf("expected %d retries, got %d", test.want, retries)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := NewPolicy(&Policy{Backoff: time.Millisecond, MaxBackoff: time.Millisecond * 10})
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: time.Millisecond},
+		{attempt: 2, want: time.Millisecond * 2},
+		{attempt: 4, want: time.Millisecond * 8},
+		{attempt: 5, want: time.Millisecond * 10},
+		{attempt: 64, want: time.Millisecond * 10},
+	}
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			if backoff := policy.backoff(test.attempt); backoff < 0 || backoff > test.want {
+				t.Fatalf("expected attempt %d to wait at most %s, got %s", test.attempt, test.want, backoff)
+			}
+		}
+	}
+}
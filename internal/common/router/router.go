@@ -2,176 +2,749 @@ package router
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
+	"fmt"
 	"net/http"
 	"net/url"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 type (
 	RouterError string
 	RouteValues map[string]string
 	HandlerFunc func(http.ResponseWriter, *http.Request, RouteValues)
-	RouteTable  struct {
-		routes  map[int][]*Route
-		configs map[string]HandlerFunc
+	SegmentKind int
+	// RouteTable is safe for concurrent use. Registrations take a write lock
+	// and lookups a read lock, so a table can be extended while serving.
+	RouteTable struct {
+		mut       sync.RWMutex
+		trees     [3]*node
+		routes    []*Route
+		hashes    map[string]*Route
+		defaults  *RouteTable
+		isDefault bool
 	}
 	Route struct {
 		path        string
 		method      string
-		routeValues map[int]string
-		routeParams map[int]string
+		isDefault   bool
+		segments    []Segment
+		params      []Segment
+		predicates  *Predicates
 		hash        string
+		handlerFunc HandlerFunc
+		metadata    atomic.Pointer[any]
+	}
+	Predicates struct {
+		Host    string            `json:"host,omitempty"`
+		Headers map[string]string `json:"headers,omitempty"`
+		Query   map[string]string `json:"query,omitempty"`
+		Cookies map[string]string `json:"cookies,omitempty"`
+	}
+	Segment struct {
+		Kind       SegmentKind
+		Value      string
+		Constraint *regexp.Regexp
+	}
+	// node is a vertex of the compressed segment trie. Runs of static
+	// segments are merged into a single prefix, while each distinct kind and
+	// constraint of dynamic segment gets a child of its own so that routes
+	// differing only in parameter names share a path.
+	node struct {
+		prefix  string
+		segment Segment
+		static  map[string]*node
+		dynamic []*node
+		routes  []*Route
+	}
+	search struct {
+		r       *http.Request
+		method  string
+		values  []string
+		allowed bool
+		collect bool
+		methods []string
 	}
 )
 
 const (
 	NO_MATCH_FOUND    RouterError = "no match found"
 	NO_URL_REGISTERED RouterError = "no url registered"
+	NOT_ALLOWED       RouterError = "method not allowed"
+
+	SEGMENT_STATIC   SegmentKind = 1
+	SEGMENT_PARAM    SegmentKind = 2
+	SEGMENT_OPTIONAL SegmentKind = 3
+	SEGMENT_CATCHALL SegmentKind = 4
+
+	CATCHALL = "*"
 )
 
 var (
-	_routeTable RouteTable
-	_once       sync.Once
+	_constraints = map[string]*regexp.Regexp{
+		"int":   regexp.MustCompile(`^-?[0-9]+$`),
+		"uint":  regexp.MustCompile(`^[0-9]+$`),
+		"uuid":  regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`),
+		"alpha": regexp.MustCompile(`^[a-zA-Z]+$`),
+		"alnum": regexp.MustCompile(`^[a-zA-Z0-9]+$`),
+		"hex":   regexp.MustCompile(`^[0-9a-fA-F]+$`),
+	}
 )
 
 func (routerError RouterError) Error() string {
 	return string(routerError)
 }
 
-func (r *Route) Bind(route *Route) map[string]string {
-	rank := RouteCompare(r, route)
-	if rank == 0 {
-		return nil
-	}
-	routeValues := make(map[string]string)
-	for key, value := range r.routeValues {
-		k := r.routeValues[key]
-		if value == "?" {
-			k = r.routeParams[key]
+// Values maps the parameter values captured by Lookup to their names. A
+// missing optional parameter is left out and a missing catch-all is empty.
+func (route *Route) Values(values []string) RouteValues {
+	routeValues := make(RouteValues, len(route.params))
+	for index, param := range route.params {
+		if index < len(values) {
+			routeValues[param.Value] = values[index]
+			continue
+		}
+		if param.Kind == SEGMENT_CATCHALL {
+			routeValues[param.Value] = ""
 		}
-		routeValues[k] = route.routeValues[key]
 	}
 	return routeValues
 }
 
+func (route *Route) Handle(w http.ResponseWriter, r *http.Request, values []string) {
+	route.handlerFunc(w, r, route.Values(values))
+}
+
 func (route *Route) GetHash() string {
 	return route.hash
 }
 
-func ParseRoute(url *url.URL, method string) *Route {
-	routeValues := make(map[int]string)
-	routeParams := make(map[int]string)
-	for index, segment := range strings.Split(url.Path, "/") {
+func (route *Route) GetPath() string {
+	return route.path
+}
+
+func (route *Route) GetMethod() string {
+	return route.method
+}
+
+func (route *Route) IsDefault() bool {
+	return route.isDefault
+}
+
+func (route *Route) GetPredicates() *Predicates {
+	return route.predicates
+}
+
+func (route *Route) GetMetadata() any {
+	metadata := route.metadata.Load()
+	if metadata == nil {
+		return nil
+	}
+	return *metadata
+}
+
+func (route *Route) SetMetadata(metadata any) {
+	route.metadata.Store(&metadata)
+}
+
+func Split(path string) []string {
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(path, "/") {
 		if len(segment) == 0 {
 			continue
 		}
-		if strings.HasPrefix(segment, ":") {
-			routeValues[index] = "?"
-			routeParams[index] = segment[1:]
-			continue
+		segments = append(segments, segment)
+	}
+	return segments
+}
+
+func ParseSegment(segment string) (Segment, error) {
+	switch {
+	case strings.HasPrefix(segment, ":"):
+		{
+			kind := SEGMENT_PARAM
+			name := segment[1:]
+			if strings.HasSuffix(name, "?") {
+				kind = SEGMENT_OPTIONAL
+				name = name[:len(name)-1]
+			}
+			name, constraint, err := ParseConstraint(name)
+			if err != nil {
+				return Segment{}, err
+			}
+			return Segment{Kind: kind, Value: name, Constraint: constraint}, nil
+		}
+	case strings.HasPrefix(segment, CATCHALL):
+		{
+			name := segment[1:]
+			if len(name) == 0 {
+				name = CATCHALL
+			}
+			return Segment{Kind: SEGMENT_CATCHALL, Value: name}, nil
 		}
-		routeValues[index] = segment
-		routeValues[index] = segment
 	}
+	return Segment{Kind: SEGMENT_STATIC, Value: segment}, nil
+}
 
-	hash := CreateHash(url, method)
+func ParseConstraint(param string) (string, *regexp.Regexp, error) {
+	start := strings.Index(param, "<")
+	if start == -1 || !strings.HasSuffix(param, ">") {
+		return param, nil, nil
+	}
+	name := param[:start]
+	expression := param[start+1 : len(param)-1]
+	if constraint, ok := _constraints[expression]; ok {
+		return name, constraint, nil
+	}
+	constraint, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", expression))
+	if err != nil {
+		return "", nil, RouterError(fmt.Sprintf("invalid constraint <%s> on %s: %s", expression, name, err.Error()))
+	}
+	return name, constraint, nil
+}
+
+func (segment Segment) rank() int {
+	rank := int(segment.Kind) * 2
+	if segment.Constraint == nil {
+		rank++
+	}
+	return rank
+}
+
+func (segment Segment) constraint() string {
+	if segment.Constraint == nil {
+		return ""
+	}
+	return segment.Constraint.String()
+}
+
+func (segment Segment) Matches(value string) bool {
+	if segment.Constraint == nil {
+		return true
+	}
+	return segment.Constraint.MatchString(value)
+}
+
+func ParseRoute(url *url.URL, method string, predicates *Predicates) (*Route, error) {
+	segments := make([]Segment, 0)
+	params := make([]Segment, 0)
+	for _, value := range Split(url.Path) {
+		segment, err := ParseSegment(value)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+		if segment.Kind != SEGMENT_STATIC {
+			params = append(params, segment)
+		}
+	}
+	hash := CreateHash(url, method, predicates)
 	route := Route{
-		path:        url.Path,
-		routeValues: routeValues,
-		routeParams: routeParams,
-		method:      strings.ToUpper(method),
-		hash:        hash,
+		path:       url.Path,
+		segments:   segments,
+		params:     params,
+		predicates: predicates,
+		method:     strings.ToUpper(method),
+		hash:       hash,
 	}
-	return &route
+	return &route, nil
 }
 
+func ValidateRoute(path string) error {
+	segments := Split(path)
+	optional := false
+	for index, value := range segments {
+		segment, err := ParseSegment(value)
+		if err != nil {
+			return err
+		}
+		switch segment.Kind {
+		case SEGMENT_CATCHALL:
+			{
+				if index != len(segments)-1 {
+					return RouterError("catch-all segment must be the last segment in " + path)
+				}
+			}
+		case SEGMENT_OPTIONAL:
+			{
+				optional = true
+			}
+		default:
+			{
+				if optional {
+					return RouterError("optional segments must be trailing in " + path)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// RouteCompare orders two routes that match the same request. An exact host
+// beats a wildcard host, which beats no host predicate. Then segments are
+// compared left to right and the first difference decides: a route that has
+// already ended beats one that goes on, static beats :param, a constrained
+// :param beats an unconstrained one, :param beats an optional :param? and
+// every one of them beats a catch-all. Remaining ties go to the route with
+// more header, query and cookie predicates. It returns a negative number when
+// preferredRoute takes precedence.
 func RouteCompare(preferredRoute *Route, route *Route) int {
-	if len(preferredRoute.routeValues) != len(route.routeValues) {
-		return 0
+	if rank, other := preferredRoute.predicates.hostRank(), route.predicates.hostRank(); rank != other {
+		return other - rank
 	}
-	rank := 1
-	for key, value := range preferredRoute.routeValues {
-		if value == "?" {
-			rank += 1
-			continue
+	for index := 0; index < len(preferredRoute.segments) || index < len(route.segments); index++ {
+		rank, other := 0, 0
+		if index < len(preferredRoute.segments) {
+			rank = preferredRoute.segments[index].rank()
+		}
+		if index < len(route.segments) {
+			other = route.segments[index].rank()
 		}
-		if value != route.routeValues[key] {
-			rank = 0
-			break
+		if rank != other {
+			return rank - other
 		}
-		rank += 2
 	}
-	return rank
+	return route.predicates.count() - preferredRoute.predicates.count()
 }
 
-func CreateHash(url *url.URL, method string) string {
+func CreateHash(url *url.URL, method string, predicates *Predicates) string {
 	buffer := bytes.NewBufferString(strings.ToUpper(method))
 	buffer.WriteString(":")
 	buffer.WriteString(url.Path)
-	sha256 := sha256.New()
-	sha256.Write(buffer.Bytes())
-	hash := hex.EncodeToString(sha256.Sum(nil))
-	return hash
+	if predicates != nil {
+		buffer.WriteString("@")
+		buffer.WriteString(predicates.String())
+	}
+	return buffer.String()
+}
+
+func NewRouteTable() *RouteTable {
+	return &RouteTable{
+		routes: make([]*Route, 0),
+		hashes: make(map[string]*Route),
+	}
+}
+
+// Register adds a route to the table. Registering the same method, path and
+// predicates again returns the route registered first.
+func (rt *RouteTable) Register(url *url.URL, method string, predicates *Predicates, handlerFunc HandlerFunc) (*Route, error) {
+	route, err := ParseRoute(url, method, predicates)
+	if err != nil {
+		return nil, err
+	}
+	rt.mut.Lock()
+	defer rt.mut.Unlock()
+	if registered, ok := rt.hashes[route.hash]; ok {
+		return registered, nil
+	}
+	route.isDefault = rt.isDefault
+	route.handlerFunc = handlerFunc
+	rt.hashes[route.hash] = route
+	rt.routes = append(rt.routes, route)
+	tier := route.predicates.hostRank()
+	if rt.trees[tier] == nil {
+		rt.trees[tier] = new(node)
+	}
+	rt.trees[tier].insert(route.segments, route)
+	return route, nil
 }
 
-func DefaultRouteTable() *RouteTable {
-	_once.Do(func() {
-		_routeTable = RouteTable{
-			routes:  map[int][]*Route{},
-			configs: make(map[string]HandlerFunc),
+// Default returns the table consulted when no route in rt matches the
+// request path, creating it on first use.
+func (rt *RouteTable) Default() *RouteTable {
+	rt.mut.Lock()
+	defer rt.mut.Unlock()
+	if rt.defaults == nil {
+		rt.defaults = NewRouteTable()
+		rt.defaults.isDefault = true
+	}
+	return rt.defaults
+}
+
+func (rt *RouteTable) Routes() []*Route {
+	rt.mut.RLock()
+	routes := make([]*Route, 0)
+	routes = append(routes, rt.routes...)
+	defaults := rt.defaults
+	rt.mut.RUnlock()
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].path != routes[j].path {
+			return routes[i].path < routes[j].path
 		}
+		return routes[i].method < routes[j].method
 	})
-	return &_routeTable
+	if defaults != nil {
+		routes = append(routes, defaults.Routes()...)
+	}
+	return routes
 }
 
-func (rt *RouteTable) Register(url *url.URL, method string, handlerFunc HandlerFunc) {
-	route := ParseRoute(url, method)
-	len := len(route.routeValues)
-	if _, ok := rt.configs[route.hash]; ok {
-		return
+// Lookup returns the most specific route matching the request along with the
+// values of its parameters appended to values. It does not allocate unless
+// values runs out of capacity or the route has query or cookie predicates.
+// When routes match the path but not the method it returns NOT_ALLOWED, and
+// when nothing matches the path the default table, if any, is searched.
+func (rt *RouteTable) Lookup(r *http.Request, values []string) (*Route, []string, error) {
+	rt.mut.RLock()
+	route, values, err := rt.lookup(r, values)
+	defaults := rt.defaults
+	rt.mut.RUnlock()
+	if err == NO_MATCH_FOUND && defaults != nil {
+		return defaults.Lookup(r, values)
+	}
+	return route, values, err
+}
+
+func (rt *RouteTable) lookup(r *http.Request, values []string) (*Route, []string, error) {
+	if len(rt.routes) == 0 && rt.defaults == nil {
+		return nil, values, NO_URL_REGISTERED
+	}
+	search := search{
+		r:      r,
+		method: strings.ToUpper(r.Method),
+		values: values,
+	}
+	for tier := len(rt.trees) - 1; tier >= 0; tier-- {
+		if rt.trees[tier] == nil {
+			continue
+		}
+		if route := rt.trees[tier].lookup(r.URL.Path, &search); route != nil {
+			return route, search.values, nil
+		}
+	}
+	if search.allowed {
+		return nil, values, NOT_ALLOWED
+	}
+	return nil, values, NO_MATCH_FOUND
+}
+
+func (rt *RouteTable) Find(r *http.Request) (http.HandlerFunc, error) {
+	route, values, err := rt.Lookup(r, nil)
+	if err != nil {
+		return nil, err
+	}
+	routeValues := route.Values(values)
+	return func(w http.ResponseWriter, r *http.Request) {
+		route.handlerFunc(w, r, routeValues)
+	}, nil
+}
+
+// Allow lists the methods of the routes matching the request regardless of
+// its method, for the Allow header of a 405 response.
+func (rt *RouteTable) Allow(r *http.Request) []string {
+	rt.mut.RLock()
+	search := search{
+		r:       r,
+		collect: true,
+		methods: make([]string, 0),
+	}
+	for _, tree := range rt.trees {
+		if tree != nil {
+			tree.lookup(r.URL.Path, &search)
+		}
 	}
-	rt.configs[route.hash] = handlerFunc
-	_, ok := rt.routes[len]
+	defaults := rt.defaults
+	rt.mut.RUnlock()
+	if len(search.methods) == 0 && defaults != nil {
+		return defaults.Allow(r)
+	}
+	sort.Strings(search.methods)
+	return search.methods
+}
+
+func (rt *RouteTable) GetHandlerFunc(hash string) HandlerFunc {
+	rt.mut.RLock()
+	defer rt.mut.RUnlock()
+	route, ok := rt.hashes[hash]
 	if !ok {
-		rt.routes[len] = make([]*Route, 0)
+		return nil
 	}
-	rt.routes[len] = append(rt.routes[len], route)
+	return route.handlerFunc
 }
 
-func (rt RouteTable) Find(url *url.URL, method string) (http.HandlerFunc, error) {
-	if len(rt.routes) == 0 {
-		return nil, NO_URL_REGISTERED
+func (n *node) insert(segments []Segment, route *Route) {
+	if len(segments) == 0 {
+		n.add(route)
+		return
 	}
-	prt := ParseRoute(url, method)
-	routes, ok := rt.routes[len(prt.routeValues)]
+	segment := segments[0]
+	switch segment.Kind {
+	case SEGMENT_STATIC:
+		{
+			end := 1
+			for end < len(segments) && segments[end].Kind == SEGMENT_STATIC {
+				end++
+			}
+			values := make([]string, 0, end)
+			for _, segment := range segments[:end] {
+				values = append(values, segment.Value)
+			}
+			n.insertStatic(values, segments[end:], route)
+		}
+	case SEGMENT_OPTIONAL:
+		{
+			n.add(route)
+			n.child(segment).insert(segments[1:], route)
+		}
+	default:
+		{
+			n.child(segment).insert(segments[1:], route)
+		}
+	}
+}
+
+func (n *node) insertStatic(values []string, segments []Segment, route *Route) {
+	if n.static == nil {
+		n.static = make(map[string]*node)
+	}
+	child, ok := n.static[values[0]]
 	if !ok {
-		return nil, NO_MATCH_FOUND
+		child = &node{prefix: strings.Join(values, "/")}
+		n.static[values[0]] = child
+		child.insert(segments, route)
+		return
+	}
+	prefix := strings.Split(child.prefix, "/")
+	common := 0
+	for common < len(prefix) && common < len(values) && prefix[common] == values[common] {
+		common++
+	}
+	if common < len(prefix) {
+		split := &node{
+			prefix: strings.Join(prefix[:common], "/"),
+			static: map[string]*node{prefix[common]: child},
+		}
+		child.prefix = strings.Join(prefix[common:], "/")
+		n.static[values[0]] = split
+		child = split
+	}
+	if common < len(values) {
+		child.insertStatic(values[common:], segments, route)
+		return
+	}
+	child.insert(segments, route)
+}
+
+func (n *node) child(segment Segment) *node {
+	for _, child := range n.dynamic {
+		if child.segment.Kind == segment.Kind && child.segment.constraint() == segment.constraint() {
+			return child
+		}
+	}
+	child := &node{segment: segment}
+	n.dynamic = append(n.dynamic, child)
+	sort.SliceStable(n.dynamic, func(i, j int) bool {
+		return n.dynamic[i].segment.rank() < n.dynamic[j].segment.rank()
+	})
+	return child
+}
+
+func (n *node) add(route *Route) {
+	n.routes = append(n.routes, route)
+	sort.SliceStable(n.routes, func(i, j int) bool {
+		return RouteCompare(n.routes[i], n.routes[j]) < 0
+	})
+}
+
+// lookup walks the trie depth first, visiting the routes ending at a node
+// before its static children and those before its dynamic children in rank
+// order, so the first route found is the one RouteCompare prefers.
+func (n *node) lookup(path string, search *search) *Route {
+	path = strings.TrimLeft(path, "/")
+	if len(path) == 0 {
+		if route := search.match(n.routes); route != nil {
+			return route
+		}
+	} else if n.static != nil {
+		if child, ok := n.static[head(path)]; ok {
+			if rest, ok := consume(path, child.prefix); ok {
+				if route := child.lookup(rest, search); route != nil {
+					return route
+				}
+			}
+		}
 	}
-	lrnk := 0
-	var lrt *Route
-	for _, url := range routes {
-		if url.method != strings.ToUpper(method) {
+	for _, child := range n.dynamic {
+		if child.segment.Kind == SEGMENT_CATCHALL {
+			if route := search.match(child.routes); route != nil {
+				search.values = append(search.values, strings.TrimRight(path, "/"))
+				return route
+			}
 			continue
 		}
-		rnk := RouteCompare(url, prt)
-		if rnk != 0 {
-			if rnk > lrnk {
-				lrnk = rnk
-				lrt = url
+		if len(path) == 0 {
+			continue
+		}
+		value := head(path)
+		if !child.segment.Matches(value) {
+			continue
+		}
+		search.values = append(search.values, value)
+		if route := child.lookup(path[len(value):], search); route != nil {
+			return route
+		}
+		search.values = search.values[:len(search.values)-1]
+	}
+	return nil
+}
+
+func (search *search) match(routes []*Route) *Route {
+	for _, route := range routes {
+		if !route.predicates.Matches(search.r) {
+			continue
+		}
+		if search.collect {
+			if !contains(search.methods, route.method) {
+				search.methods = append(search.methods, route.method)
 			}
+			continue
+		}
+		if route.method != search.method {
+			search.allowed = true
+			continue
 		}
+		return route
 	}
-	if lrnk == 0 {
-		return nil, NO_MATCH_FOUND
+	return nil
+}
+
+func head(path string) string {
+	if index := strings.IndexByte(path, '/'); index != -1 {
+		return path[:index]
 	}
-	return func(w http.ResponseWriter, r *http.Request) {
-		rt.GetHandlerFunc(lrt.hash)(w, r, lrt.Bind(prt))
-	}, nil
+	return path
 }
 
-func (rt RouteTable) GetHandlerFunc(hash string) HandlerFunc {
-	return rt.configs[hash]
+func consume(path string, prefix string) (string, bool) {
+	for len(prefix) != 0 {
+		path = strings.TrimLeft(path, "/")
+		segment := head(prefix)
+		if head(path) != segment {
+			return "", false
+		}
+		path = path[len(segment):]
+		prefix = strings.TrimPrefix(prefix[len(segment):], "/")
+	}
+	return path, true
+}
+
+func contains(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (predicates *Predicates) Matches(r *http.Request) bool {
+	if predicates == nil {
+		return true
+	}
+	if len(predicates.Host) != 0 && !MatchHost(predicates.Host, r.Host) {
+		return false
+	}
+	for key, pattern := range predicates.Headers {
+		if !matchAny(pattern, r.Header.Values(key)) {
+			return false
+		}
+	}
+	if len(predicates.Query) != 0 {
+		query := r.URL.Query()
+		for key, pattern := range predicates.Query {
+			if !matchAny(pattern, query[key]) {
+				return false
+			}
+		}
+	}
+	for key, pattern := range predicates.Cookies {
+		cookie, err := r.Cookie(key)
+		if err != nil || !Glob(pattern, cookie.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (predicates *Predicates) String() string {
+	if predicates == nil {
+		return ""
+	}
+	buffer := bytes.NewBufferString(strings.ToLower(predicates.Host))
+	for _, group := range []map[string]string{predicates.Headers, predicates.Query, predicates.Cookies} {
+		keys := make([]string, 0, len(group))
+		for key := range group {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buffer.WriteString(";")
+		for _, key := range keys {
+			buffer.WriteString(fmt.Sprintf("%s=%s,", key, group[key]))
+		}
+	}
+	return buffer.String()
+}
+
+func (predicates *Predicates) hostRank() int {
+	if predicates == nil || len(predicates.Host) == 0 {
+		return 0
+	}
+	if strings.HasPrefix(predicates.Host, "*.") {
+		return 1
+	}
+	return 2
+}
+
+func (predicates *Predicates) count() int {
+	if predicates == nil {
+		return 0
+	}
+	return len(predicates.Headers) + len(predicates.Query) + len(predicates.Cookies)
+}
+
+func MatchHost(pattern string, host string) bool {
+	if index := strings.LastIndexByte(host, ':'); index != -1 && strings.IndexByte(host[index:], ']') == -1 {
+		host = host[:index]
+	}
+	host = strings.TrimSuffix(host, ".")
+	if strings.HasPrefix(pattern, "*.") {
+		suffix := pattern[1:]
+		return len(host) > len(suffix) && strings.EqualFold(host[len(host)-len(suffix):], suffix)
+	}
+	return strings.EqualFold(host, pattern)
+}
+
+func Glob(pattern string, value string) bool {
+	prefix, rest, found := strings.Cut(pattern, "*")
+	if !found {
+		return pattern == value
+	}
+	if !strings.HasPrefix(value, prefix) {
+		return false
+	}
+	value = value[len(prefix):]
+	for {
+		part, next, found := strings.Cut(rest, "*")
+		if !found {
+			return strings.HasSuffix(value, part)
+		}
+		index := strings.Index(value, part)
+		if index == -1 {
+			return false
+		}
+		value = value[index+len(part):]
+		rest = next
+	}
+}
+
+func matchAny(pattern string, values []string) bool {
+	for _, value := range values {
+		if Glob(pattern, value) {
+			return true
+		}
+	}
+	return false
 }
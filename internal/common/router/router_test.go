@@ -0,0 +1,462 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+const (
+	CATALOGUE_SERVICES = 40
+)
+
+var (
+	_catalogue = []string{
+		"/api/v1/%s",
+		"/api/v1/%s/items",
+		"/api/v1/%s/items/:id<uuid>",
+		"/api/v1/%s/items/:id<uuid>/comments",
+		"/api/v1/%s/items/:id<uuid>/comments/:comment<int>",
+		"/api/v1/%s/items/:slug",
+		"/api/v1/%s/search/:term?",
+		"/api/v1/%s/files/*path",
+	}
+	_methods = []string{"GET", "POST", "PUT", "DELETE"}
+)
+
+func catalogue() *RouteTable {
+	rt := NewRouteTable()
+	handlerFunc := func(http.ResponseWriter, *http.Request, RouteValues) {}
+	for service := 0; service < CATALOGUE_SERVICES; service++ {
+		for _, pattern := range _catalogue {
+			path := fmt.Sprintf(pattern, fmt.Sprintf("service%d", service))
+			for _, method := range _methods {
+				rt.Register(&url.URL{Path: path}, method, nil, handlerFunc)
+			}
+		}
+	}
+	rt.Register(&url.URL{Path: "/api/*"}, "GET", &Predicates{Host: "tenant-a.example.com"}, handlerFunc)
+	rt.Register(&url.URL{Path: "/api/*"}, "GET", &Predicates{Host: "*.example.com", Headers: map[string]string{"X-Canary": "1"}}, handlerFunc)
+	return rt
+}
+
+type (
+	registration struct {
+		name       string
+		path       string
+		method     string
+		predicates *Predicates
+		isDefault  bool
+	}
+	match struct {
+		name   string
+		values RouteValues
+	}
+)
+
+func table(registrations []registration, matched *match) *RouteTable {
+	rt := NewRouteTable()
+	for _, registration := range registrations {
+		name := registration.name
+		target := rt
+		if registration.isDefault {
+			target = rt.Default()
+		}
+		target.Register(&url.URL{Path: registration.path}, registration.method, registration.predicates, func(w http.ResponseWriter, r *http.Request, rv RouteValues) {
+			matched.name = name
+			matched.values = rv
+		})
+	}
+	return rt
+}
+
+func TestFind(t *testing.T) {
+	precedence := []registration{
+		{name: "catch-all", path: "/a/*rest", method: "GET"},
+		{name: "optional", path: "/a/:id?", method: "GET"},
+		{name: "param", path: "/a/:id", method: "GET"},
+		{name: "constrained", path: "/a/:id<int>", method: "GET"},
+		{name: "static", path: "/a/b", method: "GET"},
+	}
+	constraints := []registration{
+		{name: "uuid", path: "/items/:id<uuid>", method: "GET"},
+		{name: "regex", path: "/tags/:tag<[a-z]+>?", method: "GET"},
+	}
+	hosts := []registration{
+		{name: "none", path: "/api/items", method: "GET"},
+		{name: "wildcard", path: "/api/*", method: "GET", predicates: &Predicates{Host: "*.example.com"}},
+		{name: "exact", path: "/api/*", method: "GET", predicates: &Predicates{Host: "tenant-a.example.com"}},
+	}
+	predicates := []registration{
+		{name: "plain", path: "/p", method: "GET"},
+		{name: "header", path: "/p", method: "GET", predicates: &Predicates{Headers: map[string]string{"X-Canary": "1"}}},
+		{name: "query", path: "/p", method: "GET", predicates: &Predicates{Query: map[string]string{"version": "2*"}}},
+		{name: "cookie", path: "/p", method: "GET", predicates: &Predicates{Cookies: map[string]string{"session": "beta-*"}}},
+		{name: "both", path: "/p", method: "GET", predicates: &Predicates{Headers: map[string]string{"X-Canary": "1"}, Query: map[string]string{"version": "2*"}}},
+	}
+	backtracking := []registration{
+		{name: "static", path: "/files/latest/meta", method: "GET"},
+		{name: "param", path: "/files/:name/raw", method: "GET"},
+		{name: "constrained", path: "/users/:id<int>/posts", method: "GET"},
+		{name: "fallback", path: "/users/:name/profile", method: "GET"},
+	}
+	defaults := []registration{
+		{name: "items", path: "/items", method: "GET"},
+		{name: "default", path: "/*path", method: "GET", isDefault: true},
+	}
+	tests := []struct {
+		name          string
+		registrations []registration
+		method        string
+		target        string
+		header        http.Header
+		want          match
+		wantErr       error
+	}{
+		{name: "static", registrations: precedence, method: "GET", target: "/a/b", want: match{name: "static", values: RouteValues{}}},
+		{name: "constrained", registrations: precedence, method: "GET", target: "/a/42", want: match{name: "constrained", values: RouteValues{"id": "42"}}},
+		{name: "param", registrations: precedence, method: "GET", target: "/a/x", want: match{name: "param", values: RouteValues{"id": "x"}}},
+		{name: "backtrack from static", registrations: backtracking, method: "GET", target: "/files/latest/raw", want: match{name: "param", values: RouteValues{"name": "latest"}}},
+		{name: "backtrack from constraint", registrations: backtracking, method: "GET", target: "/users/7/profile", want: match{name: "fallback", values: RouteValues{"name": "7"}}},
+		{name: "no backtrack", registrations: backtracking, method: "GET", target: "/users/7/posts", want: match{name: "constrained", values: RouteValues{"id": "7"}}},
+		{name: "uuid", registrations: constraints, method: "GET", target: "/items/6f1c2b9e-3a4d-4c5e-8f70-1a2b3c4d5e6f", want: match{name: "uuid", values: RouteValues{"id": "6f1c2b9e-3a4d-4c5e-8f70-1a2b3c4d5e6f"}}},
+		{name: "uuid mismatch", registrations: constraints, method: "GET", target: "/items/42", wantErr: NO_MATCH_FOUND},
+		{name: "optional constraint", registrations: constraints, method: "GET", target: "/tags/go", want: match{name: "regex", values: RouteValues{"tag": "go"}}},
+		{name: "optional constraint absent", registrations: constraints, method: "GET", target: "/tags", want: match{name: "regex", values: RouteValues{}}},
+		{name: "optional constraint mismatch", registrations: constraints, method: "GET", target: "/tags/Go1", wantErr: NO_MATCH_FOUND},
+		{name: "optional", registrations: precedence, method: "GET", target: "/a", want: match{name: "optional", values: RouteValues{}}},
+		{name: "catch-all", registrations: precedence, method: "GET", target: "/a/x/y/z", want: match{name: "catch-all", values: RouteValues{"rest": "x/y/z"}}},
+		{name: "unnamed catch-all", registrations: []registration{{name: "all", path: "/files/*", method: "GET"}}, method: "GET", target: "/files/a/b", want: match{name: "all", values: RouteValues{"*": "a/b"}}},
+		{name: "empty catch-all", registrations: []registration{{name: "all", path: "/files/*path", method: "GET"}}, method: "GET", target: "/files", want: match{name: "all", values: RouteValues{"path": ""}}},
+		{name: "exact host", registrations: hosts, method: "GET", target: "http://tenant-a.example.com/api/items", want: match{name: "exact", values: RouteValues{"*": "items"}}},
+		{name: "wildcard host", registrations: hosts, method: "GET", target: "http://tenant-b.example.com:8080/api/items", want: match{name: "wildcard", values: RouteValues{"*": "items"}}},
+		{name: "no host", registrations: hosts, method: "GET", target: "http://example.org/api/items", want: match{name: "none", values: RouteValues{}}},
+		{name: "wildcard host excludes apex", registrations: hosts, method: "GET", target: "http://example.com/api/other", wantErr: NO_MATCH_FOUND},
+		{name: "no predicates", registrations: predicates, method: "GET", target: "/p", want: match{name: "plain", values: RouteValues{}}},
+		{name: "header", registrations: predicates, method: "GET", target: "/p", header: http.Header{"X-Canary": {"1"}}, want: match{name: "header", values: RouteValues{}}},
+		{name: "query", registrations: predicates, method: "GET", target: "/p?version=2.1", want: match{name: "query", values: RouteValues{}}},
+		{name: "query mismatch", registrations: predicates, method: "GET", target: "/p?version=1.0", want: match{name: "plain", values: RouteValues{}}},
+		{name: "cookie", registrations: predicates, method: "GET", target: "/p", header: http.Header{"Cookie": {"session=beta-42"}}, want: match{name: "cookie", values: RouteValues{}}},
+		{name: "more predicates", registrations: predicates, method: "GET", target: "/p?version=2", header: http.Header{"X-Canary": {"1"}}, want: match{name: "both", values: RouteValues{}}},
+		{name: "not allowed", registrations: precedence, method: "POST", target: "/a/b", wantErr: NOT_ALLOWED},
+		{name: "default", registrations: defaults, method: "GET", target: "/other/path", want: match{name: "default", values: RouteValues{"path": "other/path"}}},
+		{name: "not allowed before default", registrations: defaults, method: "POST", target: "/items", wantErr: NOT_ALLOWED},
+		{name: "not allowed in default", registrations: defaults, method: "POST", target: "/other", wantErr: NOT_ALLOWED},
+		{name: "only default", registrations: defaults[1:], method: "GET", target: "/", want: match{name: "default", values: RouteValues{"path": ""}}},
+		{name: "not found", registrations: precedence, method: "GET", target: "/b", wantErr: NO_MATCH_FOUND},
+		{name: "too long", registrations: []registration{{name: "param", path: "/a/:id", method: "GET"}}, method: "GET", target: "/a/x/y", wantErr: NO_MATCH_FOUND},
+		{name: "empty table", method: "GET", target: "/a", wantErr: NO_URL_REGISTERED},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var matched match
+			rt := table(test.registrations, &matched)
+			r := httptest.NewRequest(test.method, test.target, nil)
+			for key, values := range test.header {
+				r.Header[key] = values
+			}
+			handlerFunc, err := rt.Find(r)
+			if err != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if test.wantErr != nil {
+				return
+			}
+			handlerFunc(httptest.NewRecorder(), r)
+			if !reflect.DeepEqual(matched, test.want) {
+				t.Fatalf("expected %v, got %v", test.want, matched)
+			}
+		})
+	}
+}
+
+func TestConcurrentRegister(t *testing.T) {
+	rt := NewRouteTable()
+	handlerFunc := func(http.ResponseWriter, *http.Request, RouteValues) {}
+	rt.Register(&url.URL{Path: "/items/:id"}, "GET", nil, handlerFunc)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			route, _ := rt.Register(&url.URL{Path: fmt.Sprintf("/service%d/*path", i)}, "GET", nil, handlerFunc)
+			route.SetMetadata(i)
+			rt.Default().Register(&url.URL{Path: fmt.Sprintf("/default%d", i)}, "GET", nil, handlerFunc)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		route, values, err := rt.Lookup(httptest.NewRequest("GET", "/items/1", nil), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if routeValues := route.Values(values); routeValues["id"] != "1" {
+			t.Fatalf("expected 1, got %v", routeValues)
+		}
+		for _, route := range rt.Routes() {
+			route.GetMetadata()
+		}
+	}
+	<-done
+	if routes := rt.Routes(); len(routes) != 201 {
+		t.Fatalf("expected 201, got %d", len(routes))
+	}
+}
+
+func TestAllow(t *testing.T) {
+	registrations := []registration{
+		{name: "get", path: "/items/:id", method: "GET"},
+		{name: "put", path: "/items/:id<int>", method: "PUT"},
+		{name: "delete", path: "/items/*rest", method: "DELETE"},
+		{name: "post", path: "/items", method: "POST"},
+		{name: "canary", path: "/items/:id", method: "PATCH", predicates: &Predicates{Headers: map[string]string{"X-Canary": "1"}}},
+		{name: "default", path: "/*", method: "GET", isDefault: true},
+	}
+	tests := []struct {
+		name   string
+		target string
+		header http.Header
+		want   []string
+	}{
+		{name: "every matching route", target: "/items/42", want: []string{"DELETE", "GET", "PUT"}},
+		{name: "constraint", target: "/items/x", want: []string{"DELETE", "GET"}},
+		{name: "predicate", target: "/items/x", header: http.Header{"X-Canary": {"1"}}, want: []string{"DELETE", "GET", "PATCH"}},
+		{name: "static", target: "/items", want: []string{"DELETE", "POST"}},
+		{name: "default", target: "/other", want: []string{"GET"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := table(registrations, &match{})
+			r := httptest.NewRequest("OPTIONS", test.target, nil)
+			for key, values := range test.header {
+				r.Header[key] = values
+			}
+			if allow := rt.Allow(r); !reflect.DeepEqual(allow, test.want) {
+				t.Fatalf("expected %v, got %v", test.want, allow)
+			}
+		})
+	}
+}
+
+func TestParseSegment(t *testing.T) {
+	tests := []struct {
+		name           string
+		segment        string
+		want           Segment
+		wantConstraint string
+		wantErr        bool
+	}{
+		{name: "static", segment: "a", want: Segment{Kind: SEGMENT_STATIC, Value: "a"}},
+		{name: "param", segment: ":id", want: Segment{Kind: SEGMENT_PARAM, Value: "id"}},
+		{name: "optional", segment: ":id?", want: Segment{Kind: SEGMENT_OPTIONAL, Value: "id"}},
+		{name: "catch-all", segment: "*", want: Segment{Kind: SEGMENT_CATCHALL, Value: CATCHALL}},
+		{name: "named catch-all", segment: "*path", want: Segment{Kind: SEGMENT_CATCHALL, Value: "path"}},
+		{name: "typed", segment: ":id<int>", want: Segment{Kind: SEGMENT_PARAM, Value: "id"}, wantConstraint: "^-?[0-9]+$"},
+		{name: "typed optional", segment: ":id<uint>?", want: Segment{Kind: SEGMENT_OPTIONAL, Value: "id"}, wantConstraint: "^[0-9]+$"},
+		{name: "regex", segment: ":slug<[a-z-]+>", want: Segment{Kind: SEGMENT_PARAM, Value: "slug"}, wantConstraint: "^(?:[a-z-]+)$"},
+		{name: "invalid regex", segment: ":id<[>", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			segment, err := ParseSegment(test.segment)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if test.wantErr {
+				return
+			}
+			constraint := ""
+			if segment.Constraint != nil {
+				constraint = segment.Constraint.String()
+			}
+			if segment.Kind != test.want.Kind || segment.Value != test.want.Value || constraint != test.wantConstraint {
+				t.Fatalf("expected %v %s, got %v %s", test.want, test.wantConstraint, segment, constraint)
+			}
+		})
+	}
+}
+
+func TestValidateRoute(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{name: "static", path: "/a/b"},
+		{name: "trailing catch-all", path: "/a/*path"},
+		{name: "trailing optionals", path: "/a/:b?/:c?"},
+		{name: "catch-all in the middle", path: "/a/*path/b", wantErr: true},
+		{name: "static after optional", path: "/a/:b?/c", wantErr: true},
+		{name: "param after optional", path: "/a/:b?/:c", wantErr: true},
+		{name: "invalid constraint", path: "/a/:id<[>", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := ValidateRoute(test.path); (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParseRoute(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		want    []Segment
+		wantErr bool
+	}{
+		{name: "static", path: "/a/b", want: []Segment{{Kind: SEGMENT_STATIC, Value: "a"}, {Kind: SEGMENT_STATIC, Value: "b"}}},
+		{name: "param", path: "/a/:id", want: []Segment{{Kind: SEGMENT_STATIC, Value: "a"}, {Kind: SEGMENT_PARAM, Value: "id"}}},
+		{name: "optional", path: "/:id?", want: []Segment{{Kind: SEGMENT_OPTIONAL, Value: "id"}}},
+		{name: "catch-all", path: "/*", want: []Segment{{Kind: SEGMENT_CATCHALL, Value: CATCHALL}}},
+		{name: "named catch-all", path: "/*path", want: []Segment{{Kind: SEGMENT_CATCHALL, Value: "path"}}},
+		{name: "invalid constraint", path: "/a/:id<[>", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			route, err := ParseRoute(&url.URL{Path: test.path}, "GET", nil)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if test.wantErr {
+				if _, err := NewRouteTable().Register(&url.URL{Path: test.path}, "GET", nil, nil); err == nil {
+					t.Fatalf("expected Register to fail")
+				}
+				return
+			}
+			if !reflect.DeepEqual(route.segments, test.want) {
+				t.Fatalf("expected %v, got %v", test.want, route.segments)
+			}
+		})
+	}
+}
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		value   string
+		want    bool
+	}{
+		{name: "exact", pattern: "beta", value: "beta", want: true},
+		{name: "exact mismatch", pattern: "beta", value: "beta-1"},
+		{name: "prefix", pattern: "beta-*", value: "beta-1", want: true},
+		{name: "suffix", pattern: "*.json", value: "a.json", want: true},
+		{name: "infix", pattern: "a*b*c", value: "a-b-c", want: true},
+		{name: "infix order", pattern: "a*b*c", value: "a-c-b"},
+		{name: "any", pattern: "*", value: "", want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if matched := Glob(test.pattern, test.value); matched != test.want {
+				t.Fatalf("expected %v, got %v", test.want, matched)
+			}
+		})
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		host    string
+		want    bool
+	}{
+		{name: "exact", pattern: "api.example.com", host: "api.example.com", want: true},
+		{name: "port", pattern: "api.example.com", host: "api.example.com:8443", want: true},
+		{name: "case insensitive", pattern: "API.example.com", host: "api.EXAMPLE.com.", want: true},
+		{name: "wildcard", pattern: "*.example.com", host: "a.b.example.com", want: true},
+		{name: "wildcard apex", pattern: "*.example.com", host: "example.com"},
+		{name: "wildcard suffix attack", pattern: "*.example.com", host: "evilexample.com"},
+		{name: "other", pattern: "api.example.com", host: "web.example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if matched := MatchHost(test.pattern, test.host); matched != test.want {
+				t.Fatalf("expected %v, got %v", test.want, matched)
+			}
+		})
+	}
+}
+
+func benchmarkLookup(b *testing.B, r *http.Request, want string, wantErr error) {
+	rt := catalogue()
+	var buffer [8]string
+	route, _, err := rt.Lookup(r, buffer[:0])
+	if err != wantErr {
+		b.Fatalf("expected error %v, got %v", wantErr, err)
+	}
+	if route != nil && route.GetPath() != want {
+		b.Fatalf("expected %s, got %s", want, route.GetPath())
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rt.Lookup(r, buffer[:0])
+	}
+}
+
+func BenchmarkLookupStatic(b *testing.B) {
+	r := httptest.NewRequest("GET", "/api/v1/service37/items", nil)
+	benchmarkLookup(b, r, "/api/v1/service37/items", nil)
+}
+
+func BenchmarkLookupParam(b *testing.B) {
+	r := httptest.NewRequest("PUT", "/api/v1/service37/items/shoes", nil)
+	benchmarkLookup(b, r, "/api/v1/service37/items/:slug", nil)
+}
+
+func BenchmarkLookupConstrainedParams(b *testing.B) {
+	r := httptest.NewRequest("GET", "/api/v1/service37/items/6f1c2b9e-3a4d-4c5e-8f70-1a2b3c4d5e6f/comments/42", nil)
+	benchmarkLookup(b, r, "/api/v1/service37/items/:id<uuid>/comments/:comment<int>", nil)
+}
+
+func BenchmarkLookupOptional(b *testing.B) {
+	r := httptest.NewRequest("GET", "/api/v1/service37/search", nil)
+	benchmarkLookup(b, r, "/api/v1/service37/search/:term?", nil)
+}
+
+func BenchmarkLookupCatchAll(b *testing.B) {
+	r := httptest.NewRequest("GET", "/api/v1/service37/files/docs/2024/report.pdf", nil)
+	benchmarkLookup(b, r, "/api/v1/service37/files/*path", nil)
+}
+
+func BenchmarkLookupHost(b *testing.B) {
+	r := httptest.NewRequest("GET", "http://tenant-a.example.com/api/v1/service37/items", nil)
+	benchmarkLookup(b, r, "/api/*", nil)
+}
+
+func BenchmarkLookupHeader(b *testing.B) {
+	r := httptest.NewRequest("GET", "http://tenant-b.example.com/api/v1/service37/items", nil)
+	r.Header.Set("X-Canary", "1")
+	benchmarkLookup(b, r, "/api/*", nil)
+}
+
+func BenchmarkLookupNotFound(b *testing.B) {
+	r := httptest.NewRequest("GET", "/api/v2/service37/items", nil)
+	benchmarkLookup(b, r, "", NO_MATCH_FOUND)
+}
+
+func BenchmarkLookupNotAllowed(b *testing.B) {
+	r := httptest.NewRequest("PATCH", "/api/v1/service37/items", nil)
+	benchmarkLookup(b, r, "", NOT_ALLOWED)
+}
+
+func BenchmarkFind(b *testing.B) {
+	rt := catalogue()
+	r := httptest.NewRequest("GET", "/api/v1/service37/items/6f1c2b9e-3a4d-4c5e-8f70-1a2b3c4d5e6f/comments/42", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rt.Find(r)
+	}
+}
+
+func BenchmarkRegister(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		catalogue()
+	}
+}
@@ -0,0 +1,191 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+type (
+	// Options configures the HTTP transport used to reach a backend or a
+	// filter. Zero values keep the defaults of http.DefaultTransport.
+	Options struct {
+		MaxIdleConns          int
+		MaxIdleConnsPerHost   int
+		MaxConnsPerHost       int
+		IdleConnTimeout       time.Duration
+		KeepAlive             time.Duration
+		DialTimeout           time.Duration
+		TLSHandshakeTimeout   time.Duration
+		ResponseHeaderTimeout time.Duration
+		DisableHTTP2          bool
+		CAFile                string
+		CertFile              string
+		KeyFile               string
+		ServerName            string
+		InsecureSkipVerify    bool
+	}
+	key struct {
+		Options
+		fingerprint string
+	}
+	entry struct {
+		key    key
+		client *http.Client
+		refs   int
+	}
+)
+
+const (
+	DIAL_TIMEOUT          = 30 * time.Second
+	KEEP_ALIVE            = 30 * time.Second
+	MAX_IDLE_CONNS        = 100
+	IDLE_CONN_TIMEOUT     = 90 * time.Second
+	TLS_HANDSHAKE_TIMEOUT = 10 * time.Second
+)
+
+var (
+	_clients map[key]*entry
+	_entries map[*http.Client]*entry
+	_mut     sync.Mutex
+)
+
+func init() {
+	_clients = make(map[key]*entry)
+	_entries = make(map[*http.Client]*entry)
+}
+
+// GetClient returns a client for options, shared with every other holder of
+// identical options. Certificate files are part of the identity, so a
+// rotated file gets a new client on the next reload.
+func GetClient(options *Options) (*http.Client, error) {
+	if options == nil {
+		options = &Options{}
+	}
+	k := key{Options: *options, fingerprint: fingerprint(options)}
+	_mut.Lock()
+	defer _mut.Unlock()
+	e, ok := _clients[k]
+	if !ok {
+		transport, err := New(options)
+		if err != nil {
+			return nil, err
+		}
+		e = &entry{
+			key:    k,
+			client: &http.Client{Transport: transport},
+		}
+		_clients[k] = e
+		_entries[e.client] = e
+	}
+	e.refs++
+	return e.client, nil
+}
+
+// ReleaseClient gives back a client from GetClient, closing its idle
+// connections once nobody holds it.
+func ReleaseClient(client *http.Client) error {
+	_mut.Lock()
+	defer _mut.Unlock()
+	e, ok := _entries[client]
+	if !ok {
+		return nil
+	}
+	e.refs--
+	if e.refs > 0 {
+		return nil
+	}
+	delete(_entries, client)
+	if _clients[e.key] == e {
+		delete(_clients, e.key)
+	}
+	client.CloseIdleConnections()
+	return nil
+}
+
+func New(options *Options) (*http.Transport, error) {
+	dialer := &net.Dialer{
+		Timeout:   or(options.DialTimeout, DIAL_TIMEOUT),
+		KeepAlive: or(options.KeepAlive, KEEP_ALIVE),
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     !options.DisableHTTP2,
+		MaxIdleConns:          MAX_IDLE_CONNS,
+		MaxIdleConnsPerHost:   options.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       options.MaxConnsPerHost,
+		IdleConnTimeout:       or(options.IdleConnTimeout, IDLE_CONN_TIMEOUT),
+		TLSHandshakeTimeout:   or(options.TLSHandshakeTimeout, TLS_HANDSHAKE_TIMEOUT),
+		ResponseHeaderTimeout: options.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+	if options.MaxIdleConns != 0 {
+		transport.MaxIdleConns = options.MaxIdleConns
+	}
+	if options.DisableHTTP2 {
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	tlsConfig, err := TLSConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+func TLSConfig(options *Options) (*tls.Config, error) {
+	if len(options.CAFile) == 0 && len(options.CertFile) == 0 && len(options.ServerName) == 0 && !options.InsecureSkipVerify {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         options.ServerName,
+		InsecureSkipVerify: options.InsecureSkipVerify,
+	}
+	if len(options.CAFile) != 0 {
+		pem, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", options.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(options.CertFile) != 0 || len(options.KeyFile) != 0 {
+		cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func fingerprint(options *Options) string {
+	out := ""
+	for _, file := range []string{options.CAFile, options.CertFile, options.KeyFile} {
+		if len(file) == 0 {
+			continue
+		}
+		stat, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		out += fmt.Sprintf("%s:%d:%d;", file, stat.Size(), stat.ModTime().UnixNano())
+	}
+	return out
+}
+
+func or(value time.Duration, fallback time.Duration) time.Duration {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,168 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCA(t *testing.T, path string, serial int64) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetClient(t *testing.T) {
+	a, err := GetClient(&Options{MaxConnsPerHost: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GetClient(&Options{MaxConnsPerHost: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GetClient(&Options{MaxConnsPerHost: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ReleaseClient(other)
+	if a != b {
+		t.Fatalf("expected identical options to share a client")
+	}
+	if a == other {
+		t.Fatalf("expected different options to get different clients")
+	}
+	ReleaseClient(a)
+	c, err := GetClient(&Options{MaxConnsPerHost: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != a {
+		t.Fatalf("expected a client to be kept while it is held")
+	}
+	ReleaseClient(b)
+	ReleaseClient(c)
+	d, err := GetClient(&Options{MaxConnsPerHost: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ReleaseClient(d)
+	if d == a {
+		t.Fatalf("expected a new client once every holder released it")
+	}
+}
+
+func TestGetClientRotation(t *testing.T) {
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	writeCA(t, ca, 1)
+	a, err := GetClient(&Options{CAFile: ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ReleaseClient(a)
+	writeCA(t, ca, 2)
+	os.Chtimes(ca, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	b, err := GetClient(&Options{CAFile: ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ReleaseClient(b)
+	if a == b {
+		t.Fatalf("expected a rotated certificate file to get a new client")
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name             string
+		options          Options
+		wantMaxIdleConns int
+		wantIdleTimeout  time.Duration
+		wantHTTP2        bool
+	}{
+		{name: "defaults", wantMaxIdleConns: MAX_IDLE_CONNS, wantIdleTimeout: IDLE_CONN_TIMEOUT, wantHTTP2: true},
+		{name: "custom", options: Options{MaxIdleConns: 10, IdleConnTimeout: time.Second}, wantMaxIdleConns: 10, wantIdleTimeout: time.Second, wantHTTP2: true},
+		{name: "http/1.1 only", options: Options{DisableHTTP2: true}, wantMaxIdleConns: MAX_IDLE_CONNS, wantIdleTimeout: IDLE_CONN_TIMEOUT},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transport, err := New(&test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if transport.MaxIdleConns != test.wantMaxIdleConns {
+				t.Fatalf("expected %d, got %d", test.wantMaxIdleConns, transport.MaxIdleConns)
+			}
+			if transport.IdleConnTimeout != test.wantIdleTimeout {
+				t.Fatalf("expected %v, got %v", test.wantIdleTimeout, transport.IdleConnTimeout)
+			}
+			if http2 := transport.ForceAttemptHTTP2 && transport.TLSNextProto == nil; http2 != test.wantHTTP2 {
+				t.Fatalf("expected http2 %v, got %v", test.wantHTTP2, http2)
+			}
+		})
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "ca.pem")
+	writeCA(t, ca, 1)
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("none"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		options    Options
+		wantConfig bool
+		wantRoots  bool
+		wantErr    bool
+	}{
+		{name: "none"},
+		{name: "server name", options: Options{ServerName: "backend"}, wantConfig: true},
+		{name: "insecure", options: Options{InsecureSkipVerify: true}, wantConfig: true},
+		{name: "ca", options: Options{CAFile: ca}, wantConfig: true, wantRoots: true},
+		{name: "missing ca", options: Options{CAFile: filepath.Join(dir, "missing.pem")}, wantErr: true},
+		{name: "ca without certificates", options: Options{CAFile: empty}, wantErr: true},
+		{name: "missing key pair", options: Options{CertFile: filepath.Join(dir, "missing.pem")}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := TLSConfig(&test.options)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if (config != nil) != test.wantConfig {
+				t.Fatalf("expected config %v, got %v", test.wantConfig, config)
+			}
+			if config != nil && (config.RootCAs != nil) != test.wantRoots {
+				t.Fatalf("expected roots %v, got %v", test.wantRoots, config.RootCAs)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package watch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	Watcher struct {
+		Path     string
+		Interval time.Duration
+		hash     string
+	}
+)
+
+func New(path string, interval time.Duration) *Watcher {
+	watcher := new(Watcher)
+	watcher.Path = path
+	watcher.Interval = interval
+	if watcher.Interval == 0 {
+		watcher.Interval = time.Second * 5
+	}
+	return watcher
+}
+
+func Resolve(path string) (string, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !stat.IsDir() {
+		return path, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+	files := make([]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(name))
+		if ext != ".yml" && ext != ".yaml" {
+			continue
+		}
+		files = append(files, name)
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("no configuration file found in %s", path)
+	}
+	sort.Strings(files)
+	return filepath.Join(path, files[0]), nil
+}
+
+func Read(path string) ([]byte, error) {
+	file, err := Resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(file)
+}
+
+func (watcher *Watcher) Read() ([]byte, error) {
+	data, err := Read(watcher.Path)
+	if err != nil {
+		return nil, err
+	}
+	watcher.hash = hash(data)
+	return data, nil
+}
+
+func (watcher *Watcher) Watch(ctx context.Context, fn func([]byte), errFn func(error)) {
+	ticker := time.NewTicker(watcher.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			{
+				return
+			}
+		case <-ticker.C:
+			{
+				data, err := Read(watcher.Path)
+				if err != nil {
+					errFn(err)
+					continue
+				}
+				hash := hash(data)
+				if hash == watcher.hash {
+					continue
+				}
+				watcher.hash = hash
+				fn(data)
+			}
+		}
+	}
+}
+
+func hash(data []byte) string {
+	sha256 := sha256.Sum256(data)
+	return hex.EncodeToString(sha256[:])
+}
@@ -0,0 +1,103 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		want    string
+		wantErr bool
+	}{
+		{name: "first yaml file", files: []string{"b.yaml", "a.yml", "c.json"}, want: "a.yml"},
+		{name: "hidden files", files: []string{"..data.yml", ".a.yml", "b.yml"}, want: "b.yml"},
+		{name: "upper case extension", files: []string{"config.YAML"}, want: "config.YAML"},
+		{name: "no yaml file", files: []string{"config.json"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, file := range test.files {
+				if err := os.WriteFile(filepath.Join(dir, file), nil, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			file, err := Resolve(dir)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if !test.wantErr && file != filepath.Join(dir, test.want) {
+				t.Fatalf("expected %s, got %s", filepath.Join(dir, test.want), file)
+			}
+		})
+	}
+}
+
+func TestResolveFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := Resolve(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved != file {
+		t.Fatalf("expected %s, got %s", file, resolved)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(file, []byte("v1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	watcher := New(file, time.Millisecond*10)
+	if _, err := watcher.Read(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan string, 10)
+	errs := make(chan error, 10)
+	go watcher.Watch(ctx, func(data []byte) { changes <- string(data) }, func(err error) { errs <- err })
+
+	select {
+	case data := <-changes:
+		{
+			t.Fatalf("expected no change for unchanged content, got %s", data)
+		}
+	case <-time.After(time.Millisecond * 50):
+	}
+	if err := os.WriteFile(file, []byte("v2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-changes:
+		{
+			if data != "v2" {
+				t.Fatalf("expected v2, got %s", data)
+			}
+		}
+	case <-time.After(time.Second):
+		{
+			t.Fatalf("expected a change to be reported")
+		}
+	}
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-errs:
+	case <-time.After(time.Second):
+		{
+			t.Fatalf("expected a read error to be reported")
+		}
+	}
+}
@@ -82,9 +82,11 @@ func (c *Cache) ParseKey(r *http.Request, rv netio.RouteValues) (string, error)
 		hash := sha256.Sum(nil)
 		cacheKey = strings.ReplaceAll(cacheKey, "{body}", hex.EncodeToString(hash))
 	}
-	if strings.Contains(cacheKey, "{method}") {
-		cacheKey = strings.ReplaceAll(cacheKey, "{body}", r.Method)
+	for key, value := range r.Header {
+		cacheKey = strings.ReplaceAll(cacheKey, fmt.Sprintf("{[%s]}", strings.ToLower(key)), strings.Join(value, "-"))
 	}
+	cacheKey = strings.ReplaceAll(cacheKey, "{clientip}", r.Header.Get(netio.HEADER_CLIENT_IP))
+	cacheKey = strings.ReplaceAll(cacheKey, "{method}", r.Method)
 	return cacheKey, nil
 }
 
@@ -116,8 +118,8 @@ func (c *Cache) GetIsParallel() bool {
 	return false
 }
 
-func (f *Cache) GetContext() context.Context {
-	return context.TODO()
+func (f *Cache) GetContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithCancel(parent)
 }
 
 func (f *Cache) Build() ([]netio.Caller, error) {
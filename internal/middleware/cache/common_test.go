@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vedadiyan/iceberg/internal/common/netio"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		method   string
+		target   string
+		body     string
+		header   map[string]string
+		rv       netio.RouteValues
+		want     string
+	}{
+		{name: "route value", template: "user_{:id}", method: "GET", target: "/", rv: netio.RouteValues{"id": "42"}, want: "user_42"},
+		{name: "query", template: "page_{?page}", method: "GET", target: "/?page=1&page=2", want: "page_1-2"},
+		{name: "method", template: "{method}_{:id}", method: "POST", target: "/", rv: netio.RouteValues{"id": "42"}, want: "POST_42"},
+		{name: "method with body", template: "{method}_{body}", method: "PUT", target: "/", body: "hello", want: "PUT_2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{name: "client ip", template: "ip_{clientIp}", method: "GET", target: "/", header: map[string]string{netio.HEADER_CLIENT_IP: "203.0.113.7"}, want: "ip_203.0.113.7"},
+		{name: "header", template: "tenant_{[X-Tenant]}", method: "GET", target: "/", header: map[string]string{"X-Tenant": "acme"}, want: "tenant_acme"},
+		{name: "case insensitive", template: "{METHOD}_{:ID}", method: "GET", target: "/", rv: netio.RouteValues{"id": "42"}, want: "GET_42"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+			for key, value := range test.header {
+				r.Header.Set(key, value)
+			}
+			cache := Cache{KeyTemplate: test.template}
+			key, err := cache.ParseKey(r, test.rv)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if key != test.want {
+				t.Fatalf("expected %s, got %s", test.want, key)
+			}
+		})
+	}
+}
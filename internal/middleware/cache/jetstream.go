@@ -2,6 +2,8 @@ package cache
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"strings"
@@ -9,7 +11,7 @@ import (
 	"time"
 
 	"github.com/nats-io/nats.go"
-	"github.com/nats-io/nats.go/jetstream"
+	"github.com/vedadiyan/iceberg/internal/common/metrics"
 	"github.com/vedadiyan/iceberg/internal/common/netio"
 )
 
@@ -21,6 +23,8 @@ type (
 		kv     nats.KeyValue
 		get    *JetStreamGet
 		set    *JetStreamSet
+		once   sync.Once
+		err    error
 	}
 	JetStreamGet struct {
 		*JetStream
@@ -31,24 +35,51 @@ type (
 )
 
 var (
+	_conns map[string]*nats.Conn
+	_refs  map[string]int
 	_kvs   map[string]nats.KeyValue
 	_kvMut sync.RWMutex
 )
 
 func init() {
+	_conns = make(map[string]*nats.Conn)
+	_refs = make(map[string]int)
 	_kvs = make(map[string]nats.KeyValue)
 }
 
+// GetKV returns the bucket at url, creating it when missing, and takes a
+// reference on the connection that is given back by ReleaseKV. Nothing is
+// registered unless the bucket is ready.
 func GetKV(url string, bucket string, ttl time.Duration) (nats.KeyValue, error) {
 	_kvMut.Lock()
 	defer _kvMut.Unlock()
-	if conn, ok := _kvs[url]; ok {
-		return conn, nil
-	}
-	conn, err := nats.Connect(url)
-	if err != nil {
-		return nil, err
+	conn, connected := _conns[url]
+	if !connected {
+		c, err := nats.Connect(url)
+		if err != nil {
+			return nil, err
+		}
+		conn = c
+	}
+	key := fmt.Sprintf("%s/%s", url, bucket)
+	kv, ok := _kvs[key]
+	if !ok {
+		var err error
+		kv, err = createKV(conn, bucket, ttl)
+		if err != nil {
+			if !connected {
+				conn.Close()
+			}
+			return nil, err
+		}
+		_kvs[key] = kv
 	}
+	_conns[url] = conn
+	_refs[url]++
+	return kv, nil
+}
+
+func createKV(conn *nats.Conn, bucket string, ttl time.Duration) (nats.KeyValue, error) {
 	js, err := conn.JetStream()
 	if err != nil {
 		return nil, err
@@ -57,12 +88,37 @@ func GetKV(url string, bucket string, ttl time.Duration) (nats.KeyValue, error)
 		Bucket: bucket,
 		TTL:    ttl,
 	})
-	if err != nil && err != jetstream.ErrBucketExists {
+	if err == nil {
+		return kv, nil
+	}
+	kv, bucketErr := js.KeyValue(bucket)
+	if bucketErr != nil {
 		return nil, err
 	}
 	return kv, nil
 }
 
+func ReleaseKV(url string) error {
+	_kvMut.Lock()
+	defer _kvMut.Unlock()
+	conn, ok := _conns[url]
+	if !ok {
+		return nil
+	}
+	_refs[url]--
+	if _refs[url] > 0 {
+		return nil
+	}
+	for key := range _kvs {
+		if strings.HasPrefix(key, url+"/") {
+			delete(_kvs, key)
+		}
+	}
+	delete(_conns, url)
+	delete(_refs, url)
+	return netio.Drain(conn)
+}
+
 func NewJetStream(c *Cache) (*JetStream, error) {
 	host := c.Address.Host
 	if strings.HasPrefix(host, "[[") && strings.HasSuffix(host, "]]") {
@@ -104,6 +160,13 @@ func (jetStream *JetStream) Set() netio.Caller {
 	return jetStream.set
 }
 
+func (jetStream *JetStream) Close() error {
+	jetStream.once.Do(func() {
+		jetStream.err = ReleaseKV(jetStream.Host)
+	})
+	return jetStream.err
+}
+
 func (f *JetStreamGet) GetLevel() netio.Level {
 	return netio.LEVEL_PRE
 }
@@ -120,14 +183,18 @@ func (f *JetStreamGet) Call(ctx context.Context, rv netio.RouteValues, _ netio.C
 	value, err := f.kv.Get(key)
 	if err != nil {
 		if err == nats.ErrKeyNotFound {
+			metrics.CacheOperations.WithLabelValues("miss").Inc()
 			return netio.CONTINUE, nil, nil
 		}
+		metrics.CacheOperations.WithLabelValues("error").Inc()
 		return netio.TERM, nil, netio.NewError(err.Error(), http.StatusInternalServerError)
 	}
 	res, err := Unmarshal(value.Value())
 	if err != nil {
+		metrics.CacheOperations.WithLabelValues("error").Inc()
 		return netio.TERM, nil, netio.NewError(err.Error(), http.StatusInternalServerError)
 	}
+	metrics.CacheOperations.WithLabelValues("hit").Inc()
 	return netio.TERM, res, nil
 }
 
@@ -154,7 +221,21 @@ func (f *JetStreamSet) Call(ctx context.Context, rv netio.RouteValues, c netio.C
 	}
 	_, err = f.kv.Put(key, data)
 	if err != nil {
+		metrics.CacheOperations.WithLabelValues("error").Inc()
 		return netio.TERM, nil, netio.NewError(err.Error(), http.StatusInternalServerError)
 	}
+	metrics.CacheOperations.WithLabelValues("store").Inc()
 	return netio.CONTINUE, nil, nil
 }
+
+func Check() error {
+	_kvMut.RLock()
+	defer _kvMut.RUnlock()
+	errs := make([]error, 0)
+	for url, conn := range _conns {
+		if status := conn.Status(); status != nats.CONNECTED {
+			errs = append(errs, fmt.Errorf("nats %s is %s", url, status.String()))
+		}
+	}
+	return errors.Join(errs...)
+}
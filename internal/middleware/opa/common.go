@@ -79,6 +79,6 @@ func (opa *Opa) OverrideResponseUpdaters([]netio.ResponseUpdater) {
 
 }
 
-func (opa *Opa) GetContext() context.Context {
-	return context.TODO()
+func (opa *Opa) GetContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithCancel(parent)
 }
@@ -3,6 +3,7 @@ package opa
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
+	"github.com/vedadiyan/iceberg/internal/common/metrics"
 	"github.com/vedadiyan/iceberg/internal/common/netio"
 )
 
@@ -28,6 +30,7 @@ type (
 
 var (
 	_conns   map[string]*nats.Conn
+	_refs    map[string]int
 	_connMut sync.RWMutex
 )
 
@@ -36,19 +39,43 @@ const (
 	POLICY_TYPE_REMOTE PolicyType = "remote"
 )
 
+func init() {
+	_conns = make(map[string]*nats.Conn)
+	_refs = make(map[string]int)
+}
+
 func GetConn(url string) (*nats.Conn, error) {
 	_connMut.Lock()
 	defer _connMut.Unlock()
 	if conn, ok := _conns[url]; ok {
+		_refs[url]++
 		return conn, nil
 	}
 	conn, err := nats.Connect(url)
 	if err != nil {
 		return nil, err
 	}
+	_conns[url] = conn
+	_refs[url]++
 	return conn, nil
 }
 
+func ReleaseConn(url string) error {
+	_connMut.Lock()
+	defer _connMut.Unlock()
+	conn, ok := _conns[url]
+	if !ok {
+		return nil
+	}
+	_refs[url]--
+	if _refs[url] > 0 {
+		return nil
+	}
+	delete(_conns, url)
+	delete(_refs, url)
+	return netio.Drain(conn)
+}
+
 func GetKV(conn *nats.Conn, bucket string) (nats.KeyValue, error) {
 	js, err := conn.JetStream()
 	if err != nil {
@@ -81,7 +108,7 @@ func NewOpaNats(opa *Opa) (*OpaNats, error) {
 	opaNats.conn = conn
 	kv, err := GetKV(conn, "OPA_STORE")
 	if err != nil {
-		return nil, err
+		return nil, errors.Join(err, ReleaseConn(host))
 	}
 	policies := make([]string, 0)
 	for p, t := range opa.Policies {
@@ -90,7 +117,7 @@ func NewOpaNats(opa *Opa) (*OpaNats, error) {
 			policies = append(policies, name)
 			_, err := kv.Put(name, []byte(os.Getenv(p)))
 			if err != nil {
-				return nil, err
+				return nil, errors.Join(err, ReleaseConn(host))
 			}
 			continue
 		}
@@ -100,16 +127,21 @@ func NewOpaNats(opa *Opa) (*OpaNats, error) {
 	return opaNats, nil
 }
 
+func (opaNats *OpaNats) Close() error {
+	return ReleaseConn(opaNats.Host)
+}
+
 func (opaNats *OpaNats) Eval(r *http.Request, rv netio.RouteValues) (bool, string, error) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		return false, "", err
 	}
 	data := map[string]any{
-		"path":    rv,
-		"headers": r.Header,
-		"method":  r.Method,
-		"data":    body,
+		"path":     rv,
+		"headers":  r.Header,
+		"method":   r.Method,
+		"clientIp": r.Header.Get(netio.HEADER_CLIENT_IP),
+		"data":     body,
 	}
 	inputs, err := json.Marshal(data)
 	if err != nil {
@@ -169,10 +201,25 @@ func (opaNats *OpaNats) Call(ctc context.Context, rv netio.RouteValues, c netio.
 	}
 	res, msg, err := opaNats.Eval(r, rv)
 	if err != nil {
+		metrics.OpaDecisions.WithLabelValues(string(opaNats.Type), "error").Inc()
 		return true, nil, netio.NewError(err.Error(), 500)
 	}
 	if !res {
-		return true, nil, netio.NewError(msg, 400)
+		metrics.OpaDecisions.WithLabelValues(string(opaNats.Type), "deny").Inc()
+		return true, nil, netio.NewStatusError(msg, 400)
 	}
+	metrics.OpaDecisions.WithLabelValues(string(opaNats.Type), "allow").Inc()
 	return false, nil, nil
 }
+
+func Check() error {
+	_connMut.RLock()
+	defer _connMut.RUnlock()
+	errs := make([]error, 0)
+	for url, conn := range _conns {
+		if status := conn.Status(); status != nats.CONNECTED {
+			errs = append(errs, fmt.Errorf("nats %s is %s", url, status.String()))
+		}
+	}
+	return errors.Join(errs...)
+}